@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Address book: the addresses of the nodes heard of through the network (address gossip)
+// or the config file, persisted in the `peers` bucket of the node's database so that
+// a restarted node does not depend on its seed nodes anymore.
+
+const (
+	PEERS_BUCKET      = "peers"
+	MAX_ADDR_PER_MSG  = 100                // Largest number of addresses sent in one RES_ADDR.
+	MAX_ADDR_AGE      = 7 * 24 * time.Hour // Addresses not seen for longer are not gossiped.
+	MAX_ADDR_FAILURES = 10                 // Consecutive failed connections before forgetting an address.
+	ADDR_RETRY_DELAY  = 10 * time.Minute   // Delay before retrying an address which failed.
+	ADDR_FUTURE_SLACK = 10 * time.Minute   // Timestamps further in the future are clamped to now.
+)
+
+// KnownAddr is one address of the book.
+type KnownAddr struct {
+	Address   string `json:"address"`            // Listening address of the node (host:port).
+	Timestamp int64  `json:"timestamp"`          // Unix time the node was last known to be up (0 = never).
+	LastTry   int64  `json:"last_try,omitempty"` // Unix time of the last failed connection.
+	Failures  int    `json:"failures,omitempty"` // Consecutive failed connections.
+}
+
+// AddrBook holds the known addresses, in memory and in the node's database.
+type AddrBook struct {
+	mu    sync.Mutex
+	db    *bolt.DB
+	addrs map[string]*KnownAddr
+}
+
+// Address book of the running node.
+var addrBook *AddrBook
+
+// Utility functions start from here.
+
+func setAddrBook(ab *AddrBook) {
+	addrBook = ab
+}
+
+func getAddrBook() *AddrBook {
+	return addrBook
+}
+
+// newAddrBook loads the address book stored in the given database (nil = in memory only).
+func newAddrBook(db *bolt.DB) (*AddrBook, error) {
+	ab := &AddrBook{db: db, addrs: make(map[string]*KnownAddr)}
+	if db == nil {
+		return ab, nil
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(PEERS_BUCKET))
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(key, value []byte) error {
+			addr := new(KnownAddr)
+			if err := json.Unmarshal(value, addr); err != nil {
+				Warning.Printf("Skip malformed address %s: %v", key, err)
+				return nil
+			}
+			ab.addrs[addr.Address] = addr
+			return nil
+		})
+	})
+	return ab, err
+}
+
+// AddrBook's methods:
+
+// Add merges the given addresses (eg: received from a peer) into the book.
+// The local node's address and malformed addresses are ignored.
+func (ab *AddrBook) Add(addrs []KnownAddr) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	now := time.Now()
+	var updated []*KnownAddr
+	for _, addr := range addrs {
+		if !isDialableAddr(addr.Address) || addr.Address == getLocalNode().Address {
+			continue
+		}
+		if addr.Timestamp > now.Add(ADDR_FUTURE_SLACK).Unix() {
+			addr.Timestamp = now.Unix()
+		}
+
+		known, ok := ab.addrs[addr.Address]
+		if !ok {
+			known = &KnownAddr{Address: addr.Address}
+			ab.addrs[addr.Address] = known
+		} else if addr.Timestamp <= known.Timestamp {
+			continue
+		}
+		known.Timestamp = addr.Timestamp
+		updated = append(updated, known)
+	}
+	ab.save(updated...)
+}
+
+// Good records a successful connection to the address.
+func (ab *AddrBook) Good(address string) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	known, ok := ab.addrs[address]
+	if !ok {
+		known = &KnownAddr{Address: address}
+		ab.addrs[address] = known
+	}
+	known.Timestamp, known.LastTry, known.Failures = time.Now().Unix(), 0, 0
+	ab.save(known)
+}
+
+// Attempt records a failed connection to the address, which is forgotten
+// after MAX_ADDR_FAILURES consecutive failures.
+func (ab *AddrBook) Attempt(address string) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	known, ok := ab.addrs[address]
+	if !ok {
+		return
+	}
+	known.LastTry = time.Now().Unix()
+	known.Failures++
+	if known.Failures >= MAX_ADDR_FAILURES {
+		delete(ab.addrs, address)
+		ab.remove(address)
+		return
+	}
+	ab.save(known)
+}
+
+// Forget removes the address from the book (eg: a neighbor removed from the config).
+func (ab *AddrBook) Forget(address string) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	if _, ok := ab.addrs[address]; ok {
+		delete(ab.addrs, address)
+		ab.remove(address)
+	}
+}
+
+// Retry clears the failures of the address (eg: the node just connected to us)
+// and returns true if it had any.
+func (ab *AddrBook) Retry(address string) bool {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	known, ok := ab.addrs[address]
+	if !ok || known.Failures == 0 {
+		return false
+	}
+	known.LastTry, known.Failures = 0, 0
+	ab.save(known)
+	return true
+}
+
+// Sample returns up to `n` random addresses seen recently, except the excluded one.
+func (ab *AddrBook) Sample(n int, exclude string) []KnownAddr {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	minTimestamp := time.Now().Add(-MAX_ADDR_AGE).Unix()
+	var good []KnownAddr
+	for _, addr := range ab.addrs {
+		if addr.Address != exclude && addr.Failures == 0 && addr.Timestamp >= minTimestamp {
+			good = append(good, KnownAddr{Address: addr.Address, Timestamp: addr.Timestamp})
+		}
+	}
+	rand.Shuffle(len(good), func(i, j int) { good[i], good[j] = good[j], good[i] })
+	if len(good) > n {
+		good = good[:n]
+	}
+	return good
+}
+
+// Candidates returns the addresses worth a connection attempt, the most recently seen first.
+// The excluded addresses (eg: already connected) and the ones which failed recently are skipped.
+func (ab *AddrBook) Candidates(exclude map[string]bool) []string {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	retryAfter := time.Now().Add(-ADDR_RETRY_DELAY).Unix()
+	var candidates []*KnownAddr
+	for _, addr := range ab.addrs {
+		if !exclude[addr.Address] && (addr.Failures == 0 || addr.LastTry < retryAfter) {
+			candidates = append(candidates, addr)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Timestamp > candidates[j].Timestamp
+	})
+
+	addresses := make([]string, len(candidates))
+	for idx, addr := range candidates {
+		addresses[idx] = addr.Address
+	}
+	return addresses
+}
+
+// Len returns the number of known addresses.
+func (ab *AddrBook) Len() int {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	return len(ab.addrs)
+}
+
+// save persists the given addresses (the lock must be held).
+func (ab *AddrBook) save(addrs ...*KnownAddr) {
+	if ab.db == nil || len(addrs) == 0 {
+		return
+	}
+	err := ab.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(PEERS_BUCKET))
+		for _, addr := range addrs {
+			value, err := json.Marshal(addr)
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put([]byte(addr.Address), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		Warning.Printf("Cannot save the address book: %v", err)
+	}
+}
+
+// remove deletes the address from the database (the lock must be held).
+func (ab *AddrBook) remove(address string) {
+	if ab.db == nil {
+		return
+	}
+	err := ab.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(PEERS_BUCKET)).Delete([]byte(address))
+	})
+	if err != nil {
+		Warning.Printf("Cannot save the address book: %v", err)
+	}
+}
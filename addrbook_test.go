@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddrBook(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{Network: Network{LocalNode: Node{Address: "localhost:3331"}}})
+	dbPath := filepath.Join(t.TempDir(), DB_FILE)
+	db, err := openDB(dbPath)
+	if err != nil {
+		t.Fatalf("openDB failed: %v", err)
+	}
+	ab, err := newAddrBook(db)
+	if err != nil {
+		t.Fatalf("newAddrBook failed: %v", err)
+	}
+
+	now := time.Now().Unix()
+	ab.Add([]KnownAddr{
+		{Address: "localhost:3331", Timestamp: now}, // Local node.
+		{Address: "not-an-address", Timestamp: now},
+		{Address: "localhost:3332", Timestamp: now},
+		{Address: "localhost:3333"}, // Heard of, never reached.
+		{Address: "localhost:3334", Timestamp: now - int64(2*MAX_ADDR_AGE/time.Second)},
+	})
+	if ab.Len() != 3 {
+		t.Fatalf("Expected 3 addresses, got %d", ab.Len())
+	}
+	if sample := ab.Sample(MAX_ADDR_PER_MSG, ""); len(sample) != 1 || sample[0].Address != "localhost:3332" {
+		t.Errorf("Only the recently seen address should be gossiped, got %v", sample)
+	}
+	if sample := ab.Sample(MAX_ADDR_PER_MSG, "localhost:3332"); len(sample) != 0 {
+		t.Errorf("The requesting node should not get its own address, got %v", sample)
+	}
+
+	// Reached, a heard of address gets gossiped; a failing one is retried later only.
+	ab.Good("localhost:3333")
+	ab.Attempt("localhost:3334")
+	candidates := ab.Candidates(map[string]bool{"localhost:3332": true})
+	if len(candidates) != 1 || candidates[0] != "localhost:3333" {
+		t.Errorf("Unexpected candidates %v", candidates)
+	}
+	if !ab.Retry("localhost:3334") || len(ab.Candidates(nil)) != 3 {
+		t.Errorf("A failing address which reached us should be retried at once")
+	}
+	for i := 0; i < MAX_ADDR_FAILURES; i++ {
+		ab.Attempt("localhost:3332")
+	}
+
+	// The book survives a restart.
+	db.Close()
+	if db, err = openDB(dbPath); err != nil {
+		t.Fatalf("openDB failed: %v", err)
+	}
+	defer db.Close()
+	restored, _ := newAddrBook(db)
+	if restored.Len() != 2 || len(restored.Sample(MAX_ADDR_PER_MSG, "")) != 1 {
+		t.Errorf("Expected 2 addresses (1 good) after reopening, got %d", restored.Len())
+	}
+}
@@ -31,7 +31,8 @@ func base58Encode(input []byte) []byte {
 	}
 
 	reverseBytes(result)
-	for b := range input {
+	// Every leading zero byte is encoded as the first character of the alphabet.
+	for _, b := range input {
 		if b == 0x00 {
 			result = append([]byte{alphabet[0]}, result...)
 		} else {
@@ -46,10 +47,11 @@ func base58Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
-			zeroBytes++
+	for _, b := range input {
+		if b != alphabet[0] {
+			break
 		}
+		zeroBytes++
 	}
 
 	payload := input[zeroBytes:]
@@ -9,8 +9,8 @@ func TestBase58Encode(t *testing.T) {
 	input := []byte("abcdef-12345")
 	actual := string(base58Encode(input))
 	expected := "2qb7RmPbQXRfszbtQ"
-	if reflect.DeepEqual(actual, expected) {
-		Error.Fatal("Failed!")
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Failed! actual = %q, expected = %q", actual, expected)
 	}
 }
 
@@ -18,7 +18,7 @@ func TestBase58Decode(t *testing.T) {
 	input := []byte("2qb7RmPbQXRfszbtQ")
 	actual := string(base58Decode(input))
 	expected := "abcdef-12345"
-	if reflect.DeepEqual(actual, expected) {
-		Error.Fatal("Failed!")
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Failed! actual = %q, expected = %q", actual, expected)
 	}
 }
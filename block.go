@@ -25,11 +25,6 @@ type Header struct {
 	Nonce         int    `json:"Nonce"`         // Number only used once.
 }
 
-// Create Genesis Block (starting point).
-func newGenesisBlock(txs []Transaction) *Block {
-	return newBlock(txs, []byte{}, 1)
-}
-
 // Create/Mine new block for the chain.
 func newBlock(txs []Transaction, prevBlockHash []byte, curDepth int) *Block {
 	nHeader := Header{
@@ -104,8 +99,7 @@ func (block *Block) Serialize() []byte {
 // deserializeBlock decode the given block's value from JSON formatter
 // into the original data type using `json.Unmarshal()`.
 func deserializeBlock(encoded []byte) *Block {
-	block := new(Block)
-	err := json.Unmarshal(encoded, block)
+	block, err := decodeBlock(encoded)
 	if err != nil {
 		Error.Printf("Unmarshal block failed!\n")
 		os.Exit(1)
@@ -113,6 +107,15 @@ func deserializeBlock(encoded []byte) *Block {
 	return block
 }
 
+// decodeBlock decodes a block received from another node.
+func decodeBlock(encoded []byte) (*Block, error) {
+	block := new(Block)
+	if err := json.Unmarshal(encoded, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
 // Header's methods:
 // Serialize encode the given block's header into JSON formatter using `json.Marshal()`.
 func (header *Header) Serialize() []byte {
@@ -126,12 +129,10 @@ func (header *Header) Serialize() []byte {
 
 // deserializeHeader decode the given block's header value from JSON formatter
 // into the original data type using `json.Unmarshal()`.
-func deserializeHeader(encoded []byte) *Header {
+func deserializeHeader(encoded []byte) (*Header, error) {
 	header := new(Header)
-	err := json.Unmarshal(encoded, header)
-	if err != nil {
-		Error.Printf("Unmarshal header failed!\n")
-		os.Exit(1)
+	if err := json.Unmarshal(encoded, header); err != nil {
+		return nil, err
 	}
-	return header
+	return header, nil
 }
@@ -2,19 +2,17 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strconv"
-	"syscall"
 	"time"
 
 	"github.com/boltdb/bolt"
-	"github.com/vrecan/death"
 )
 
 const (
@@ -33,6 +31,22 @@ type BlockchainIter struct {
 	Blockchain *Blockchain // Blockchain itself.
 }
 
+// Chain of the running node (nil outside of the `start` command).
+var nodeChain *Blockchain
+
+var (
+	ErrNotEnoughFunds = errors.New("not enough funds left to activate the transaction")
+	ErrUnknownPrevTx  = errors.New("input spends an unknown transaction")
+)
+
+func setNodeChain(bc *Blockchain) {
+	nodeChain = bc
+}
+
+func getNodeChain() *Blockchain {
+	return nodeChain
+}
+
 // Initialize an empty blockchain and save it to the `DB_FILE`
 // if this file is not present yet.
 func initBlockChain(node string) *Blockchain {
@@ -142,21 +156,103 @@ func (bc *Blockchain) GetBlockByDepth(depth int) *Block {
 	return nil
 }
 
+// GetBlockByHash returns the block of the chain with the given hash (nil if unknown).
+func (bc *Blockchain) GetBlockByHash(hash []byte) *Block {
+	var block *Block
+	if len(hash) != sha256.Size {
+		return nil
+	}
+
+	err := bc.DB.View(func(tx *bolt.Tx) error {
+		encoded := tx.Bucket([]byte(BLOCKS_BUCKET)).Get(hash)
+		if encoded != nil {
+			block, _ = decodeBlock(encoded)
+		}
+		return nil
+	})
+	if err != nil {
+		Error.Panic(err)
+	}
+	return block
+}
+
+// Locator returns the block locator of the chain: the hashes of its 10 latest blocks,
+// then of blocks twice further apart each time, down to the genesis block.
+// A node finds the latest block it shares with the chain from the first hash it knows.
+func (bc *Blockchain) Locator() [][]byte {
+	var locator [][]byte
+	if bc.IsEmpty() {
+		return locator
+	}
+
+	step, next := 1, bc.GetDepth()
+	bcIter := bc.Iterator()
+	for {
+		block := bcIter.Next()
+		if block.Header.Depth == next || block.IsGenesis() {
+			locator = append(locator, block.Header.Hash)
+			if len(locator) >= 10 {
+				step *= 2
+			}
+			next -= step
+		}
+		if block.IsGenesis() {
+			break
+		}
+	}
+	return locator
+}
+
+// GetHeadersAfter returns the headers of up to `max` blocks following the given depth.
+func (bc *Blockchain) GetHeadersAfter(depth, max int) []Header {
+	headers := []Header{}
+	if bc.IsEmpty() {
+		return headers
+	}
+
+	bcIter := bc.Iterator()
+	for {
+		block := bcIter.Next()
+		if block.Header.Depth <= depth {
+			break
+		}
+		if block.Header.Depth <= depth+max {
+			headers = append(headers, block.Header)
+		}
+		if block.IsGenesis() {
+			break
+		}
+	}
+
+	// Headers were collected from the latest one.
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+	return headers
+}
+
 // Adding a new given block from another node or this local node itself to the local chain
 // by appending the local chain's slice with this block.
-func (bc *Blockchain) AddBlock(block *Block) {
+// Returns false if the block does not fit the chain.
+func (bc *Blockchain) AddBlock(block *Block) bool {
 	pow := newProofOfWork(block)
 
-	if !pow.Validate() {
+	if !pow.ValidateHash() {
 		nonce, hash := pow.Run()
 		block.Header.Nonce = nonce
 		block.Header.Hash = hash
 	}
 
+	isAdded := false
 	err := bc.DB.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(BLOCKS_BUCKET))
 		if bc.IsEmpty() {
+			if !getChainParams().IsGenesis(block) {
+				Error.Printf("Block %x is not the genesis block of network %s!", block.Header.Hash, getChainParams().Name)
+				return nil
+			}
 			bc.PutBlock(bucket, block.Header.Hash, block.Serialize())
+			isAdded = true
 		} else {
 			// `l` was defined as key of the latest block's hash.
 			lastHash := bucket.Get([]byte("l"))
@@ -166,8 +262,9 @@ func (bc *Blockchain) AddBlock(block *Block) {
 			lastBlock := deserializeBlock(encodedLastBlock)
 
 			if block.Header.Depth > lastBlock.Header.Depth &&
-				bytes.Equal(block.Header.PrevBlockHash, lastBlock.Header.PrevBlockHash) {
+				bytes.Equal(block.Header.PrevBlockHash, lastBlock.Header.Hash) {
 				bc.PutBlock(bucket, block.Header.Hash, block.Serialize())
+				isAdded = true
 			} else {
 				Error.Printf("Block is invalid! Failed to add block: \n%v\n", block)
 				Error.Printf("Current latest block: \n%v\n", lastBlock)
@@ -180,6 +277,70 @@ func (bc *Blockchain) AddBlock(block *Block) {
 	if err != nil {
 		Error.Panic(err)
 	}
+	if isAdded {
+		getMempool().RemoveConfirmed(block)
+		getWatcher().Notify(bc, block)
+	}
+	return isAdded
+}
+
+// Reorganize replaces the blocks following `fork` by the given branch, which must extend it,
+// in one database transaction. The replaced blocks are deleted, so that every stored block
+// belongs to the chain. Returns the replaced blocks, latest first.
+func (bc *Blockchain) Reorganize(fork *Block, branch []*Block) ([]*Block, bool) {
+	prev := fork
+	for _, block := range branch {
+		if block.Header.Depth != prev.Header.Depth+1 || !bytes.Equal(block.Header.PrevBlockHash, prev.Header.Hash) {
+			Error.Printf("Block [%d] %x does not extend the branch!", block.Header.Depth, block.Header.Hash)
+			return nil, false
+		}
+		prev = block
+	}
+
+	var removed []*Block
+	latestHash := bc.GetLatestHash()
+	bcIter := bc.Iterator()
+	for {
+		block := bcIter.Next()
+		if bytes.Equal(block.Header.Hash, fork.Header.Hash) {
+			break
+		}
+		if block.IsGenesis() {
+			Error.Printf("Block %x is not in the chain!", fork.Header.Hash)
+			return nil, false
+		}
+		removed = append(removed, block)
+	}
+
+	isDone := false
+	err := bc.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(BLOCKS_BUCKET))
+		if !bytes.Equal(bucket.Get([]byte("l")), latestHash) {
+			Error.Printf("Chain changed during the reorganization!")
+			return nil
+		}
+		for _, block := range removed {
+			if err := bucket.Delete(block.Header.Hash); err != nil {
+				return err
+			}
+		}
+		for _, block := range branch {
+			bc.PutBlock(bucket, block.Header.Hash, block.Serialize())
+		}
+		isDone = true
+		return nil
+	})
+	if err != nil {
+		Error.Panic(err)
+	}
+	if !isDone {
+		return nil, false
+	}
+	for _, block := range branch {
+		getMempool().RemoveConfirmed(block)
+		getWatcher().Notify(bc, block)
+	}
+	return removed, true
 }
 
 // PutBlock sets 2 pairs:
@@ -267,44 +428,79 @@ func (bc *Blockchain) FindExistUTxO() map[string]TxOutputMap {
 
 // NewTx creates a new transaction from the given wallet
 // to the provided destination (address), within the total amount of coins/data.
-func (bc *Blockchain) NewTx(wallet *Wallet, toAddr string, totalVal int) *Transaction {
+func (bc *Blockchain) NewTx(wallet *Wallet, toAddr string, totalVal int) (*Transaction, error) {
+	newTx, _, err := bc.NewUnsignedTx(wallet.PublicKey, toAddr, totalVal)
+	if err != nil {
+		return nil, err
+	}
+	if err = newTx.SignWith([]*Wallet{wallet}); err != nil {
+		return nil, err
+	}
+
+	return newTx, nil
+}
+
+// NewUnsignedTx creates a new transaction spending the funds locked with the given
+// public key, without signing it. The previous outputs being spent are returned
+// alongside, so that the transaction can be signed later on another machine.
+func (bc *Blockchain) NewUnsignedTx(pubKey []byte, toAddr string, totalVal int) (*Transaction, []PrevTxOut, error) {
+	return bc.NewUnsignedTxFrom([][]byte{pubKey}, toAddr, genAddr(pubKey), totalVal)
+}
+
+// NewUnsignedTxFrom creates a new unsigned transaction spending the funds locked
+// with any of the given public keys (in order), sending the change to `changeAddr`.
+func (bc *Blockchain) NewUnsignedTxFrom(pubKeys [][]byte, toAddr, changeAddr string, totalVal int) (*Transaction, []PrevTxOut, error) {
 	var totalIns []TxInput
 	var totalOuts []TxOutput
+	var prevOuts []PrevTxOut
+	var spendableVal int
 
 	uTxOs := UTxOSet{Blockchain: bc}
 	uTxOs.Rearrange()
-	pubKeyHash := hashPubKey(wallet.PublicKey)
-	spendableVal, remainTxOuts := uTxOs.FindSpendableTxOut(pubKeyHash, totalVal)
-
-	if spendableVal > totalVal {
-		Error.Panic("ERROR: Not have enough funds left to activate the transaction!")
-	}
-
-	// Regenerate the list of TxInput from the remaining funds
-	// of the previous transaction.
-	for id, txOuts := range remainTxOuts {
-		txID, err := hex.DecodeString(id)
-		if err != nil {
-			Error.Fatal(err)
+	spentKeys := make(map[string]bool)
+	for _, pubKey := range pubKeys {
+		if spendableVal >= totalVal {
+			break
 		}
+		// A key given twice would spend its outputs twice.
+		if spentKeys[string(pubKey)] {
+			continue
+		}
+		spentKeys[string(pubKey)] = true
+		pubKeyHash := hashPubKey(pubKey)
+		keyVal, remainTxOuts := uTxOs.FindSpendableTxOut(pubKeyHash, totalVal-spendableVal)
+		spendableVal += keyVal
+
+		// Regenerate the list of TxInput from the remaining funds
+		// of the previous transaction.
+		for id, txOuts := range remainTxOuts {
+			txID, err := hex.DecodeString(id)
+			if err != nil {
+				return nil, nil, err
+			}
 
-		for idxOut := range txOuts {
-			txIn := TxInput{
-				TxID:      txID,
-				TxOutIdx:  idxOut,
-				Signature: nil,
-				PubKey:    wallet.PublicKey,
+			for idxOut, txOut := range txOuts {
+				txIn := TxInput{
+					TxID:      txID,
+					TxOutIdx:  idxOut,
+					Signature: nil,
+					PubKey:    pubKey,
+				}
+				totalIns = append(totalIns, txIn)
+				prevOuts = append(prevOuts, PrevTxOut{TxID: txID, TxOutIdx: idxOut, TxOut: txOut})
 			}
-			totalIns = append(totalIns, txIn)
 		}
 	}
 
+	if spendableVal < totalVal {
+		return nil, nil, fmt.Errorf("%w: %d available, %d needed", ErrNotEnoughFunds, spendableVal, totalVal)
+	}
+
 	// Regenerate the list of TxOutput by recalculating the remaining funds
 	// after spending on the previous TxInput transaction.
-	fromAddr := wallet.Address
 	totalOuts = append(totalOuts, *newTxOut(totalVal, toAddr))
 	if spendableVal > totalVal {
-		totalOuts = append(totalOuts, *newTxOut(spendableVal-totalVal, fromAddr))
+		totalOuts = append(totalOuts, *newTxOut(spendableVal-totalVal, changeAddr))
 	}
 
 	newTx := &Transaction{
@@ -313,40 +509,59 @@ func (bc *Blockchain) NewTx(wallet *Wallet, toAddr string, totalVal int) *Transa
 		TxOuts: totalOuts,
 	}
 	newTx.ID = newTx.HashTx()
-	newTx.Sign(wallet.PrivateKey)
 
-	return newTx
+	return newTx, prevOuts, nil
 }
 
 func (bc *Blockchain) VerifyTx(tx *Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
+	if bc.IsEmpty() {
+		return false
+	}
+	if !tx.HasValidID() {
+		Info.Printf("Transaction %x refused: ID does not match its contents", tx.ID)
+		return false
+	}
+	if tx.HasDuplicateInputs() {
+		Info.Printf("Transaction %x refused: an output is spent twice", tx.ID)
+		return false
+	}
 
+	prevTxs, err := bc.GetPrevTxs(tx)
+	if err != nil {
+		Info.Printf("Transaction %x refused: %v", tx.ID, err)
+		return false
+	}
 	uTxOs := UTxOSet{Blockchain: bc}
 	uTxOs.Rearrange()
-	prevTxs := bc.GetPrevTxs(tx)
 
 	return tx.VerifySignature() && uTxOs.VerifyTxIns(tx.TxIns) && tx.VerifyValues(prevTxs)
 }
 
-func (bc *Blockchain) GetPrevTxs(tx *Transaction) map[string]Transaction {
+// GetPrevTxs returns the transactions whose outputs are spent by the given one, by hex ID.
+// An input spending an unknown transaction is an error.
+func (bc *Blockchain) GetPrevTxs(tx *Transaction) (map[string]Transaction, error) {
 	prevTxs := make(map[string]Transaction)
 
 	for _, txIn := range tx.TxIns {
 		prevTx, err := bc.FindTxByID(txIn.TxID)
 		if err != nil {
-			Error.Fatal(err)
+			return nil, fmt.Errorf("%w: %x", ErrUnknownPrevTx, txIn.TxID)
 		}
 
 		key := hex.EncodeToString(prevTx.ID)
 		prevTxs[key] = prevTx
 	}
 
-	return prevTxs
+	return prevTxs, nil
 }
 
 func (bc *Blockchain) FindTxByID(id []byte) (Transaction, error) {
+	if bc.IsEmpty() {
+		return Transaction{}, errors.New("ERROR: Not found transaction")
+	}
 	bcIter := bc.Iterator()
 
 	for {
@@ -365,6 +580,94 @@ func (bc *Blockchain) FindTxByID(id []byte) (Transaction, error) {
 	return Transaction{}, errors.New("ERROR: Not found transaction")
 }
 
+// FindTxBlock returns the block containing the transaction with the given ID.
+func (bc *Blockchain) FindTxBlock(id []byte) (*Block, bool) {
+	if bc.IsEmpty() {
+		return nil, false
+	}
+
+	bcIter := bc.Iterator()
+	for {
+		block := bcIter.Next()
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, id) {
+				return block, true
+			}
+		}
+
+		if block.IsGenesis() {
+			break
+		}
+	}
+
+	return nil, false
+}
+
+// FindUsedPubKeyHashes returns the (hex-encoded) public key hashes having received
+// or spent funds anywhere in the chain.
+func (bc *Blockchain) FindUsedPubKeyHashes() map[string]bool {
+	used := make(map[string]bool)
+	if bc.IsEmpty() {
+		return used
+	}
+
+	bcIter := bc.Iterator()
+	for {
+		block := bcIter.Next()
+		for _, tx := range block.Transactions {
+			for _, txOut := range tx.TxOuts {
+				used[hex.EncodeToString(txOut.PubKeyHash)] = true
+			}
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, txIn := range tx.TxIns {
+				used[hex.EncodeToString(hashPubKey(txIn.PubKey))] = true
+			}
+		}
+
+		if block.IsGenesis() {
+			break
+		}
+	}
+
+	return used
+}
+
+// FindHistory returns the payments received and spent by the given addresses, oldest first.
+func (bc *Blockchain) FindHistory(addresses []string) []WatchEvent {
+	var blocks []*Block
+	if !bc.IsEmpty() {
+		bcIter := bc.Iterator()
+		for {
+			block := bcIter.Next()
+			blocks = append(blocks, block)
+			if block.IsGenesis() {
+				break
+			}
+		}
+	}
+
+	var history []WatchEvent
+	watched := watchedHashes(addresses)
+	txOuts := make(map[string][]TxOutput)
+	prevOut := func(txIn TxInput) (TxOutput, bool) {
+		outs, ok := txOuts[hex.EncodeToString(txIn.TxID)]
+		if !ok || txIn.TxOutIdx < 0 || txIn.TxOutIdx >= len(outs) {
+			return TxOutput{}, false
+		}
+		return outs[txIn.TxOutIdx], true
+	}
+	for idx := len(blocks) - 1; idx >= 0; idx-- {
+		for _, tx := range blocks[idx].Transactions {
+			txOuts[hex.EncodeToString(tx.ID)] = tx.TxOuts
+		}
+		history = append(history, blockWatchEvents(blocks[idx], watched, prevOut)...)
+	}
+
+	return history
+}
+
 // Stringify returns a string representation of the chain's values.
 func (bc *Blockchain) Stringify() string {
 	var chainAsStr string
@@ -454,16 +757,6 @@ func getLocalBC(node string) *Blockchain {
 	return &Blockchain{DB: db}
 }
 
-// closeDB forces the database to be closed.
-func closeDB(bc *Blockchain) {
-	d := death.NewDeath(syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-	d.WaitForDeathWithFunc(func() {
-		defer os.Exit(1)
-		defer runtime.Goexit()
-		bc.DB.Close()
-	})
-}
-
 // getAbsPathDB returns the absolute path to the database storage file in the given node.
 // @@@ FIXME: this is a temporary solution, maybe automatically later.
 func getAbsPathDB(node string) string {
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Named networks: every network has its own address version byte, genesis block,
+// magic value prefixing its messages and chain ID committed to by every signature,
+// so that nothing produced for one network is accepted by another.
+
+const (
+	NW_MAINNET = "mainnet"
+	NW_TESTNET = "testnet"
+	NW_REGTEST = "regtest"
+
+	DEFAULT_NW_NAME = NW_MAINNET // Used by the configs not naming their network.
+)
+
+// ChainParams holds the constants identifying a network.
+type ChainParams struct {
+	Name        string // Name of the network in the config file.
+	AddrVersion byte   // First byte of the network's addresses.
+	Magic       uint32 // Value prefixing every message exchanged on the network.
+	ChainID     uint32 // Identifier included in every signature hash.
+	GenesisHash string // Hex hash of the genesis block.
+
+	// The genesis block, which holds no transaction, is rebuilt from its timestamp and nonce.
+	GenesisTime  int64
+	GenesisNonce int
+}
+
+// Parameters of the known networks.
+// NOTE: mainnet keeps the historical `0x00` version, so that the existing addresses stay valid.
+var knownChainParams = map[string]ChainParams{
+	NW_MAINNET: {Name: NW_MAINNET, AddrVersion: 0x00, Magic: 0x70647030, ChainID: 1,
+		GenesisHash: "0000710c6fb0179d8794c036d406259505ba4e394b1b37af24728b207b46917c", GenesisTime: 1654041600, GenesisNonce: 4144},
+	NW_TESTNET: {Name: NW_TESTNET, AddrVersion: 0x6f, Magic: 0x70647431, ChainID: 2,
+		GenesisHash: "000079c4b2d16d5e70206c92850225e242bf815b5f18806fe0840842ef0c49eb", GenesisTime: 1654128000, GenesisNonce: 31302},
+	NW_REGTEST: {Name: NW_REGTEST, AddrVersion: 0x3c, Magic: 0x70647232, ChainID: 3,
+		GenesisHash: "00005915f5e8de7e315a7fdd167ea705513a0d4d9df8cb5e9937c246de21b44f", GenesisTime: 1654214400, GenesisNonce: 51318},
+}
+
+// Parameters of the network the node runs on.
+var chainParams *ChainParams
+
+// Utility functions start from here.
+
+// getChainParams returns the parameters of the current network (mainnet if none is configured).
+func getChainParams() *ChainParams {
+	if chainParams == nil {
+		params := knownChainParams[DEFAULT_NW_NAME]
+		chainParams = &params
+	}
+	return chainParams
+}
+
+// setChainParams selects the network named in the given config section.
+func setChainParams(nw Network) error {
+	params, err := newChainParams(nw)
+	if err != nil {
+		return err
+	}
+	chainParams = params
+	return nil
+}
+
+// newChainParams returns the parameters of the network named in the given config section.
+func newChainParams(nw Network) (*ChainParams, error) {
+	name := nw.Name
+	if name == "" {
+		name = DEFAULT_NW_NAME
+	}
+	params, ok := knownChainParams[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q (known: %v)", name, knownNetworks())
+	}
+
+	if nw.GenesisHash != "" {
+		if _, err := hex.DecodeString(nw.GenesisHash); err != nil {
+			return nil, fmt.Errorf("invalid genesis hash %q: %v", nw.GenesisHash, err)
+		}
+		params.GenesisHash = nw.GenesisHash
+	}
+	return &params, nil
+}
+
+// knownNetworks returns the sorted names of the known networks.
+func knownNetworks() []string {
+	var names []string
+	for name := range knownChainParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ChainParams's methods:
+
+// IsGenesis returns true if the given block is the genesis block of the network.
+func (params *ChainParams) IsGenesis(block *Block) bool {
+	return len(block.Header.PrevBlockHash) == 0 && hex.EncodeToString(block.Header.Hash) == params.GenesisHash
+}
+
+// GenesisBlock returns the genesis block of the network, or nil if the config overrides its hash
+// with the one of another block, which must then be pulled from the peers.
+func (params *ChainParams) GenesisBlock() *Block {
+	genesis := &Block{
+		Header:       Header{PrevBlockHash: []byte{}, Timestamp: params.GenesisTime, Depth: 1, Nonce: params.GenesisNonce},
+		Transactions: []Transaction{},
+	}
+	genesis.Header.Hash, _ = hex.DecodeString(params.GenesisHash)
+	if !newProofOfWork(genesis).ValidateHash() {
+		return nil
+	}
+	return genesis
+}
+
+// SigHash returns the hash signed by the inputs of a transaction: `sha256(chainID || data)`.
+func (params *ChainParams) SigHash(data []byte) []byte {
+	var chainID [4]byte
+	binary.BigEndian.PutUint32(chainID[:], params.ChainID)
+	sum := sha256.Sum256(append(chainID[:], data...))
+	return sum[:]
+}
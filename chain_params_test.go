@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestAddrBelongsToNetwork(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	defer setChainParams(Network{})
+
+	w := newWallet()
+	if err := setChainParams(Network{Name: NW_TESTNET}); err != nil {
+		t.Fatalf("setChainParams failed: %v", err)
+	}
+	testnetAddr := genAddr(w.PublicKey)
+	if !validateAddr(testnetAddr) || validateAddr(w.Address) {
+		t.Errorf("Only testnet addresses should be valid on testnet!")
+	}
+
+	setChainParams(Network{Name: NW_MAINNET})
+	if validateAddr(testnetAddr) || !validateAddr(w.Address) {
+		t.Errorf("Only mainnet addresses should be valid on mainnet!")
+	}
+	if err := setChainParams(Network{Name: "devnet"}); err == nil {
+		t.Errorf("Unknown network should be rejected!")
+	}
+}
+
+func TestSignatureBoundToChainID(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	defer setChainParams(Network{})
+
+	sender, receiver := newWallet(), newWallet()
+	tx := &Transaction{
+		TxIns:  []TxInput{{TxID: []byte("prev"), TxOutIdx: 0, PubKey: sender.PublicKey}},
+		TxOuts: []TxOutput{*newTxOut(5, receiver.Address)},
+	}
+	tx.ID = tx.HashTx()
+
+	setChainParams(Network{Name: NW_REGTEST})
+	tx.Sign(sender.PrivateKey)
+	if !tx.VerifySignature() {
+		t.Fatalf("Signature should be valid on its own network!")
+	}
+	setChainParams(Network{Name: NW_MAINNET})
+	if tx.VerifySignature() {
+		t.Errorf("Signature should not be replayable on another network!")
+	}
+}
+
+func TestGenesisBlocks(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	defer setChainParams(Network{})
+
+	hashes := map[string]bool{}
+	for _, name := range knownNetworks() {
+		setChainParams(Network{Name: name})
+		params := getChainParams()
+		genesis := params.GenesisBlock()
+		if genesis == nil || !params.IsGenesis(genesis) {
+			t.Fatalf("%s: genesis block %s cannot be rebuilt", name, params.GenesisHash)
+		}
+		hashes[params.GenesisHash] = true
+
+		mined := newBlock([]Transaction{}, []byte{}, 1)
+		mined.Header.Nonce, mined.Header.Hash = newProofOfWork(mined).Run()
+		if params.IsGenesis(mined) {
+			t.Errorf("%s: any first block should not be the genesis block", name)
+		}
+		bc := newTestChain(t, name)
+		if bc.AddBlock(mined) || !bc.IsEmpty() {
+			t.Errorf("%s: the chain should only start with the genesis block", name)
+		}
+		if !bc.AddBlock(genesis) {
+			t.Errorf("%s: the genesis block should start the chain", name)
+		}
+	}
+	if len(hashes) != len(knownNetworks()) {
+		t.Errorf("Every network should have its own genesis block, got %v", hashes)
+	}
+
+	otherHash := "00000000000000000000000000000000000000000000000000000000000000aa"
+	setChainParams(Network{Name: NW_REGTEST, GenesisHash: otherHash})
+	if getChainParams().GenesisBlock() != nil {
+		t.Errorf("The genesis block of an overridden hash should be pulled from the peers")
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"regexp"
+	"strings"
+	"syscall"
+	"time"
 
 	cli "github.com/urfave/cli"
 )
@@ -32,6 +38,14 @@ import (
 	Step 1: .\pdpapp.exe -c node2 -n node2 ims
 	Step 2: .\pdpapp.exe crtx -c node2 -n node2 --to localhost:3331 -v 1 -f test.txt
 	Step 3: Checking the `test.txt` file for more details.
+
+	Sample commands of signing a transaction offline: (eg: send from node2 -> node1)
+	Step 1 (online) : .\pdpapp.exe build-tx -c node2 -n node2 --to {address} -v 1 -f unsigned.json
+	Step 2 (offline): .\pdpapp.exe sign-tx -c node2 -f unsigned.json -o signed.json
+	Step 3 (online) : .\pdpapp.exe broadcast-tx -c node2 -f signed.json --peer localhost:3331
+
+	Sample command of tracking a transaction until it gets 3 confirmations:
+		.\pdpapp.exe tx-status -c node2 --wait 3 {txID}
 */
 
 // newCLIApp create the new CLI application with some custom commands.
@@ -48,12 +62,18 @@ func newCLIApp() *cli.App {
 	createWalletCLI(app)
 	createTransactionCLI(app)
 	createValidationPrfCLI(app)
+	offlineTxCLI(app)
+	txStatusCLI(app)
+	walletCLI(app)
+	signMessageCLI(app)
+	peersCLI(app)
+	configCLI(app)
 
 	return app
 }
 
 func createWalletCLI(app *cli.App) {
-	var cfgPath string
+	var cfgPath, keyType string
 
 	app.Commands = append(app.Commands, []cli.Command{
 		{
@@ -61,9 +81,10 @@ func createWalletCLI(app *cli.App) {
 			Aliases: []string{"cw"},
 			Usage:   "create new storable wallet address",
 			Action: func(ctx *cli.Context) error {
-				execCreateWallet(ctx, cfgPath)
+				execCreateWallet(ctx, cfgPath, keyType)
 				return nil
 			},
+			Flags: []cli.Flag{keyTypeFlag(&keyType)},
 		},
 	}...)
 	app.Flags = append(app.Flags, []cli.Flag{
@@ -117,16 +138,16 @@ func startServerCLI(app *cli.App) {
 }
 
 func createTransactionCLI(app *cli.App) {
-	var cfgPath, nodeDb, toAddr, exportFile string
+	var cfgPath, nodeDb, toAddr, exportFile, fromRefs string
 	var totalVal int
 
 	app.Commands = append(app.Commands, []cli.Command{
 		{
 			Name:    "create-tx",
 			Aliases: []string{"crtx"},
-			Usage:   "crtx -c {cfgPath} -n {node} -to {address} -v {value} -f {exportFile}",
+			Usage:   "crtx -c {cfgPath} -n {node} [--from {address|label,...}] -to {address} -v {value} -f {exportFile}",
 			Action: func(ctx *cli.Context) error {
-				execCreateTx(ctx, totalVal, cfgPath, nodeDb, toAddr, exportFile)
+				execCreateTx(ctx, totalVal, cfgPath, nodeDb, toAddr, exportFile, fromRefs)
 				return nil
 			},
 			Flags: []cli.Flag{
@@ -154,6 +175,12 @@ func createTransactionCLI(app *cli.App) {
 					// cfgPath[3]
 					Destination: &exportFile,
 				},
+				cli.StringFlag{
+					Name: "from",
+					// cfgPath[4]
+					Usage:       "comma-separated addresses or labels of the wallet store to spend from",
+					Destination: &fromRefs,
+				},
 			},
 		},
 	}...)
@@ -181,11 +208,97 @@ func createValidationPrfCLI(app *cli.App) {
 	}...)
 }
 
+// offlineTxCLI splits the creation of a transaction into the build, sign and broadcast steps,
+// so that the private key only needs to live on an offline machine.
+func offlineTxCLI(app *cli.App) {
+	var cfgPath, nodeDb, toAddr, inFile, outFile, peerAddr string
+	var totalVal int
+
+	app.Commands = append(app.Commands, []cli.Command{
+		{
+			Name:    "build-tx",
+			Aliases: []string{"btx"},
+			Usage:   "btx -c {cfgPath} -n {node} --to {address} -v {value} -f {exportFile}",
+			Action: func(ctx *cli.Context) error {
+				execBuildTx(ctx, totalVal, cfgPath, nodeDb, toAddr, outFile)
+				return nil
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "c", Destination: &cfgPath},
+				cli.StringFlag{Name: "n", Destination: &nodeDb},
+				cli.IntFlag{Name: "v", Destination: &totalVal},
+				cli.StringFlag{Name: "to", Destination: &toAddr},
+				cli.StringFlag{Name: "f", Destination: &outFile},
+			},
+		},
+		{
+			Name:    "sign-tx",
+			Aliases: []string{"stx"},
+			Usage:   "stx -c {cfgPath} -f {unsignedFile} -o {signedFile}",
+			Action: func(ctx *cli.Context) error {
+				execSignTx(ctx, cfgPath, inFile, outFile)
+				return nil
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "c", Destination: &cfgPath},
+				cli.StringFlag{Name: "f", Destination: &inFile},
+				cli.StringFlag{Name: "o", Destination: &outFile},
+			},
+		},
+		{
+			Name:    "broadcast-tx",
+			Aliases: []string{"bctx"},
+			Usage:   "bctx -c {cfgPath} -f {signedFile} --peer {nodeAddress}",
+			Action: func(ctx *cli.Context) error {
+				execBroadcastTx(ctx, cfgPath, inFile, peerAddr)
+				return nil
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "c", Destination: &cfgPath},
+				cli.StringFlag{Name: "f", Destination: &inFile},
+				cli.StringFlag{Name: "peer", Destination: &peerAddr},
+			},
+		},
+	}...)
+}
+
+// txStatusCLI reports the state of a transaction known by a node.
+func txStatusCLI(app *cli.App) {
+	var cfgPath, peerAddr string
+	var waitConfirms int
+
+	app.Commands = append(app.Commands, []cli.Command{
+		{
+			Name:      "tx-status",
+			Aliases:   []string{"txs"},
+			Usage:     "txs -c {cfgPath} --peer {nodeAddress} --wait {confirmations} {txID}",
+			ArgsUsage: "TXID",
+			Action: func(ctx *cli.Context) error {
+				execTxStatus(ctx, waitConfirms, cfgPath, peerAddr, ctx.Args().First())
+				return nil
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "c", Destination: &cfgPath},
+				cli.StringFlag{Name: "peer", Destination: &peerAddr},
+				cli.IntFlag{
+					Name:        "wait",
+					Usage:       "block until the transaction has `N` confirmations",
+					Destination: &waitConfirms,
+				},
+			},
+		},
+	}...)
+}
+
 // execStartServer executes the specified commands from the terminal.
 func execStartServer(ctx *cli.Context, cfgPath ...string) {
 	// `cfg[0]` = path to the configuration file.
 	// `cfg[1]` = path to the database storage file.
 	initNwCfg(cfgPath[0])
+	startWatcher(cfgPath[0])
+	if isDevMode() {
+		Warning.Printf("Running in dev mode: unsigned messages are accepted")
+	}
 
 	// If `DB_FILE` haven't existed, initialize an empty blockchain.
 	// Else, read this file to get the blockchain structure.
@@ -196,27 +309,67 @@ func execStartServer(ctx *cli.Context, cfgPath ...string) {
 	} else {
 		Info.Printf("Import blockchain database from local storage completed!")
 	}
-	syncNeighborBC(bc)
+	setNodeChain(bc)
+	loadPeerManager(bc)
 
-	if bc == nil || bc.IsEmpty() {
-		Info.Printf("Pull failed, no available node for synchronization. Create new blockchain instead.\n")
-		firstTx := []Transaction{}
-		bc.AddBlock(newGenesisBlock(firstTx))
+	// SIGINT and SIGTERM stop the node, a second signal kills it.
+	nodeCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	node := newNodeLifecycle(bc)
+	if err := node.Start(nodeCtx); err != nil {
+		if nodeCtx.Err() != nil {
+			Info.Printf("Node stopped while starting")
+			return
+		}
+		Error.Print(err)
+		os.Exit(1)
 	}
 
-	startBCServer(bc)
-	defer bc.DB.Close()
+	// SIGHUP reloads the config file.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for nodeCtx.Err() == nil {
+		select {
+		case <-nodeCtx.Done():
+		case <-hup:
+			if report, err := reloadNwCfg(); err != nil {
+				Error.Print(err)
+			} else {
+				Info.Printf("Config reloaded:\n%s", report.Stringify())
+			}
+		}
+	}
+	stopSignals()
+	Info.Printf("Shutting down, waiting up to %v for the ongoing requests...", SHUTDOWN_TIMEOUT)
+	node.Stop(SHUTDOWN_TIMEOUT)
+	Info.Printf("Node stopped")
 }
 
 // execCreateWallet creates new a `Wallet` instance.
-func execCreateWallet(ctx *cli.Context, cfgPath string) {
-	config := initNwCfg(cfgPath)
-	wallet := newWallet()
+func execCreateWallet(ctx *cli.Context, cfgPath, keyType string) {
+	config := loadNwCfg(cfgPath)
+	wallet, err := newWalletOf(keyType)
+	if err != nil {
+		Error.Print(err)
+		os.Exit(1)
+	}
+
+	// The previous wallet is kept inside the wallet store instead of being overwritten.
+	ws := openWalletStore(cfgPath, config)
+	if _, err := ws.Add("", *wallet.ToJson()); err != nil {
+		Error.Printf("Cannot add wallet: %v", err)
+		os.Exit(1)
+	}
+	ws.Default = wallet.Address
+	ws.Save()
+
 	config.WJson = *wallet.ToJson()
 	config.ExportNetworkCfg(cfgPath)
 
 	fmt.Printf("New wallet is created successfully! Wallet is exported to : * %s *\n", cfgPath)
 	fmt.Printf("%s\n", config.WJson)
+	fmt.Printf("Run `wallet lock -c %s` to encrypt its private key.\n", cfgPath)
 }
 
 // @@@ FIXME: to be more cleaner!
@@ -225,21 +378,62 @@ func execCreateTx(ctx *cli.Context, val int, cfgPath ...string) {
 	// `cfg[1]` = path to the database storage file.
 	// `cfg[2]` = the node's port address.
 	// `cfg[3]` = the path to the output file.
+	// `cfg[4]` = the wallets to spend from (empty = the node's default wallet).
 	var sourceAddr string
 	re := regexp.MustCompile("[0-9]+")
 	sourceIdxAddr := re.Find([]byte(cfgPath[0]))
 	sourceAddr = fmt.Sprintf("localhost:333%d", Bytestoi(sourceIdxAddr))
 	Info.Printf("Execute transaction: send %d coins from %s to address %s", val, sourceAddr, cfgPath[2])
 
-	initNwCfg(cfgPath[0])
-	wallet := getWallet()
+	var wallets []*Wallet
+	ws := openWalletStore(cfgPath[0], loadNwCfg(cfgPath[0]))
+	if cfgPath[4] == "" {
+		initNwCfg(cfgPath[0])
+		wallets = []*Wallet{getWallet()}
+	} else {
+		var err error
+		wallets, err = ws.Unlock(strings.Split(cfgPath[4], ","))
+		if err != nil {
+			Error.Print(err)
+			os.Exit(1)
+		}
+	}
 	bc := getLocalBC(cfgPath[1])
 	if bc == nil {
 		Error.Print("Local blockchain not found. Need one existed first!")
 		os.Exit(1)
 	}
 
-	tx := bc.NewTx(wallet, cfgPath[2], val)
+	// The change goes to a fresh HD address if possible, else back to the first wallet spent from.
+	// The HD address is only derived once the transaction is known to have a change output,
+	// so that failed sends do not use up the change indexes.
+	var pubKeys [][]byte
+	for _, w := range wallets {
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+	tx, _, err := bc.NewUnsignedTxFrom(pubKeys, cfgPath[2], wallets[0].Address, val)
+	if err != nil {
+		Error.Printf("Cannot create transaction: %v", err)
+		os.Exit(1)
+	}
+	hasNewChange := ws.HD != nil && len(tx.TxOuts) > 1
+	if hasNewChange {
+		entry, _, err := ws.AddHD(HD_CHANGE_CHAIN, "")
+		if err != nil {
+			Error.Print(err)
+			os.Exit(1)
+		}
+		tx.TxOuts[1] = *newTxOut(tx.TxOuts[1].Value, entry.Wallet.Address)
+		tx.ID = tx.HashTx()
+	}
+	if err = tx.SignWith(wallets); err != nil {
+		Error.Print(err)
+		os.Exit(1)
+	}
+	if hasNewChange {
+		ws.Save()
+	}
+	Info.Printf("Transaction ID: %x (track it with `tx-status`)", tx.ID)
 	msgReq := createMsgReqAddTx(tx)
 	if isExist := checkFileExists(cfgPath[3]); isExist {
 		contents, _ := json.MarshalIndent(msgReq, "", "  ")
@@ -264,3 +458,126 @@ func execValidateBlock(ctx *cli.Context, cfgPath string) {
 		checkBlockPrf(bc, idx)
 	}
 }
+
+// execBuildTx exports an unsigned transaction spending the funds of the configured
+// wallet's public key. No private key is needed on this host.
+func execBuildTx(ctx *cli.Context, val int, cfgPath ...string) {
+	// `cfg[0]` = path to the configuration file.
+	// `cfg[1]` = path to the database storage file.
+	// `cfg[2]` = the receiver's wallet address.
+	// `cfg[3]` = the path to the output file.
+	cfg := loadNwCfg(cfgPath[0])
+	pubKey, err := hex.DecodeString(cfg.WJson.PublicKey)
+	if err != nil || len(pubKey) == 0 {
+		Error.Print("Configuration does not contain a valid public key!")
+		os.Exit(1)
+	}
+	if !validateAddr(cfgPath[2]) {
+		Error.Printf("Invalid receiver address: %s", cfgPath[2])
+		os.Exit(1)
+	}
+
+	bc := getLocalBC(cfgPath[1])
+	if bc == nil {
+		Error.Print("Local blockchain not found. Need one existed first!")
+		os.Exit(1)
+	}
+	defer bc.DB.Close()
+
+	tx, prevOuts, err := bc.NewUnsignedTx(pubKey, cfgPath[2], val)
+	if err != nil {
+		Error.Printf("Cannot build transaction: %v", err)
+		os.Exit(1)
+	}
+	ptx := newPartialTx(tx, prevOuts)
+	ptx.Export(cfgPath[3])
+
+	fmt.Printf("Unsigned transaction is exported to : * %s *\n", cfgPath[3])
+	fmt.Printf("%s\n", ptx.Stringify())
+}
+
+// execSignTx signs a transaction built by `build-tx` with the configured wallet.
+// This step does not need the chain database nor any network access.
+func execSignTx(ctx *cli.Context, cfgPath, inFile, outFile string) {
+	initNwCfg(cfgPath)
+	ptx, err := importPartialTx(inFile)
+	if err != nil {
+		Error.Printf("Cannot read partial transaction %s: %v", inFile, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s\n", ptx.Stringify())
+	if err = ptx.Sign(getWallet()); err != nil {
+		Error.Printf("Cannot sign transaction: %v", err)
+		os.Exit(1)
+	}
+
+	if outFile == "" {
+		outFile = inFile
+	}
+	ptx.Export(outFile)
+	fmt.Printf("Signed transaction is exported to : * %s *\n", outFile)
+}
+
+// execBroadcastTx submits a transaction signed by `sign-tx` to the given node.
+func execBroadcastTx(ctx *cli.Context, cfgPath, inFile, peerAddr string) {
+	cfg := loadNwCfg(cfgPath)
+	ptx, err := importPartialTx(inFile)
+	if err != nil {
+		Error.Printf("Cannot read partial transaction %s: %v", inFile, err)
+		os.Exit(1)
+	}
+	if !ptx.IsSigned() || !ptx.Tx.VerifySignature() {
+		Error.Print("Transaction is not signed yet. Run `sign-tx` first!")
+		os.Exit(1)
+	}
+	if err = ptx.Check(); err != nil {
+		Error.Printf("Invalid transaction: %v", err)
+		os.Exit(1)
+	}
+
+	node := cfg.Network.LocalNode
+	if peerAddr != "" {
+		node = Node{Address: peerAddr}
+	}
+	isSuccess, err := sendTxNeighbor(&ptx.Tx, node)
+	if err != nil {
+		Error.Printf("Broadcast to %s failed: %v", node.Address, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Transaction %x accepted by %s : %t\n", ptx.Tx.ID, node.Address, isSuccess)
+}
+
+// execTxStatus prints the state of the given transaction. With `waitConfirms` > 0
+// it keeps polling the node until the transaction reaches that many confirmations.
+func execTxStatus(ctx *cli.Context, waitConfirms int, cfgPath, peerAddr, txIDHex string) {
+	cfg := loadNwCfg(cfgPath)
+	txID, err := hex.DecodeString(txIDHex)
+	if err != nil || len(txID) == 0 {
+		Error.Printf("Invalid transaction ID: %q", txIDHex)
+		os.Exit(1)
+	}
+
+	node := cfg.Network.LocalNode
+	if peerAddr != "" {
+		node = Node{Address: peerAddr}
+	}
+
+	for {
+		status, err := getTxStateNeighbor(txID, node)
+		if err != nil {
+			Error.Printf("Cannot fetch the transaction's state from %s: %v", node.Address, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s\n", status.Stringify())
+
+		if waitConfirms <= 0 || status.Confirmations >= waitConfirms {
+			return
+		}
+		if status.State == TX_STATE_CONFLICTED {
+			Error.Printf("Transaction was dropped, it conflicts with %s", status.ConflictWith)
+			os.Exit(1)
+		}
+		time.Sleep(TX_STATE_POLL_INTERVAL)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	cli "github.com/urfave/cli"
+)
+
+/*
+	Sample command reloading the config file of a running node (only accepted from the local host):
+		.\pdpapp.exe config reload -c node1
+*/
+
+// configCLI groups the admin commands managing the config of a running node.
+func configCLI(app *cli.App) {
+	var cfgPath string
+	cfgFlag := cli.StringFlag{Name: "c", Destination: &cfgPath}
+
+	app.Commands = append(app.Commands, []cli.Command{
+		{
+			Name:  "config",
+			Usage: "manage the config of a running node",
+			Subcommands: []cli.Command{
+				{
+					Name:  "reload",
+					Usage: "reload -c {cfgPath} : apply the changes of the config file without restarting the node",
+					Action: func(ctx *cli.Context) error {
+						execReloadCfg(ctx, cfgPath)
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag},
+				},
+			},
+		},
+	}...)
+}
+
+// execReloadCfg asks the local node to reload its config file and prints the changes applied.
+func execReloadCfg(ctx *cli.Context, cfgPath string) {
+	cfg := loadNwCfg(cfgPath)
+	node := cfg.Network.LocalNode
+
+	report, err := reqReloadNeighbor(node)
+	if err != nil {
+		Error.Printf("Cannot reload the config of %s: %v", node.Address, err)
+		os.Exit(1)
+	}
+	fmt.Print(report.Stringify())
+}
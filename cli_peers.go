@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	cli "github.com/urfave/cli"
+)
+
+/*
+	Sample commands managing the peers of a running node (only accepted from the local host):
+		.\pdpapp.exe peers list -c node1
+		.\pdpapp.exe peers ban -c node1 --duration 48h --reason "spam" 10.0.0.7
+		.\pdpapp.exe peers unban -c node1 10.0.0.7
+		.\pdpapp.exe peers id -c node1
+*/
+
+// peersCLI groups the admin commands managing the peers of a running node.
+func peersCLI(app *cli.App) {
+	var cfgPath, peerAddr, duration, reason string
+	cfgFlag := cli.StringFlag{Name: "c", Destination: &cfgPath}
+	peerFlag := cli.StringFlag{Name: "peer", Usage: "node to manage, default to the local node", Destination: &peerAddr}
+
+	app.Commands = append(app.Commands, []cli.Command{
+		{
+			Name:  "peers",
+			Usage: "manage the peers of a running node",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "list -c {cfgPath} : list the connections, misbehaving and banned peers",
+					Action: func(ctx *cli.Context) error {
+						execPeers(ctx, cfgPath, peerAddr, createMsgReqPeers)
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag, peerFlag},
+				},
+				{
+					Name:      "ban",
+					Usage:     "ban -c {cfgPath} --duration {24h} --reason {text} {ip|host:port}",
+					ArgsUsage: "PEER",
+					Action: func(ctx *cli.Context) error {
+						execBanPeer(ctx, cfgPath, peerAddr, ctx.Args().First(), duration, reason)
+						return nil
+					},
+					Flags: []cli.Flag{
+						cfgFlag,
+						peerFlag,
+						cli.StringFlag{Name: "duration", Usage: "ban duration, default to the node's `ban_duration`", Destination: &duration},
+						cli.StringFlag{Name: "reason", Value: "banned by the node operator", Destination: &reason},
+					},
+				},
+				{
+					Name:      "unban",
+					Usage:     "unban -c {cfgPath} {ip|host:port}",
+					ArgsUsage: "PEER",
+					Action: func(ctx *cli.Context) error {
+						key := ctx.Args().First()
+						execPeers(ctx, cfgPath, peerAddr, func() *Message { return createMsgUnbanPeer(key) })
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag, peerFlag},
+				},
+				{
+					Name:  "id",
+					Usage: "id -c {cfgPath} : print the node ID to add to the `allowed_peers` of other nodes",
+					Action: func(ctx *cli.Context) error {
+						execNodeID(ctx, cfgPath)
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag},
+				},
+			},
+		},
+	}...)
+}
+
+// execPeers sends the admin request built by `newMsg` and prints the state of the node's peers.
+func execPeers(ctx *cli.Context, cfgPath, peerAddr string, newMsg func() *Message) {
+	cfg := loadNwCfg(cfgPath)
+	node := cfg.Network.LocalNode
+	if peerAddr != "" {
+		node = Node{Address: peerAddr}
+	}
+
+	report, err := reqPeersNeighbor(newMsg(), node)
+	if err != nil {
+		Error.Printf("Cannot manage the peers of %s: %v", node.Address, err)
+		os.Exit(1)
+	}
+	fmt.Print(report.Stringify())
+}
+
+// execBanPeer bans the given peer from the node.
+func execBanPeer(ctx *cli.Context, cfgPath, peerAddr, key, duration, reason string) {
+	if key == "" {
+		Error.Print("Missing the peer to ban")
+		os.Exit(1)
+	}
+	req := &BanRequest{Key: key, Reason: reason}
+	if duration != "" {
+		banTime, err := time.ParseDuration(duration)
+		if err != nil || banTime <= 0 {
+			Error.Printf("Invalid ban duration %q", duration)
+			os.Exit(1)
+		}
+		req.Duration = int64(banTime / time.Second)
+	}
+	execPeers(ctx, cfgPath, peerAddr, func() *Message { return createMsgBanPeer(req) })
+}
+
+// execNodeID prints the identity of the node, creating its node key on the first run.
+func execNodeID(ctx *cli.Context, cfgPath string) {
+	loadNwCfg(cfgPath)
+	identity, err := loadNodeIdentity(getNwCfgPath())
+	if err != nil {
+		Error.Printf("Cannot load node identity: %v", err)
+		os.Exit(1)
+	}
+	fmt.Println(identity.ID())
+}
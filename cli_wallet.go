@@ -0,0 +1,813 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	cli "github.com/urfave/cli"
+)
+
+/*
+	Sample commands of protecting the wallet's private key with a passphrase:
+	Encrypt (or migrate) the plain text key of `config/node1/config.json`:
+		.\pdpapp.exe wallet lock -c node1
+	Change the passphrase:
+		.\pdpapp.exe wallet change-passphrase -c node1
+	Decrypt the key back into the config file:
+		.\pdpapp.exe wallet unlock -c node1
+	Start a node reading the passphrase from the file descriptor 3:
+		.\pdpapp.exe --passphrase-fd 3 -c node1 -n node1 start 3< passphrase.txt
+
+	Sample commands of managing several keys in `config/node1/wallets.json`:
+		.\pdpapp.exe wallet new -c node1 --label savings
+		.\pdpapp.exe wallet list -c node1 -n node1
+		.\pdpapp.exe wallet import-key -c node1 --label cold --key {hexPrivateKey}
+		.\pdpapp.exe wallet export-key -c node1 savings
+		.\pdpapp.exe wallet set-default -c node1 savings
+	Spend from several of them at once:
+		.\pdpapp.exe crtx -c node1 -n node1 --from default,savings --to {address} -v 5 -f tx.json
+
+	Sample commands of deriving every key from one seed (HD wallet):
+	Create the seed and write down the printed mnemonic phrase:
+		.\pdpapp.exe wallet hd-init -c node1 --words 24
+	Derive the next receiving address:
+		.\pdpapp.exe wallet new -c node1
+	Restore the keys on another host, scanning the chain for the used addresses:
+		.\pdpapp.exe wallet restore -c node2 -n node2 --mnemonic "{words}" --gap 20
+
+	Sample commands of backing up a key as shares, any 3 of the 5 written files rebuilding it:
+		.\pdpapp.exe wallet split -c node1 --shares 5 --threshold 3
+		.\pdpapp.exe wallet split -c node1 --shares 5 --threshold 3 hd-seed
+		.\pdpapp.exe wallet combine -c node1 shares/share-1-of-5.txt shares/share-3-of-5.txt shares/share-4-of-5.txt
+
+	Sample commands of monitoring addresses without their private keys:
+		.\pdpapp.exe wallet watch -c node3 --label shop --address {address}
+		.\pdpapp.exe wallet history -c node3 -n node3 shop
+	A running node logs the payments of its watched addresses to `config/node3/watch-events.log`.
+
+	Sample commands of proving the control of an address:
+		.\pdpapp.exe sign-message -c node1 --address {address} --message "register storage provider"
+		.\pdpapp.exe verify-message --address {address} --signature {base64} --message "register storage provider"
+*/
+
+// walletCLI groups the commands managing the local wallet.
+func walletCLI(app *cli.App) {
+	var cfgPath, nodeDb, label, privKey, mnemonic, keyType, address, pubKey, shareDir string
+	var words, account, gapLimit, shares, threshold int
+	cfgFlag := cli.StringFlag{Name: "c", Destination: &cfgPath}
+	labelFlag := cli.StringFlag{Name: "label", Destination: &label}
+	accountFlag := cli.IntFlag{Name: "account", Usage: "HD account to derive the keys of", Destination: &account}
+
+	app.Commands = append(app.Commands, []cli.Command{
+		{
+			Name:  "wallet",
+			Usage: "manage the wallet of a node",
+			Subcommands: []cli.Command{
+				{
+					Name:  "lock",
+					Usage: "lock -c {cfgPath} : encrypt the plain text private keys into keystores",
+					Action: func(ctx *cli.Context) error {
+						execWalletLock(ctx, cfgPath)
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag},
+				},
+				{
+					Name:  "unlock",
+					Usage: "unlock -c {cfgPath} : decrypt the keystore back into the config file",
+					Action: func(ctx *cli.Context) error {
+						execWalletUnlock(ctx, cfgPath)
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag},
+				},
+				{
+					Name:  "change-passphrase",
+					Usage: "change-passphrase -c {cfgPath} : re-encrypt the keystore with a new passphrase",
+					Action: func(ctx *cli.Context) error {
+						execWalletChangePassphrase(ctx, cfgPath)
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag},
+				},
+				{
+					Name:  "new",
+					Usage: "new -c {cfgPath} --label {label} : add a new key to the wallet store",
+					Action: func(ctx *cli.Context) error {
+						execWalletNew(ctx, cfgPath, label, keyType)
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag, labelFlag, keyTypeFlag(&keyType)},
+				},
+				{
+					Name:  "list",
+					Usage: "list -c {cfgPath} -n {node} : list the stored keys with their balances",
+					Action: func(ctx *cli.Context) error {
+						execWalletList(ctx, cfgPath, nodeDb)
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag, cli.StringFlag{Name: "n", Destination: &nodeDb}},
+				},
+				{
+					Name:  "import-key",
+					Usage: "import-key -c {cfgPath} --label {label} --key {hexPrivateKey} --key-type {type}",
+					Action: func(ctx *cli.Context) error {
+						execWalletImportKey(ctx, cfgPath, label, privKey, keyType)
+						return nil
+					},
+					Flags: []cli.Flag{
+						cfgFlag,
+						labelFlag,
+						keyTypeFlag(&keyType),
+						cli.StringFlag{Name: "key", Destination: &privKey},
+					},
+				},
+				{
+					Name:      "export-key",
+					Usage:     "export-key -c {cfgPath} {address|label} : print the hex private key",
+					ArgsUsage: "ADDRESS|LABEL",
+					Action: func(ctx *cli.Context) error {
+						execWalletExportKey(ctx, cfgPath, ctx.Args().First())
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag},
+				},
+				{
+					Name:      "set-default",
+					Usage:     "set-default -c {cfgPath} {address|label} : use the key as the node's wallet",
+					ArgsUsage: "ADDRESS|LABEL",
+					Action: func(ctx *cli.Context) error {
+						execWalletSetDefault(ctx, cfgPath, ctx.Args().First())
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag},
+				},
+				{
+					Name:  "watch",
+					Usage: "watch -c {cfgPath} --label {label} --address {address} | --pubkey {hexPublicKey}",
+					Action: func(ctx *cli.Context) error {
+						execWalletWatch(ctx, cfgPath, label, address, pubKey)
+						return nil
+					},
+					Flags: []cli.Flag{
+						cfgFlag,
+						labelFlag,
+						cli.StringFlag{Name: "address", Destination: &address},
+						cli.StringFlag{Name: "pubkey", Destination: &pubKey},
+					},
+				},
+				{
+					Name:      "history",
+					Usage:     "history -c {cfgPath} -n {node} {address|label} : list the payments of a stored wallet",
+					ArgsUsage: "ADDRESS|LABEL",
+					Action: func(ctx *cli.Context) error {
+						execWalletHistory(ctx, cfgPath, nodeDb, ctx.Args().First())
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag, cli.StringFlag{Name: "n", Destination: &nodeDb}},
+				},
+				{
+					Name:  "hd-init",
+					Usage: "hd-init -c {cfgPath} --words {12|24} --account {N} : create the seed of the HD keys",
+					Action: func(ctx *cli.Context) error {
+						execWalletHDInit(ctx, cfgPath, words, uint32(account))
+						return nil
+					},
+					Flags: []cli.Flag{
+						cfgFlag,
+						accountFlag,
+						cli.IntFlag{Name: "words", Value: 12, Destination: &words},
+					},
+				},
+				{
+					Name:  "restore",
+					Usage: "restore -c {cfgPath} -n {node} --mnemonic {words} --gap {N} : restore the HD keys",
+					Action: func(ctx *cli.Context) error {
+						execWalletRestore(ctx, cfgPath, nodeDb, mnemonic, uint32(account), gapLimit)
+						return nil
+					},
+					Flags: []cli.Flag{
+						cfgFlag,
+						accountFlag,
+						cli.StringFlag{Name: "n", Destination: &nodeDb},
+						cli.StringFlag{Name: "mnemonic", Destination: &mnemonic},
+						cli.IntFlag{Name: "gap", Value: HD_GAP_LIMIT, Destination: &gapLimit},
+					},
+				},
+				{
+					Name:      "split",
+					Usage:     "split -c {cfgPath} --shares {N} --threshold {K} -o {dir} [address|label|hd-seed] : back up a key as K-of-N shares",
+					ArgsUsage: "[ADDRESS|LABEL|" + HD_SEED_LABEL + "]",
+					Action: func(ctx *cli.Context) error {
+						execWalletSplit(ctx, cfgPath, ctx.Args().First(), shares, threshold, shareDir)
+						return nil
+					},
+					Flags: []cli.Flag{
+						cfgFlag,
+						cli.IntFlag{Name: "shares", Value: 5, Destination: &shares},
+						cli.IntFlag{Name: "threshold", Value: 3, Destination: &threshold},
+						cli.StringFlag{Name: "o", Usage: "directory of the share files (default: shares)", Destination: &shareDir},
+					},
+				},
+				{
+					Name:      "combine",
+					Usage:     "combine -c {cfgPath} --account {N} {share} {share} ... : rebuild a key (or the HD seed) from its shares",
+					ArgsUsage: "SHARE_FILE|SHARE ...",
+					Action: func(ctx *cli.Context) error {
+						execWalletCombine(ctx, cfgPath, uint32(account), ctx.Args())
+						return nil
+					},
+					Flags: []cli.Flag{cfgFlag, accountFlag},
+				},
+			},
+		},
+	}...)
+	app.Flags = append(app.Flags, []cli.Flag{
+		cli.IntFlag{
+			Name:        "passphrase-fd",
+			Value:       -1,
+			Usage:       "Read the wallet's passphrases line by line from file descriptor `FD`",
+			Destination: &passphraseFd,
+		},
+	}...)
+}
+
+// signMessageCLI groups the commands signing and verifying messages with a wallet's key.
+func signMessageCLI(app *cli.App) {
+	var cfgPath, address, signature, message string
+	cfgFlag := cli.StringFlag{Name: "c", Destination: &cfgPath}
+	addrFlag := cli.StringFlag{Name: "address", Destination: &address}
+	msgFlag := cli.StringFlag{Name: "message", Destination: &message}
+
+	app.Commands = append(app.Commands, []cli.Command{
+		{
+			Name:    "sign-message",
+			Aliases: []string{"sm"},
+			Usage:   "sm -c {cfgPath} --address {address|label} --message {text}",
+			Action: func(ctx *cli.Context) error {
+				execSignMessage(ctx, cfgPath, address, message)
+				return nil
+			},
+			Flags: []cli.Flag{cfgFlag, addrFlag, msgFlag},
+		},
+		{
+			Name:    "verify-message",
+			Aliases: []string{"vm"},
+			Usage:   "vm -c {cfgPath} --address {address} --signature {base64} --message {text}",
+			Action: func(ctx *cli.Context) error {
+				execVerifyMessage(ctx, cfgPath, address, signature, message)
+				return nil
+			},
+			Flags: []cli.Flag{
+				cfgFlag,
+				addrFlag,
+				msgFlag,
+				cli.StringFlag{Name: "signature", Destination: &signature},
+			},
+		},
+	}...)
+}
+
+// keyTypeFlag returns the flag selecting the signature scheme of a new key.
+func keyTypeFlag(keyType *string) cli.StringFlag {
+	return cli.StringFlag{
+		Name:        "key-type",
+		Usage:       "signature scheme of the key: p256 (default), secp256k1 or ed25519",
+		Destination: keyType,
+	}
+}
+
+// execWalletLock encrypts the plain text private key of the given config, and the ones
+// of the other keys of its wallet store, with the same passphrase.
+// This is also the migration path of the configs created before the keystore existed.
+func execWalletLock(ctx *cli.Context, cfgPath string) {
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+	var plainEntries []*WalletEntry
+	for idx := range ws.Entries {
+		entry := &ws.Entries[idx]
+		if entry.Wallet.Address != cfg.WJson.Address && entry.Path == "" && !entry.WatchOnly && entry.Wallet.PrivateKey != "" {
+			plainEntries = append(plainEntries, entry)
+		}
+	}
+	if cfg.WJson.Keystore != "" && len(plainEntries) == 0 {
+		Error.Printf("Wallet is already locked in %s", cfg.WJson.Keystore)
+		os.Exit(1)
+	}
+	if cfg.WJson.Keystore == "" && cfg.WJson.PrivateKey == "" && len(plainEntries) == 0 {
+		Error.Print("Configuration does not contain any private key!")
+		os.Exit(1)
+	}
+
+	passphrase := readNewPassphrase("New passphrase")
+	if cfg.WJson.Keystore == "" && cfg.WJson.PrivateKey != "" {
+		ksPath := keystorePath(resolveCfgPath(cfgPath))
+		if err := lockWalletJson(&cfg.WJson, ksPath, passphrase); err != nil {
+			Error.Printf("Cannot lock wallet: %v", err)
+			os.Exit(1)
+		}
+		cfg.ExportNetworkCfg(cfgPath)
+		ws.Put(cfg.WJson)
+		fmt.Printf("Wallet %s is locked in : * %s *\n", cfg.WJson.Address, ksPath)
+	}
+	for _, entry := range plainEntries {
+		ksPath := walletKeystorePath(resolveCfgPath(cfgPath), entry.Wallet.Address)
+		if err := lockWalletJson(&entry.Wallet, ksPath, passphrase); err != nil {
+			Error.Printf("Cannot lock wallet %s: %v", entry.Label, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wallet %s (%s) is locked in : * %s *\n", entry.Wallet.Address, entry.Label, ksPath)
+	}
+	ws.Save()
+}
+
+// execWalletUnlock writes the decrypted private key back into the given config.
+func execWalletUnlock(ctx *cli.Context, cfgPath string) {
+	cfg := loadNwCfg(cfgPath)
+	if cfg.WJson.Keystore == "" {
+		Error.Print("Wallet is not locked!")
+		os.Exit(1)
+	}
+
+	ksPath := cfg.WJson.Keystore
+	cfg.WJson = *unlockKeystore(&cfg.WJson)
+	cfg.ExportNetworkCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+	ws.Put(cfg.WJson)
+	ws.Save()
+	if err := os.Remove(ksPath); err != nil {
+		Warning.Printf("Cannot remove keystore %s: %v", ksPath, err)
+	}
+	fmt.Printf("Wallet %s is unlocked, its private key is stored in plain text again.\n", cfg.WJson.Address)
+}
+
+// execWalletChangePassphrase re-encrypts the keystore of the given config.
+func execWalletChangePassphrase(ctx *cli.Context, cfgPath string) {
+	cfg := loadNwCfg(cfgPath)
+	if cfg.WJson.Keystore == "" {
+		Error.Print("Wallet is not locked, run `wallet lock` first!")
+		os.Exit(1)
+	}
+
+	plainWallet := unlockKeystore(&cfg.WJson)
+	ks, err := encryptWallet(plainWallet, readNewPassphrase("New passphrase"))
+	if err != nil {
+		Error.Printf("Cannot encrypt wallet: %v", err)
+		os.Exit(1)
+	}
+	if err = ks.Export(cfg.WJson.Keystore); err != nil {
+		Error.Printf("Cannot write keystore %s: %v", cfg.WJson.Keystore, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Passphrase of wallet %s is changed.\n", cfg.WJson.Address)
+}
+
+// addStoreWallet stores the given wallet under the label. The key is locked in its own
+// keystore when the node's default wallet is locked too.
+func addStoreWallet(cfgPath, label string, w *Wallet) {
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+
+	wj := w.ToJson()
+	entry, err := ws.Add(label, *wj)
+	if err != nil {
+		Error.Printf("Cannot add wallet: %v", err)
+		os.Exit(1)
+	}
+	if cfg.WJson.Keystore != "" {
+		ksPath := walletKeystorePath(resolveCfgPath(cfgPath), wj.Address)
+		if err := lockWalletJson(wj, ksPath, readNewPassphrase("Passphrase for "+wj.Address)); err != nil {
+			Error.Printf("Cannot lock wallet: %v", err)
+			os.Exit(1)
+		}
+		ws.Put(*wj)
+	}
+	ws.Save()
+	fmt.Printf("Wallet %s (%s) is added to : * %s *\n", entry.Wallet.Address, entry.Label, ws.path)
+}
+
+// execWalletNew generates a new key into the wallet store. Once the store has an HD seed,
+// the key is the next receiving address derived from it (HD keys are P-256 ones only).
+func execWalletNew(ctx *cli.Context, cfgPath, label, keyType string) {
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+	if ws.HD == nil || keyType != "" {
+		w, err := newWalletOf(keyType)
+		if err != nil {
+			Error.Print(err)
+			os.Exit(1)
+		}
+		addStoreWallet(cfgPath, label, w)
+		return
+	}
+
+	entry, _, err := ws.AddHD(HD_EXTERNAL_CHAIN, label)
+	if err != nil {
+		Error.Printf("Cannot derive wallet: %v", err)
+		os.Exit(1)
+	}
+	ws.Save()
+	fmt.Printf("Wallet %s (%s, %s) is added to : * %s *\n", entry.Wallet.Address, entry.Label, entry.Path, ws.path)
+}
+
+// execWalletImportKey adds an existing hex-encoded private key to the wallet store.
+func execWalletImportKey(ctx *cli.Context, cfgPath, label, privKey, keyType string) {
+	w, err := importPrivKey(keyType, privKey)
+	if err != nil {
+		Error.Printf("Invalid private key: %v", err)
+		os.Exit(1)
+	}
+	addStoreWallet(cfgPath, label, w)
+}
+
+// execWalletExportKey prints the private key of a stored wallet.
+func execWalletExportKey(ctx *cli.Context, cfgPath, ref string) {
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+	entry, ok := ws.Find(ref)
+	if !ok {
+		Error.Printf("Unknown wallet %q", ref)
+		os.Exit(1)
+	}
+	if entry.WatchOnly {
+		Error.Printf("%v: %s", ErrWatchOnly, ref)
+		os.Exit(1)
+	}
+	if entry.Path != "" {
+		fmt.Printf("%s\n", ws.Wallet(entry).ToJson().PrivateKey)
+		return
+	}
+	fmt.Printf("%s\n", entry.Wallet.Unlock().PrivateKey)
+}
+
+// execWalletSetDefault makes a stored wallet the node's wallet (`wallet` section of the config).
+func execWalletSetDefault(ctx *cli.Context, cfgPath, ref string) {
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+	entry, ok := ws.Find(ref)
+	if !ok {
+		Error.Printf("Unknown wallet %q", ref)
+		os.Exit(1)
+	}
+	if entry.WatchOnly {
+		Error.Printf("%v: %s", ErrWatchOnly, ref)
+		os.Exit(1)
+	}
+
+	wj := entry.Wallet
+	if entry.Path != "" {
+		// The node's wallet must hold its private key, HD keys are materialized first.
+		wj = *ws.Wallet(entry).ToJson()
+		if cfg.WJson.Keystore != "" {
+			ksPath := walletKeystorePath(resolveCfgPath(cfgPath), wj.Address)
+			if err := lockWalletJson(&wj, ksPath, readNewPassphrase("Passphrase for "+wj.Address)); err != nil {
+				Error.Printf("Cannot lock wallet: %v", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	ws.Default = entry.Wallet.Address
+	ws.Save()
+	cfg.WJson = wj
+	cfg.ExportNetworkCfg(cfgPath)
+	fmt.Printf("Default wallet is now %s (%s)\n", entry.Wallet.Address, entry.Label)
+}
+
+// execWalletList prints the stored wallets, with their balances when a chain DB is given.
+func execWalletList(ctx *cli.Context, cfgPath, nodeDb string) {
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+
+	var uTxOs *UTxOSet
+	if nodeDb != "" {
+		bc := getLocalBC(nodeDb)
+		if bc == nil {
+			Error.Print("Local blockchain not found. Need one existed first!")
+			os.Exit(1)
+		}
+		defer bc.DB.Close()
+		uTxOs = &UTxOSet{Blockchain: bc}
+		uTxOs.Rearrange()
+	}
+
+	for _, entry := range ws.Sorted() {
+		marker := " "
+		if entry.Wallet.Address == ws.Default {
+			marker = "*"
+		}
+		balance := "?"
+		if uTxOs != nil {
+			balance = strconv.Itoa(uTxOs.GetTotalValOwnedBy(addrPubKeyHash(entry.Wallet.Address)))
+		}
+		kind := entry.Path
+		if entry.WatchOnly {
+			kind = "watch-only"
+		}
+		createdAt := time.Unix(entry.CreatedAt, 0).Format("2006-01-02 15:04:05")
+		fmt.Printf("%s %-16s %-36s %8s coins  %s  %s\n", marker, entry.Label, entry.Wallet.Address, balance, createdAt, kind)
+	}
+}
+
+// initStoreHD stores the seed of the given mnemonic in the wallet store of the config.
+func initStoreHD(cfgPath, mnemonic string, account uint32) (*Config, *WalletStore) {
+	seed, err := mnemonicToSeed(mnemonic, "")
+	if err != nil {
+		Error.Printf("Cannot use mnemonic: %v", err)
+		os.Exit(1)
+	}
+	return initStoreHDSeed(cfgPath, seed, account)
+}
+
+// initStoreHDSeed stores the HD seed in the wallet store of the config.
+// The seed is encrypted when the node's default wallet is locked.
+func initStoreHDSeed(cfgPath string, seed []byte, account uint32) (*Config, *WalletStore) {
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+	var passphrase []byte
+	if cfg.WJson.Keystore != "" {
+		passphrase = readNewPassphrase("Passphrase for the HD seed")
+	}
+	ksPath := walletKeystorePath(resolveCfgPath(cfgPath), HD_SEED_LABEL)
+	if err := ws.InitHD(seed, account, ksPath, passphrase); err != nil {
+		Error.Printf("Cannot initialize HD wallet: %v", err)
+		os.Exit(1)
+	}
+	return cfg, ws
+}
+
+// execWalletHDInit creates a new seed and prints its mnemonic phrase as the backup of all the HD keys.
+func execWalletHDInit(ctx *cli.Context, cfgPath string, words int, account uint32) {
+	mnemonic, err := newMnemonic(words * MNEMONIC_BITS_PER_WD * 32 / 33)
+	if err != nil {
+		Error.Printf("Cannot create mnemonic: %v", err)
+		os.Exit(1)
+	}
+
+	_, ws := initStoreHD(cfgPath, mnemonic, account)
+	entry, _, err := ws.AddHD(HD_EXTERNAL_CHAIN, "")
+	if err != nil {
+		Error.Printf("Cannot derive wallet: %v", err)
+		os.Exit(1)
+	}
+	ws.Save()
+
+	fmt.Printf("Write down the mnemonic phrase, it is the only backup of the HD keys:\n\n  %s\n\n", mnemonic)
+	fmt.Printf("First address: %s (%s)\n", entry.Wallet.Address, entry.Path)
+}
+
+// execWalletRestore restores the HD keys of a mnemonic phrase. Both the external and the change
+// chains are derived until `gapLimit` consecutive addresses were never used in the local chain.
+func execWalletRestore(ctx *cli.Context, cfgPath, nodeDb, mnemonic string, account uint32, gapLimit int) {
+	if gapLimit <= 0 {
+		Error.Print("Gap limit must be positive!")
+		os.Exit(1)
+	}
+	bc := getLocalBC(nodeDb)
+	if bc == nil {
+		Error.Print("Local blockchain not found. Need one existed first!")
+		os.Exit(1)
+	}
+	defer bc.DB.Close()
+	used := bc.FindUsedPubKeyHashes()
+
+	_, ws := initStoreHD(cfgPath, mnemonic, account)
+	restored := 0
+	for _, chain := range []uint32{HD_EXTERNAL_CHAIN, HD_CHANGE_CHAIN} {
+		for idx, unused := uint32(0), 0; unused < gapLimit; idx++ {
+			_, w := ws.HD.Derive(chain, idx)
+			if !used[hex.EncodeToString(hashPubKey(w.PublicKey))] {
+				unused++
+				continue
+			}
+
+			// Every key up to the used one is stored, so that the indexes stay contiguous.
+			unused = 0
+			for ws.HD.Next[chain] <= idx {
+				if _, _, err := ws.AddHD(chain, ""); err != nil {
+					Warning.Printf("Skip derived wallet: %v", err)
+					ws.HD.Next[chain]++
+				}
+				restored++
+			}
+		}
+	}
+
+	// Always leave a fresh receiving address.
+	entry, _, err := ws.AddHD(HD_EXTERNAL_CHAIN, "")
+	if err != nil {
+		Error.Printf("Cannot derive wallet: %v", err)
+		os.Exit(1)
+	}
+	ws.Save()
+	fmt.Printf("%d used wallet(s) are restored to : * %s *\n", restored, ws.path)
+	fmt.Printf("Next receiving address: %s (%s)\n", entry.Wallet.Address, entry.Path)
+}
+
+// execSignMessage prints the signature of the message by the stored wallet of the given address.
+func execSignMessage(ctx *cli.Context, cfgPath, address, message string) {
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+	if address == "" {
+		address = ws.Default
+	}
+	entry, ok := ws.Find(address)
+	if !ok {
+		Error.Printf("Unknown wallet %q", address)
+		os.Exit(1)
+	}
+
+	signature, err := signWalletMessage(ws.Wallet(entry), message)
+	if err != nil {
+		Error.Printf("Cannot sign message: %v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s\n", signature)
+}
+
+// execVerifyMessage checks the signature of the message, the config only selects the network.
+func execVerifyMessage(ctx *cli.Context, cfgPath, address, signature, message string) {
+	if cfgPath != "" {
+		loadNwCfg(cfgPath)
+	}
+	if err := verifyWalletMessage(address, signature, message); err != nil {
+		Error.Printf("Verification failed: %v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Message is signed by %s\n", address)
+}
+
+// execWalletWatch adds a watch-only entry from an address or a hex-encoded public key.
+func execWalletWatch(ctx *cli.Context, cfgPath, label, address, pubKeyHex string) {
+	var pubKey []byte
+	if pubKeyHex != "" {
+		var err error
+		if pubKey, err = hex.DecodeString(pubKeyHex); err != nil || len(pubKey) == 0 {
+			Error.Printf("Invalid public key: %v", err)
+			os.Exit(1)
+		}
+	} else if address == "" {
+		Error.Print("Either --address or --pubkey is required!")
+		os.Exit(1)
+	}
+
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+	entry, err := ws.AddWatch(label, address, pubKey)
+	if err != nil {
+		Error.Printf("Cannot watch wallet: %v", err)
+		os.Exit(1)
+	}
+	ws.Save()
+	fmt.Printf("Wallet %s (%s) is watched from : * %s *\n", entry.Wallet.Address, entry.Label, ws.path)
+}
+
+// execWalletHistory prints the payments received and spent by a stored wallet.
+func execWalletHistory(ctx *cli.Context, cfgPath, nodeDb, ref string) {
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+	entry, ok := ws.Find(ref)
+	if !ok {
+		Error.Printf("Unknown wallet %q", ref)
+		os.Exit(1)
+	}
+	bc := getLocalBC(nodeDb)
+	if bc == nil {
+		Error.Print("Local blockchain not found. Need one existed first!")
+		os.Exit(1)
+	}
+	defer bc.DB.Close()
+
+	balance := 0
+	for _, event := range bc.FindHistory([]string{entry.Wallet.Address}) {
+		if event.Type == WATCH_EVENT_SPEND {
+			balance -= event.Value
+		} else {
+			balance += event.Value
+		}
+		fmt.Println(event.Stringify())
+	}
+	fmt.Printf("Balance of %s (%s): %d coins\n", entry.Wallet.Address, entry.Label, balance)
+}
+
+// execWalletSplit writes the K-of-N shares of a private key (the node's wallet by default)
+// or of the HD seed, one file per share.
+func execWalletSplit(ctx *cli.Context, cfgPath, ref string, shares, threshold int, shareDir string) {
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+
+	var kind, keyTag byte
+	var secret []byte
+	switch ref {
+	case HD_SEED_LABEL:
+		if ws.HD == nil {
+			Error.Print("The wallet store has no HD seed!")
+			os.Exit(1)
+		}
+		kind, secret = SHARE_KIND_SEED, ws.HD.Unlock()
+	default:
+		var w *Wallet
+		if ref == "" {
+			w = cfg.WJson.Unlock().ToWallet()
+		} else {
+			entry, ok := ws.Find(ref)
+			if !ok {
+				Error.Printf("Unknown wallet %q", ref)
+				os.Exit(1)
+			}
+			w = ws.Wallet(entry)
+		}
+		if w.WatchOnly {
+			Error.Printf("%v: %s", ErrWatchOnly, w.Address)
+			os.Exit(1)
+		}
+		privKey, _ := hex.DecodeString(w.ToJson().PrivateKey)
+		kind, keyTag, secret = SHARE_KIND_KEY, keyTagOf(w.KeyType), privKey
+	}
+
+	walletShares, err := splitWalletSecret(kind, keyTag, secret, shares, threshold)
+	if err != nil {
+		Error.Printf("Cannot split wallet: %v", err)
+		os.Exit(1)
+	}
+	if shareDir == "" {
+		shareDir = SHARE_DIR
+	}
+	if err := os.MkdirAll(shareDir, 0700); err != nil {
+		Error.Print(err)
+		os.Exit(1)
+	}
+	for _, share := range walletShares {
+		sharePath := filepath.ToSlash(filepath.Join(shareDir, fmt.Sprintf("share-%d-of-%d.txt", share.X, shares)))
+		if err := ioutil.WriteFile(sharePath, []byte(share.Encode()+"\n"), 0600); err != nil {
+			Error.Print(err)
+			os.Exit(1)
+		}
+		fmt.Println(sharePath)
+	}
+	fmt.Printf("Any %d of the %d shares rebuild the key: keep each of them in a distinct place.\n", threshold, shares)
+}
+
+// execWalletCombine rebuilds a private key (made the node's wallet) or the HD seed from its shares.
+func execWalletCombine(ctx *cli.Context, cfgPath string, account uint32, refs []string) {
+	var walletShares []WalletShare
+	for _, ref := range refs {
+		share, err := readWalletShare(ref)
+		if err != nil {
+			Error.Printf("Cannot read share %s: %v", ref, err)
+			os.Exit(1)
+		}
+		walletShares = append(walletShares, *share)
+	}
+	secret, err := combineWalletShares(walletShares)
+	if err != nil {
+		Error.Printf("Cannot combine shares: %v", err)
+		os.Exit(1)
+	}
+
+	if walletShares[0].Kind == SHARE_KIND_SEED {
+		_, ws := initStoreHDSeed(cfgPath, secret, account)
+		entry, _, err := ws.AddHD(HD_EXTERNAL_CHAIN, "")
+		if err != nil {
+			Error.Printf("Cannot derive wallet: %v", err)
+			os.Exit(1)
+		}
+		ws.Save()
+		fmt.Printf("HD seed is restored to : * %s *\n", ws.path)
+		fmt.Printf("Next receiving address: %s (%s)\n", entry.Wallet.Address, entry.Path)
+		return
+	}
+
+	w, err := walletFromPrivKey(keyTypeOfTag(walletShares[0].KeyTag), secret)
+	if err != nil {
+		Error.Printf("Cannot rebuild wallet: %v", err)
+		os.Exit(1)
+	}
+	cfg := loadNwCfg(cfgPath)
+	ws := openWalletStore(cfgPath, cfg)
+	wj := w.ToJson()
+	if cfg.WJson.Keystore != "" {
+		ksPath := walletKeystorePath(resolveCfgPath(cfgPath), wj.Address)
+		if err := lockWalletJson(wj, ksPath, readNewPassphrase("Passphrase for "+wj.Address)); err != nil {
+			Error.Printf("Cannot lock wallet: %v", err)
+			os.Exit(1)
+		}
+	}
+	if _, ok := ws.Find(wj.Address); ok {
+		ws.Put(*wj)
+	} else if _, err := ws.Add("", *wj); err != nil {
+		Error.Printf("Cannot add wallet: %v", err)
+		os.Exit(1)
+	}
+	ws.Default = wj.Address
+	ws.Save()
+
+	cfg.WJson = *wj
+	cfg.ExportNetworkCfg(cfgPath)
+	fmt.Printf("Wallet %s is rebuilt and exported to : * %s *\n", wj.Address, cfgPath)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Command registry: each command served by the node is registered with the payload types of its
+// request and response, its handler, the services the node must provide to serve it, its rate-limit
+// class and whether it runs on the worker pool or is reserved to the local host. `handleMsg`
+// dispatches the requests through the registry, refusing the unknown commands with a REJECT, so a
+// subsystem adds its commands by calling `registerCmd` from its own file (see reload.go).
+
+var (
+	ErrUnknownCmd      = errors.New("unknown command")
+	ErrServiceDisabled = errors.New("service not provided by the node")
+)
+
+// Command describes a command served by the node.
+type Command struct {
+	Name      string // Command of the request.
+	Response  string // Command of the response (none if empty).
+	Services  uint64 // SERVICE_* flags the node must provide.
+	Class     string // Rate-limit class (not limited if empty).
+	Expensive bool   // Handled by the worker pool.
+	Admin     bool   // Only accepted from the local host.
+
+	handle func(req *Request) // Decodes the request, runs the handler and responds.
+}
+
+// Request is a request received by the node, handed to the command's handler.
+type Request struct {
+	Conn *reqConn
+	BC   *Blockchain
+	Msg  *Message
+	Key  string // Key of the requesting peer.
+}
+
+// Payload encodes and decodes the payloads of type T, according to the codec of the connection.
+type Payload[T any] struct {
+	Encode func(value T, codec WireCodec) []byte
+	Decode func(data []byte) (T, error)
+}
+
+// Commands served by the node, by name.
+var (
+	commandsMu sync.RWMutex
+	commands   = make(map[string]*Command)
+)
+
+// Payloads of the commands.
+var (
+	noPayload = Payload[struct{}]{
+		Encode: func(struct{}, WireCodec) []byte { return []byte{} },
+		Decode: func([]byte) (struct{}, error) { return struct{}{}, nil },
+	}
+	rawPayload = Payload[[]byte]{
+		Encode: func(data []byte, _ WireCodec) []byte { return data },
+		Decode: func(data []byte) ([]byte, error) { return data, nil },
+	}
+	stringPayload = Payload[string]{
+		Encode: func(s string, _ WireCodec) []byte { return []byte(s) },
+		Decode: func(data []byte) (string, error) { return string(data), nil },
+	}
+	intPayload = Payload[int]{
+		Encode: func(n int, _ WireCodec) []byte { return Itobytes(n) },
+		Decode: func(data []byte) (int, error) { return strconv.Atoi(string(data)) },
+	}
+	boolPayload = Payload[bool]{
+		Encode: func(b bool, _ WireCodec) []byte { return []byte(strconv.FormatBool(b)) },
+		Decode: func(data []byte) (bool, error) { return strconv.ParseBool(string(data)) },
+	}
+	invPayload = Payload[[]InvItem]{
+		Encode: jsonPayload[[]InvItem]().Encode,
+		Decode: deserializeInv,
+	}
+	headerPayload = Payload[*Header]{
+		Encode: func(header *Header, _ WireCodec) []byte { return header.Serialize() },
+		Decode: deserializeHeader,
+	}
+	txPayload = Payload[*Transaction]{
+		Encode: func(tx *Transaction, _ WireCodec) []byte { return tx.Serialize() },
+		Decode: DeserializeTx,
+	}
+	blockPayload = Payload[*Block]{
+		Encode: func(block *Block, codec WireCodec) []byte {
+			if codec.Binary {
+				return encodeCompactBlock(block)
+			}
+			return block.Serialize()
+		},
+		Decode: decodeWireBlock,
+	}
+	blocksPayload = Payload[[]*Block]{
+		Encode: func(blocks []*Block, codec WireCodec) []byte {
+			if codec.Binary {
+				return encodeCompactBlocks(blocks)
+			}
+			return jsonPayload[[]*Block]().Encode(blocks, codec)
+		},
+		Decode: decodeWireBlocks,
+	}
+	invDataPayload = Payload[*InvData]{
+		Encode: func(data *InvData, codec WireCodec) []byte {
+			if codec.Binary {
+				return encodeCompactInvData(data)
+			}
+			return jsonPayload[*InvData]().Encode(data, codec)
+		},
+		Decode: decodeWireInvData,
+	}
+)
+
+// Utility functions start from here.
+
+// jsonPayload returns the JSON encoding of the payloads of type T.
+func jsonPayload[T any]() Payload[T] {
+	return Payload[T]{
+		Encode: func(value T, _ WireCodec) []byte {
+			data, err := json.Marshal(value)
+			if err != nil {
+				Error.Panic("Marshal Failed!\n")
+			}
+			return data
+		},
+		Decode: func(data []byte) (T, error) {
+			var value T
+			err := json.Unmarshal(data, &value)
+			return value, err
+		},
+	}
+}
+
+// hashesPayload returns the JSON encoding of the lists of hashes, up to `max` of them.
+func hashesPayload(max int) Payload[[][]byte] {
+	payload := jsonPayload[[][]byte]()
+	decode := payload.Decode
+	payload.Decode = func(data []byte) ([][]byte, error) {
+		hashes, err := decode(data)
+		if err == nil && len(hashes) > max {
+			err = fmt.Errorf("%d hashes exceed %d", len(hashes), max)
+		}
+		return hashes, err
+	}
+	return payload
+}
+
+// registerCmd registers the command, decoding its requests with `reqPayload` and encoding the
+// responses of `handle` with `resPayload`. An error of the handler is answered with a REJECT.
+// It panics if the command is registered twice.
+func registerCmd[Req, Res any](cmd Command, reqPayload Payload[Req], resPayload Payload[Res],
+	handle func(req *Request, payload Req) (Res, error)) {
+
+	cmd.handle = func(req *Request) {
+		payload, err := reqPayload.Decode(req.Msg.Data)
+		if err != nil {
+			err = fmt.Errorf("malformed %s payload: %v", cmd.Name, err)
+			getPeerManager().Misbehave(req.Key, MISBEHAVIOUR_MALFORMED_MSG, err.Error())
+			rejectPeer(req.Conn, err)
+			return
+		}
+		res, err := handle(req, payload)
+		if err != nil {
+			rejectPeer(req.Conn, err)
+			return
+		}
+		if cmd.Response != "" {
+			respond(req.Conn, createMsg(cmd.Response, resPayload.Encode(res, connCodec(req.Conn))))
+		}
+	}
+
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	if _, ok := commands[cmd.Name]; ok {
+		panic(fmt.Sprintf("command %s registered twice", cmd.Name))
+	}
+	commands[cmd.Name] = &cmd
+}
+
+// lookupCmd returns the registered command of the given name.
+func lookupCmd(name string) (*Command, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	cmd, ok := commands[name]
+	return cmd, ok
+}
+
+// cmdClass returns the rate-limit class of the command (empty if not limited).
+func cmdClass(name string) string {
+	if cmd, ok := lookupCmd(name); ok {
+		return cmd.Class
+	}
+	return ""
+}
+
+// isExpensiveCmd returns true if the command is handled by the worker pool.
+func isExpensiveCmd(name string) bool {
+	cmd, ok := lookupCmd(name)
+	return ok && cmd.Expensive
+}
+
+// localServices returns the services provided by the local node.
+func localServices() uint64 {
+	services, _ := parseServices(nil)
+	if cfg := getNetworkCfg(); cfg != nil {
+		services, _ = parseServices(cfg.Network.Services)
+	}
+	return services
+}
+
+// handleMsg handles the request of any connected node with its registered command.
+func handleMsg(conn *reqConn, bc *Blockchain, msg *Message, key string) {
+	cmd, ok := lookupCmd(msg.Cmd)
+	if !ok {
+		Info.Printf("Unknown command %q from %s", msg.Cmd, conn.RemoteAddr())
+		getPeerManager().Misbehave(key, MISBEHAVIOUR_UNKNOWN_CMD, fmt.Sprintf("unknown command %q", msg.Cmd))
+		rejectPeer(conn, fmt.Errorf("%w %q", ErrUnknownCmd, msg.Cmd))
+		return
+	}
+	if cmd.Admin && !isAdminConn(conn) {
+		rejectPeer(conn, ErrNotAdmin)
+		return
+	}
+	if missing := cmd.Services &^ localServices(); missing != 0 {
+		names := (&VersionPayload{Services: missing}).ServiceNames()
+		rejectPeer(conn, fmt.Errorf("%w: %s", ErrServiceDisabled, strings.Join(names, ", ")))
+		return
+	}
+	cmd.handle(&Request{Conn: conn, BC: bc, Msg: msg, Key: key})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const testEchoCmd = "TEST_ECHO"
+
+// reqTestCmd sends the request to the node and checks the command of its response.
+func reqTestCmd(msg *Message, node Node, resCmd string) (*Message, error) {
+	res, err := reqNeighbor(msg, node)
+	if err != nil {
+		return nil, err
+	}
+	return res, checkMsgCmd(res, resCmd)
+}
+
+func TestCommandRegistry(t *testing.T) {
+	if _, ok := lookupCmd(testEchoCmd); !ok {
+		registerCmd(Command{Name: testEchoCmd, Response: testEchoCmd, Class: CLASS_QUERY}, rawPayload, rawPayload,
+			func(req *Request, data []byte) ([]byte, error) {
+				if len(data) == 0 {
+					return nil, fmt.Errorf("nothing to echo")
+				}
+				return data, nil
+			})
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Registering %s twice should panic", testEchoCmd)
+			}
+		}()
+		registerCmd(Command{Name: testEchoCmd}, noPayload, noPayload, handlePing)
+	}()
+
+	if class := cmdClass(CReqBlocks); class != CLASS_SYNC {
+		t.Errorf("Expected %s to be limited as %q, got %q", CReqBlocks, CLASS_SYNC, class)
+	}
+	if class := cmdClass(CPing); class != "" {
+		t.Errorf("Pings should not be rate limited, got %q", class)
+	}
+	if !isExpensiveCmd(CAddTx) || isExpensiveCmd(CReqDepth) {
+		t.Errorf("Only the expensive commands should run on the workers")
+	}
+	if _, ok := lookupCmd(CReloadCfg); !ok {
+		t.Errorf("The reload command should be registered by its subsystem")
+	}
+
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+	node := serveTestChain(t, bc)
+
+	res, err := reqTestCmd(createMsg(testEchoCmd, []byte("hello")), node, testEchoCmd)
+	if err != nil || !bytes.Equal(res.Data, []byte("hello")) {
+		t.Errorf("Expected the registered command to echo, got %+v, %v", res, err)
+	}
+	if _, err = reqTestCmd(createMsg(testEchoCmd, nil), node, testEchoCmd); err == nil || !strings.Contains(err.Error(), "nothing to echo") {
+		t.Errorf("Error of the handler should be answered with a REJECT, got %v", err)
+	}
+	if _, err = reqTestCmd(createMsg("NO_SUCH_CMD", nil), node, ""); err == nil || !strings.Contains(err.Error(), ErrUnknownCmd.Error()) {
+		t.Errorf("Unknown command should be refused, got %v", err)
+	}
+}
+
+func TestServiceNotProvided(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{Network: Network{Services: []string{"observer"}}})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+	node := serveTestChain(t, bc)
+
+	if _, err := getDepthNeighbor(node); err != nil {
+		t.Errorf("Observer should answer the depth requests: %v", err)
+	}
+	_, err := reqTestCmd(createMsgReqBlock(0), node, CResBlock)
+	if err == nil || !strings.Contains(err.Error(), ErrServiceDisabled.Error()) || !strings.Contains(err.Error(), "storage") {
+		t.Errorf("Observer should refuse the block requests, got %v", err)
+	}
+}
+
+func TestReqPrf(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+	node := serveTestChain(t, bc)
+
+	for _, test := range []struct {
+		prf    []byte
+		expect string
+	}{
+		{bc.GetBlockByDepth(1).GenPrf(), "true"},
+		{[]byte{}, "false"},
+	} {
+		res, err := reqTestCmd(createMsgReqPrf(test.prf), node, CResPrf)
+		if err != nil || string(res.Data) != test.expect {
+			t.Errorf("Proof %q: expected %s, got %+v, %v", test.prf, test.expect, res, err)
+		}
+	}
+}
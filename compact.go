@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Compact blocks: the peers speaking the binary protocol (see `WireCodec`) exchange the blocks of
+// RES_BLOCK, RES_BLOCKS and RES_DATA in a binary form instead of JSON, hashes and signatures being
+// written as raw bytes rather than base64. Integers are varints and byte strings are prefixed by
+// their length plus one (0 standing for nil), so that a decoded block hashes as the original.
+//
+//	COMPACT_FORMAT || header || tx count || txs -> block
+//	COMPACT_FORMAT || block count || blocks (without the format byte) -> blocks
+//
+// JSON data, starting with '{' or '[', is still accepted from the older peers.
+
+const COMPACT_FORMAT = byte(1)
+
+var ErrMalformedCompact = errors.New("malformed compact data")
+
+// compactWriter appends the fields of the compact form to a buffer.
+type compactWriter struct {
+	buf bytes.Buffer
+}
+
+// compactReader reads the fields of the compact form, keeping the first error.
+type compactReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+// Utility functions start from here.
+
+// encodeCompactBlock returns the compact form of the block.
+func encodeCompactBlock(block *Block) []byte {
+	w := new(compactWriter)
+	w.buf.WriteByte(COMPACT_FORMAT)
+	w.Block(block)
+	return w.buf.Bytes()
+}
+
+// encodeCompactBlocks returns the compact form of the blocks.
+func encodeCompactBlocks(blocks []*Block) []byte {
+	w := new(compactWriter)
+	w.buf.WriteByte(COMPACT_FORMAT)
+	w.Uvarint(uint64(len(blocks)))
+	for _, block := range blocks {
+		w.Block(block)
+	}
+	return w.buf.Bytes()
+}
+
+// encodeCompactInvData returns the compact form of the announced items:
+// INV ID (4) || blocks || tx count || serialized txs.
+func encodeCompactInvData(data *InvData) []byte {
+	w := new(compactWriter)
+	w.buf.WriteByte(COMPACT_FORMAT)
+	binary.Write(&w.buf, binary.BigEndian, data.InvID)
+	w.Uvarint(uint64(len(data.Blocks)))
+	for _, block := range data.Blocks {
+		w.Block(block)
+	}
+	w.Uvarint(uint64(len(data.Txs)))
+	for _, tx := range data.Txs {
+		w.Bytes(tx)
+	}
+	return w.buf.Bytes()
+}
+
+// decodeWireBlock decodes a block received from a peer, in the compact form or JSON.
+func decodeWireBlock(data []byte) (*Block, error) {
+	if !isCompact(data) {
+		return decodeBlock(data)
+	}
+	r := newCompactReader(data[1:])
+	block := r.Block()
+	return block, r.Done()
+}
+
+// decodeWireBlocks decodes the blocks received from a peer, in the compact form or JSON.
+func decodeWireBlocks(data []byte) ([]*Block, error) {
+	var blocks []*Block
+	if !isCompact(data) {
+		err := json.Unmarshal(data, &blocks)
+		return blocks, err
+	}
+	r := newCompactReader(data[1:])
+	for i := r.Count(); i > 0 && r.err == nil; i-- {
+		blocks = append(blocks, r.Block())
+	}
+	return blocks, r.Done()
+}
+
+// decodeWireInvData decodes the announced items received from a peer, in the compact form or JSON.
+func decodeWireInvData(data []byte) (*InvData, error) {
+	invData := new(InvData)
+	if !isCompact(data) {
+		err := json.Unmarshal(data, invData)
+		return invData, err
+	}
+	r := newCompactReader(data[1:])
+	if err := binary.Read(r.r, binary.BigEndian, &invData.InvID); err != nil {
+		return nil, ErrMalformedCompact
+	}
+	for i := r.Count(); i > 0 && r.err == nil; i-- {
+		invData.Blocks = append(invData.Blocks, r.Block())
+	}
+	for i := r.Count(); i > 0 && r.err == nil; i-- {
+		invData.Txs = append(invData.Txs, r.Bytes())
+	}
+	return invData, r.Done()
+}
+
+// isCompact returns true if the data is in the compact form rather than JSON.
+func isCompact(data []byte) bool {
+	return len(data) > 0 && data[0] == COMPACT_FORMAT
+}
+
+func newCompactReader(data []byte) *compactReader {
+	return &compactReader{r: bytes.NewReader(data)}
+}
+
+// compactWriter's methods:
+
+func (w *compactWriter) Uvarint(v uint64) {
+	var raw [binary.MaxVarintLen64]byte
+	w.buf.Write(raw[:binary.PutUvarint(raw[:], v)])
+}
+
+func (w *compactWriter) Varint(v int64) {
+	var raw [binary.MaxVarintLen64]byte
+	w.buf.Write(raw[:binary.PutVarint(raw[:], v)])
+}
+
+// Bytes writes the length of the byte string plus one (0 for nil), then its bytes.
+func (w *compactWriter) Bytes(b []byte) {
+	if b == nil {
+		w.Uvarint(0)
+		return
+	}
+	w.Uvarint(uint64(len(b)) + 1)
+	w.buf.Write(b)
+}
+
+// Len writes the length of a list plus one (0 for nil).
+func (w *compactWriter) Len(n int, isNil bool) {
+	if isNil {
+		w.Uvarint(0)
+		return
+	}
+	w.Uvarint(uint64(n) + 1)
+}
+
+func (w *compactWriter) Block(block *Block) {
+	header := block.Header
+	w.Bytes(header.PrevBlockHash)
+	w.Bytes(header.Hash)
+	w.Varint(header.Timestamp)
+	w.Varint(int64(header.Depth))
+	w.Varint(int64(header.Nonce))
+
+	w.Len(len(block.Transactions), block.Transactions == nil)
+	for _, tx := range block.Transactions {
+		w.Bytes(tx.ID)
+		w.Len(len(tx.TxIns), tx.TxIns == nil)
+		for _, in := range tx.TxIns {
+			w.Bytes(in.TxID)
+			w.Varint(int64(in.TxOutIdx))
+			w.Bytes(in.Signature)
+			w.Bytes(in.PubKey)
+		}
+		w.Len(len(tx.TxOuts), tx.TxOuts == nil)
+		for _, out := range tx.TxOuts {
+			w.Varint(int64(out.Value))
+			w.Bytes(out.PubKeyHash)
+		}
+	}
+}
+
+// compactReader's methods:
+
+func (r *compactReader) Uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		r.err = ErrMalformedCompact
+	}
+	return v
+}
+
+func (r *compactReader) Varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(r.r)
+	if err != nil {
+		r.err = ErrMalformedCompact
+	}
+	return v
+}
+
+func (r *compactReader) Bytes() []byte {
+	n, isNil := r.Len()
+	if isNil || r.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.err = ErrMalformedCompact
+	}
+	return b
+}
+
+// Len reads the length of a list, which cannot exceed the bytes left (each item taking one at least).
+func (r *compactReader) Len() (int, bool) {
+	v := r.Uvarint()
+	if v == 0 || r.err != nil {
+		return 0, true
+	}
+	if v-1 > uint64(r.r.Len()) {
+		r.err = ErrMalformedCompact
+		return 0, true
+	}
+	return int(v - 1), false
+}
+
+// Count reads the number of items of a list written by `Uvarint`.
+func (r *compactReader) Count() int {
+	v := r.Uvarint()
+	if v > uint64(r.r.Len()) {
+		r.err = ErrMalformedCompact
+		return 0
+	}
+	return int(v)
+}
+
+func (r *compactReader) Block() *Block {
+	block := new(Block)
+	header := &block.Header
+	header.PrevBlockHash = r.Bytes()
+	header.Hash = r.Bytes()
+	header.Timestamp = r.Varint()
+	header.Depth = int(r.Varint())
+	header.Nonce = int(r.Varint())
+
+	if n, isNil := r.Len(); !isNil {
+		block.Transactions = make([]Transaction, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			block.Transactions = append(block.Transactions, r.Tx())
+		}
+	}
+	return block
+}
+
+func (r *compactReader) Tx() Transaction {
+	tx := Transaction{ID: r.Bytes()}
+	if n, isNil := r.Len(); !isNil {
+		tx.TxIns = make([]TxInput, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			tx.TxIns = append(tx.TxIns, TxInput{
+				TxID:      r.Bytes(),
+				TxOutIdx:  int(r.Varint()),
+				Signature: r.Bytes(),
+				PubKey:    r.Bytes(),
+			})
+		}
+	}
+	if n, isNil := r.Len(); !isNil {
+		tx.TxOuts = make([]TxOutput, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			tx.TxOuts = append(tx.TxOuts, TxOutput{Value: int(r.Varint()), PubKeyHash: r.Bytes()})
+		}
+	}
+	return tx
+}
+
+// Done returns the error met while reading, or if bytes are left.
+func (r *compactReader) Done() error {
+	if r.err == nil && r.r.Len() > 0 {
+		r.err = ErrMalformedCompact
+	}
+	return r.err
+}
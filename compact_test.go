@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// codecBuffer is a stream written with the given codec.
+type codecBuffer struct {
+	bytes.Buffer
+	codec WireCodec
+}
+
+func (buf *codecBuffer) Codec() WireCodec {
+	return buf.codec
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// newTestBlocks returns a chain of blocks holding a coinbase and `txs` signed transactions each,
+// paying each other between a few wallets.
+func newTestBlocks(count, txs int) []*Block {
+	var pubKeys, pubKeyHashes [][]byte
+	for i := 0; i < 4; i++ {
+		pubKeys, pubKeyHashes = append(pubKeys, randomBytes(64)), append(pubKeyHashes, randomBytes(20))
+	}
+	var blocks []*Block
+	prevHash, prevTxID := []byte{}, randomBytes(32)
+	for depth := 1; depth <= count; depth++ {
+		transactions := []Transaction{*newCoinBaseTx(newWallet().Address)}
+		for i := 0; i < txs; i++ {
+			tx := Transaction{
+				ID:     randomBytes(32),
+				TxIns:  []TxInput{{TxID: prevTxID, TxOutIdx: 1, Signature: randomBytes(64), PubKey: pubKeys[i%4]}},
+				TxOuts: []TxOutput{{Value: 10, PubKeyHash: pubKeyHashes[(i+1)%4]}, {Value: 990, PubKeyHash: pubKeyHashes[i%4]}},
+			}
+			transactions = append(transactions, tx)
+			prevTxID = tx.ID
+		}
+		block := newBlock(transactions, prevHash, depth)
+		blocks = append(blocks, block)
+		prevHash = block.Header.Hash
+	}
+	return blocks
+}
+
+func TestCompactBlocks(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	blocks := newTestBlocks(3, 2)
+	blocks[1].Transactions[1].TxIns = []TxInput{}
+	blocks[2].Transactions = nil
+
+	decoded, err := decodeWireBlocks(encodeCompactBlocks(blocks))
+	if err != nil {
+		t.Fatalf("Cannot decode compact blocks: %v", err)
+	}
+	for i, block := range decoded {
+		// The decoded block hashes as the original one.
+		if !bytes.Equal(block.Serialize(), blocks[i].Serialize()) {
+			t.Errorf("Block %d differs once decoded", i)
+		}
+	}
+	if block, err := decodeWireBlock(encodeCompactBlock(blocks[0])); err != nil || !reflect.DeepEqual(block, blocks[0]) {
+		t.Errorf("Compact block differs once decoded: %v", err)
+	}
+
+	// The blocks of the older peers are still accepted in JSON.
+	encoded, _ := json.Marshal(blocks)
+	if decoded, err := decodeWireBlocks(encoded); err != nil || len(decoded) != len(blocks) {
+		t.Errorf("Cannot decode JSON blocks: %v", err)
+	}
+
+	compact := encodeCompactBlocks(blocks)
+	if _, err := decodeWireBlocks(compact[:len(compact)-1]); !errors.Is(err, ErrMalformedCompact) {
+		t.Errorf("Truncated blocks should be refused, got %v", err)
+	}
+	huge := []byte{COMPACT_FORMAT, 0xff, 0xff, 0xff, 0xff, 0x0f}
+	if _, err := decodeWireBlocks(huge); !errors.Is(err, ErrMalformedCompact) {
+		t.Errorf("Count over the data size should be refused, got %v", err)
+	}
+}
+
+func TestCompressedFrames(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	stream := &codecBuffer{codec: WireCodec{Binary: true, CompressMin: DEFAULT_COMPRESS_MIN_SIZE}}
+	msg := createMsg(CResHeaders, bytes.Repeat([]byte(`{"Depth":1,"Nonce":0}`), 100))
+	msg.ID = 7
+	small := &Message{Cmd: CReqDepth, Data: []byte{}, Source: Node{Address: "127.0.0.1:3341"}}
+	if err := writeMsg(stream, msg); err != nil {
+		t.Fatalf("writeMsg failed: %v", err)
+	}
+	if err := writeMsg(stream, small); err != nil {
+		t.Fatalf("writeMsg failed: %v", err)
+	}
+
+	header := deserializeWireHeader(stream.Bytes()[:WIRE_HEADER_LEN])
+	if header.Version != WIRE_FORMAT_BINARY || stream.Bytes()[WIRE_HEADER_LEN]&WIRE_FLAG_DEFLATE == 0 {
+		t.Errorf("Large message should be compressed in a binary frame")
+	}
+	if int(header.Length) >= len(msg.Data) {
+		t.Errorf("Compressed payload of %d bytes is larger than the data (%d bytes)", header.Length, len(msg.Data))
+	}
+
+	received, err := readMsg(stream)
+	if err == nil {
+		err = verifyMsg(received, "")
+	}
+	if err != nil || received.ID != msg.ID || !bytes.Equal(received.Data, msg.Data) {
+		t.Fatalf("Compressed message differs once read: %v", err)
+	}
+	if received, err = readMsg(stream); err != nil || received.Cmd != small.Cmd || received.Source.Address != small.Source.Address {
+		t.Errorf("Small message differs once read: %v", err)
+	}
+}
+
+// BenchmarkSyncWireBytes compares the bytes on the wire of the RES_BLOCKS frames of the same sync:
+// JSON frames and blocks (before), binary frames and compact blocks, then compressed.
+func BenchmarkSyncWireBytes(b *testing.B) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(b, &Config{})
+	blocks := newTestBlocks(8*MAX_BLOCKS_PER_MSG, 10)
+	codecs := []struct {
+		name  string
+		codec WireCodec
+	}{
+		{"json", WireCodec{}},
+		{"binary", WireCodec{Binary: true}},
+		{"compressed", WireCodec{Binary: true, CompressMin: DEFAULT_COMPRESS_MIN_SIZE}},
+	}
+	for _, c := range codecs {
+		b.Run(c.name, func(b *testing.B) {
+			var wireBytes int
+			for i := 0; i < b.N; i++ {
+				stream := &codecBuffer{codec: c.codec}
+				for start := 0; start < len(blocks); start += MAX_BLOCKS_PER_MSG {
+					end := minVal(start+MAX_BLOCKS_PER_MSG, len(blocks))
+					if err := writeMsg(stream, createMsgResBlocks(blocks[start:end], c.codec.Binary)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				wireBytes = stream.Len()
+			}
+			b.ReportMetric(float64(wireBytes), "wire-bytes")
+			b.ReportMetric(float64(wireBytes)/float64(len(blocks)), "bytes/block")
+		})
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -18,7 +20,9 @@ const (
 
 // Default variable of network configuration.
 var (
-	nwConfig *Config
+	nwConfigMu   sync.RWMutex
+	nwConfig     *Config
+	nwConfigPath string // Path of the config file `nwConfig` was loaded from.
 )
 
 // Required configurations for the network.
@@ -39,43 +43,102 @@ func (cfg *Config) ExportNetworkCfg(filePath string) {
 
 	// Export the Wallet's settings to the configuration file.
 	// Append the configuration file with Wallet's settings if it already existed.
-	cfgPath := readNwCfgPath(filePath)
+	cfgPath := resolveCfgPath(filePath)
 	appendFile(cfgPath, prettyMarshal)
 }
 
 // Get default network configurations.
 func getNetworkCfg() *Config {
+	nwConfigMu.RLock()
+	defer nwConfigMu.RUnlock()
 	return nwConfig
 }
 
+// setNetworkCfg replaces the network configurations (eg: reloaded from the config file).
+func setNetworkCfg(cfg *Config) {
+	nwConfigMu.Lock()
+	defer nwConfigMu.Unlock()
+	nwConfig = cfg
+}
+
+// getNwCfgPath returns the path of the config file the network configurations were loaded from.
+func getNwCfgPath() string {
+	nwConfigMu.RLock()
+	defer nwConfigMu.RUnlock()
+	return nwConfigPath
+}
+
 // initNwCfg initializes the network configurations from the config file
 // with the given source path.
 func initNwCfg(cfgPathCLI string) *Config {
-	var cfgPath string
-	if cfgPathCLI != "" {
-		cfgPath = cfgPathCLI
-	} else {
-		cfgPath = DEFAULT_CFG_PATH
-	}
-	nwConfig = importNwCfg(cfgPath)
-	walletConfig := nwConfig.WJson.ToWallet()
+	cfg := loadNwCfg(cfgPathCLI)
+	walletConfig := cfg.WJson.Unlock().ToWallet()
 	setWallet(walletConfig)
 
-	return nwConfig
+	return cfg
+}
+
+// loadNwCfg initializes the network configurations from the config file
+// without loading the wallet's private key (eg: on hosts that never sign anything).
+func loadNwCfg(cfgPathCLI string) *Config {
+	if cfgPathCLI == "" {
+		cfgPathCLI = DEFAULT_CFG_PATH
+	}
+	cfgPath := resolveCfgPath(cfgPathCLI)
+	cfg := importNwCfg(cfgPathCLI)
+	if err := checkNwCfg(cfg); err != nil {
+		Error.Println(err.Error())
+		os.Exit(1)
+	}
+	setChainParams(cfg.Network)
+	nwConfigMu.Lock()
+	nwConfig, nwConfigPath = cfg, cfgPath
+	nwConfigMu.Unlock()
+
+	if cfg.WJson.Address != "" && !validateAddr(cfg.WJson.Address) {
+		Warning.Printf("Wallet %s does not belong to network %s!", cfg.WJson.Address, getChainParams().Name)
+	}
+
+	return cfg
+}
+
+// checkNwCfg returns an error if the network configurations are invalid.
+func checkNwCfg(cfg *Config) error {
+	if _, err := newChainParams(cfg.Network); err != nil {
+		return err
+	}
+	if _, err := parseServices(cfg.Network.Services); err != nil {
+		return err
+	}
+	if duration := cfg.Network.BanDuration; duration != "" {
+		if _, err := time.ParseDuration(duration); err != nil {
+			return fmt.Errorf("invalid ban duration %q: %v", duration, err)
+		}
+	}
+	if err := checkRateLimits(cfg.Network.RateLimits); err != nil {
+		return err
+	}
+	return checkNodeIDs(cfg.Network)
 }
 
 // importNwCfg reads the configuration from file in given `path` (or flag value)
 // and returns the network configuration.
 func importNwCfg(path string) *Config {
-	if (strings.Compare(path, DEFAULT_CFG_PATH)) == 0 {
-		return getCfgData(path)
-	}
-	cfgPath := readNwCfgPath(path)
+	cfgPath := resolveCfgPath(path)
 	cfgData := getCfgData(cfgPath)
 
 	return cfgData
 }
 
+// resolveCfgPath returns the path of the configuration file corresponding
+// to the given `path` (or flag value, eg: node1/2/3).
+func resolveCfgPath(path string) string {
+	if (strings.Compare(path, DEFAULT_CFG_PATH)) == 0 {
+		return path
+	}
+	return readNwCfgPath(path)
+}
+
 // walkCfgDir walks through the directory tree structure and returns all the sub-directories.
 func walkCfgDir(cfgDir string) ([]string, error) {
 	if strings.Compare(cfgDir, "") == 0 {
@@ -130,14 +193,25 @@ func readFile(path string) ([]byte, error) {
 
 // getCfgData returns the configuration data corresponding to the given path.
 func getCfgData(path string) *Config {
-	cfgFile, _ := readFile(path)
-	cfg := Config{}
-	err := json.Unmarshal(cfgFile, &cfg)
+	cfg, err := parseCfgData(path)
 	if err != nil {
 		Error.Println(err.Error())
 		os.Exit(1)
 	}
-	return &cfg
+	return cfg
+}
+
+// parseCfgData reads the configuration file in the given path.
+func parseCfgData(path string) (*Config, error) {
+	cfgFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := Config{}
+	if err := json.Unmarshal(cfgFile, &cfg); err != nil {
+		return nil, fmt.Errorf("malformed config %s: %v", path, err)
+	}
+	return &cfg, nil
 }
 
 func checkFileExists(path string) bool {
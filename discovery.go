@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Peer discovery: starting from the seed nodes of the config file (one is enough), the node
+// asks its peers for the addresses they know (REQ_ADDR), keeps them in its address book and
+// connects to new ones until it has `target_outbound` outbound peers.
+// The addresses of the nodes connecting to us are only gossiped once we reached them ourselves.
+
+const (
+	DEFAULT_TARGET_OUTBOUND   = 8
+	OUTBOUND_REFRESH_INTERVAL = 30 * time.Second
+)
+
+// OutboundPeers holds the nodes the local node is connected to, by address.
+type OutboundPeers struct {
+	mu    sync.Mutex
+	peers map[string]*VersionPayload
+}
+
+// Outbound peers of the running node.
+var outboundPeers = &OutboundPeers{peers: make(map[string]*VersionPayload)}
+
+// Wakes the discovery loop up before its next tick.
+var refreshCh = make(chan struct{}, 1)
+
+// Utility functions start from here.
+
+// isDialableAddr returns true if the address has both a host and a port.
+func isDialableAddr(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	return err == nil && host != "" && port != "" && port != "0"
+}
+
+// targetOutbound returns the number of outbound peers the node tries to keep.
+func targetOutbound() int {
+	if cfg := getNetworkCfg(); cfg != nil && cfg.Network.TargetOutbound > 0 {
+		return cfg.Network.TargetOutbound
+	}
+	return DEFAULT_TARGET_OUTBOUND
+}
+
+// getPeerNodes returns the nodes to synchronize with and to announce to:
+// the outbound peers, or the neighbor nodes of the config before any of them is reached.
+func getPeerNodes() []Node {
+	if nodes := outboundPeers.Nodes(); len(nodes) > 0 {
+		return nodes
+	}
+	return getNetwork().NeighborNodes
+}
+
+// startDiscovery loads the address book of the node's database, connects to the first peers
+// and keeps refreshing them in the background until `ctx` is canceled. The returned channel
+// is closed once the background refreshing stopped.
+func startDiscovery(ctx context.Context, bc *Blockchain) <-chan struct{} {
+	ab, err := newAddrBook(bc.DB)
+	if err != nil {
+		Error.Printf("Cannot load the address book: %v", err)
+		ab, _ = newAddrBook(nil)
+	}
+	setAddrBook(ab)
+
+	// The seed nodes are candidates like the others, never seen yet.
+	var seeds []KnownAddr
+	for _, node := range getNetwork().NeighborNodes {
+		seeds = append(seeds, KnownAddr{Address: node.Address})
+	}
+	ab.Add(seeds)
+	Info.Printf("Address book: %d known address(es)", ab.Len())
+
+	refreshOutbound()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(OUTBOUND_REFRESH_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-refreshCh:
+			}
+			refreshOutbound()
+		}
+	}()
+	return done
+}
+
+// requestRefresh wakes the discovery loop up (eg: a new address was heard of).
+func requestRefresh() {
+	select {
+	case refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+// refreshOutbound checks the outbound peers are still up, gathering their addresses,
+// then connects to new peers up to the target.
+func refreshOutbound() {
+	ab := getAddrBook()
+	if ab == nil {
+		return
+	}
+
+	for _, node := range outboundPeers.Nodes() {
+		if _, addrs, err := reqAddrNeighbor(node); err != nil {
+			Info.Printf("Peer %s is gone: %v", node.Address, err)
+			outboundPeers.Remove(node.Address)
+			ab.Attempt(node.Address)
+		} else {
+			ab.Good(node.Address)
+			ab.Add(addrs)
+		}
+	}
+
+	tried := map[string]bool{getLocalNode().Address: true}
+	for _, node := range outboundPeers.Nodes() {
+		tried[node.Address] = true
+	}
+	for outboundPeers.Len() < targetOutbound() {
+		candidates := ab.Candidates(tried)
+		if len(candidates) == 0 {
+			return
+		}
+		address := candidates[0]
+		tried[address] = true
+		connectOutbound(ab, address)
+	}
+}
+
+// connectOutbound connects to the node of the given address as an outbound peer,
+// gathering the addresses it knows.
+func connectOutbound(ab *AddrBook, address string) error {
+	version, addrs, err := reqAddrNeighbor(Node{Address: address})
+	if errors.Is(err, ErrPeerBanned) {
+		return err
+	}
+	if err != nil {
+		Info.Printf("Cannot connect to %s: %v", address, err)
+		ab.Attempt(address)
+		return err
+	}
+	outboundPeers.Set(address, version)
+	ab.Good(address)
+	ab.Add(addrs)
+	Info.Printf("Connected to peer %s (%s, height %d), %d/%d outbound peer(s)",
+		address, version.UserAgent, version.BestHeight, outboundPeers.Len(), targetOutbound())
+	return nil
+}
+
+// heardOfPeer adds the listening address announced by an inbound peer to the address book,
+// as a candidate to connect to (and to gossip once reached).
+func heardOfPeer(address string) {
+	ab := getAddrBook()
+	if ab == nil || address == "" || outboundPeers.Has(address) {
+		return
+	}
+	// A node which failed before (eg: dialed before it listened) is up again since it reached us.
+	before := ab.Len()
+	ab.Add([]KnownAddr{{Address: address}})
+	retry := ab.Retry(address)
+	if (ab.Len() > before || retry) && outboundPeers.Len() < targetOutbound() {
+		requestRefresh()
+	}
+}
+
+// reqAddrNeighbor connects to the node and asks for the addresses it knows.
+func reqAddrNeighbor(node Node) (*VersionPayload, []KnownAddr, error) {
+	client, err := getPeerClient(node)
+	if err != nil {
+		return nil, nil, err
+	}
+	msgRes, err := client.Request(createMsgReqAddr())
+	if err == nil {
+		err = checkMsgCmd(msgRes, CResAddr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var addrs []KnownAddr
+	if err = json.Unmarshal(msgRes.Data, &addrs); err != nil {
+		getPeerManager().Misbehave(peerKey(node.Address), MISBEHAVIOUR_MALFORMED_MSG, fmt.Sprintf("malformed addresses: %v", err))
+		return nil, nil, err
+	}
+	if len(addrs) > MAX_ADDR_PER_MSG {
+		addrs = addrs[:MAX_ADDR_PER_MSG]
+	}
+	return client.Version(), addrs, nil
+}
+
+// OutboundPeers's methods:
+
+// Nodes returns the outbound peers, sorted by address.
+func (op *OutboundPeers) Nodes() []Node {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	var nodes []Node
+	for address := range op.peers {
+		nodes = append(nodes, Node{Address: address})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Address < nodes[j].Address })
+	return nodes
+}
+
+func (op *OutboundPeers) Set(address string, version *VersionPayload) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.peers[address] = version
+}
+
+func (op *OutboundPeers) Remove(address string) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	delete(op.peers, address)
+}
+
+// RemoveKey drops the outbound peers of the given peer identity (eg: banned).
+func (op *OutboundPeers) RemoveKey(key string) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	for address := range op.peers {
+		if peerKey(address) == key {
+			delete(op.peers, address)
+		}
+	}
+}
+
+func (op *OutboundPeers) Has(address string) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	_, ok := op.peers[address]
+	return ok
+}
+
+func (op *OutboundPeers) Len() int {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return len(op.peers)
+}
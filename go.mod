@@ -15,12 +15,6 @@ require (
 	golang.org/x/exp v0.0.0-20220428152302-39d4317da171
 )
 
-require github.com/vrecan/death v3.0.1+incompatible
+require golang.org/x/crypto v0.0.0-20220507011949-2cf3adece122
 
-require (
-	github.com/cihub/seelog v0.0.0-20170130134532-f561c5e57575 // indirect
-	github.com/gopherjs/gopherjs v0.0.0-20200217142428-fce0ec30dd00 // indirect
-	github.com/smartystreets/assertions v1.1.1 // indirect
-	github.com/smartystreets/goconvey v1.6.4 // indirect
-	golang.org/x/crypto v0.0.0-20220507011949-2cf3adece122
-)
+require golang.org/x/term v0.0.0-20220526004731-065cf7ba2467
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Version handshake opening every connection between two nodes:
+//
+//	outbound                      inbound
+//	VERSION  ------------------>  (checks the outbound node)
+//	         <------------------  VERSION
+//	         <------------------  VERACK
+//	VERACK   ------------------>  (then the requests follow)
+//
+// A node refusing the other (incompatible protocol, chain or genesis block, or a connection
+// to itself) sends a REJECT message with the reason and closes the connection.
+
+const (
+	MIN_PROTOCOL_VERSION = uint16(1) // Oldest protocol version still spoken with.
+	NODE_USER_AGENT      = "/ImChain:1.0/"
+	HANDSHAKE_TIMEOUT    = 10 * time.Second
+
+	SERVICE_MINER    = uint64(1 << 0) // Mines the blocks of the transactions it receives.
+	SERVICE_STORAGE  = uint64(1 << 1) // Stores the full chain and serves its blocks.
+	SERVICE_OBSERVER = uint64(1 << 2) // Follows the chain without mining.
+)
+
+var (
+	ErrIncompatiblePeer = errors.New("incompatible peer")
+	ErrSelfConnection   = errors.New("connected to itself")
+	ErrPeerRejected     = errors.New("rejected by peer")
+)
+
+// Names of the services in the `network.services` config field.
+var serviceNames = map[string]uint64{
+	"miner":    SERVICE_MINER,
+	"storage":  SERVICE_STORAGE,
+	"observer": SERVICE_OBSERVER,
+}
+
+// Nonce of the local node, telling its own VERSION messages apart.
+var localNonce = newNonce()
+
+// VersionPayload describes a node to its peer.
+type VersionPayload struct {
+	Protocol    uint16 `json:"protocol"`     // Wire protocol version spoken by the node.
+	ChainID     uint32 `json:"chain_id"`     // Chain ID of its network.
+	GenesisHash string `json:"genesis_hash"` // Hex hash of its network's genesis block.
+	BestHeight  int    `json:"best_height"`  // Depth of its chain.
+	ChainWork   string `json:"chain_work"`   // Hex work of its chain (see `chainWork`).
+	Services    uint64 `json:"services"`     // SERVICE_* flags.
+	Compression bool   `json:"compression"`  // Whether the node accepts compressed messages.
+	UserAgent   string `json:"user_agent"`   // Software of the node.
+	Nonce       uint64 `json:"nonce"`        // Random value detecting the connections to itself.
+	Timestamp   int64  `json:"timestamp"`    // Unix time of the node.
+}
+
+// Utility functions start from here.
+
+func newNonce() uint64 {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// parseServices returns the flags of the given service names (miner and storage by default).
+func parseServices(names []string) (uint64, error) {
+	if len(names) == 0 {
+		return SERVICE_MINER | SERVICE_STORAGE, nil
+	}
+	var services uint64
+	for _, name := range names {
+		flag, ok := serviceNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown service %q", name)
+		}
+		services |= flag
+	}
+	return services, nil
+}
+
+// localVersion returns the VERSION payload describing the local node.
+func localVersion() *VersionPayload {
+	version := &VersionPayload{
+		Protocol:    WIRE_PROTOCOL_VERSION,
+		ChainID:     getChainParams().ChainID,
+		GenesisHash: getChainParams().GenesisHash,
+		UserAgent:   NODE_USER_AGENT,
+		Compression: compressMinSize() > 0,
+		Nonce:       localNonce,
+		Timestamp:   time.Now().Unix(),
+	}
+	if cfg := getNetworkCfg(); cfg != nil {
+		version.Services, _ = parseServices(cfg.Network.Services)
+	}
+	if bc := getNodeChain(); bc != nil && !bc.IsEmpty() {
+		version.BestHeight = bc.GetDepth()
+		version.ChainWork = chainWork(version.BestHeight).Text(16)
+	}
+	return version
+}
+
+// checkPeerVersion returns an error if the `local` node cannot talk with the described peer.
+func checkPeerVersion(local, peer *VersionPayload) error {
+	switch {
+	case peer.Nonce == local.Nonce:
+		return ErrSelfConnection
+	case peer.Protocol < MIN_PROTOCOL_VERSION:
+		return fmt.Errorf("%w: protocol version %d, expected at least %d", ErrIncompatiblePeer, peer.Protocol, MIN_PROTOCOL_VERSION)
+	case peer.ChainID != local.ChainID:
+		return fmt.Errorf("%w: chain ID %d, expected %d", ErrIncompatiblePeer, peer.ChainID, local.ChainID)
+	case peer.GenesisHash != local.GenesisHash:
+		return fmt.Errorf("%w: genesis block %s, expected %s", ErrIncompatiblePeer, peer.GenesisHash, local.GenesisHash)
+	}
+	return nil
+}
+
+// deserializeVersion decodes a VERSION payload.
+func deserializeVersion(data []byte) (*VersionPayload, error) {
+	version := new(VersionPayload)
+	if err := json.Unmarshal(data, version); err != nil {
+		return nil, fmt.Errorf("%w: malformed version: %v", ErrIncompatiblePeer, err)
+	}
+	return version, nil
+}
+
+// expectMsg reads the next message, which must have the given command.
+// A REJECT message is turned into an error carrying the peer's reason.
+func expectMsg(conn net.Conn, cmd string) (*Message, error) {
+	msg, err := readMsg(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMsgCmd(msg, cmd); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// checkMsgCmd returns an error if the message does not have the given command,
+// carrying the peer's reason for a REJECT message.
+func checkMsgCmd(msg *Message, cmd string) error {
+	if msg.Cmd == CReject {
+		return fmt.Errorf("%w: %s", ErrPeerRejected, msg.Data)
+	}
+	if msg.Cmd != cmd {
+		return fmt.Errorf("%w: expected %s, got %s", ErrIncompatiblePeer, cmd, msg.Cmd)
+	}
+	return nil
+}
+
+// rejectPeer tells the peer why it is refused.
+func rejectPeer(conn net.Conn, reason error) {
+	writeMsg(conn, tagResponse(conn, createMsgReject(reason.Error())))
+}
+
+// handshakeOutbound runs the handshake of a connection opened by the `local` node
+// and returns the version of the peer.
+func handshakeOutbound(conn net.Conn, local *VersionPayload) (*VersionPayload, error) {
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+
+	if err := writeMsg(conn, createMsgVersion(local)); err != nil {
+		return nil, err
+	}
+	msg, err := expectMsg(conn, CVersion)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := deserializeVersion(msg.Data)
+	if err == nil {
+		err = checkPeerVersion(local, peer)
+	}
+	if err != nil {
+		rejectPeer(conn, err)
+		return nil, err
+	}
+	if _, err = expectMsg(conn, CVerAck); err != nil {
+		return nil, err
+	}
+	return peer, writeMsg(conn, createMsgVerAck())
+}
+
+// handshakeInbound runs the handshake of a connection accepted by the `local` node,
+// `msg` being the first message received, and returns the version of the peer.
+func handshakeInbound(conn net.Conn, local *VersionPayload, msg *Message) (*VersionPayload, error) {
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+
+	if msg.Cmd != CVersion {
+		err := fmt.Errorf("%w: %s before the version handshake", ErrIncompatiblePeer, msg.Cmd)
+		rejectPeer(conn, err)
+		return nil, err
+	}
+	peer, err := deserializeVersion(msg.Data)
+	if err == nil {
+		err = checkPeerVersion(local, peer)
+	}
+	if err != nil {
+		rejectPeer(conn, err)
+		return nil, err
+	}
+
+	if err = writeMsg(conn, createMsgVersion(local)); err != nil {
+		return nil, err
+	}
+	if err = writeMsg(conn, createMsgVerAck()); err != nil {
+		return nil, err
+	}
+	if _, err = expectMsg(conn, CVerAck); err != nil {
+		return nil, err
+	}
+	return peer, nil
+}
+
+// dialPeer opens a secure connection to the given node, unless it is banned, and runs the handshake.
+// The connection is tracked by the peer manager until closed.
+func dialPeer(node Node) (net.Conn, *VersionPayload, error) {
+	pm := getPeerManager()
+	peerConn, err := pm.Open(peerKey(node.Address), node.Address, node.Address, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := net.DialTimeout("tcp", node.Address, HANDSHAKE_TIMEOUT)
+	if err != nil {
+		pm.Close(peerConn)
+		return nil, nil, err
+	}
+	secured, identity, err := secureOutbound(conn, node)
+	if err != nil {
+		conn.Close()
+		pm.Close(peerConn)
+		return nil, nil, fmt.Errorf("secure connection with %s failed: %w", node.Address, err)
+	}
+	tracked := &trackedConn{Conn: secured, pm: pm, peer: peerConn}
+
+	peer, err := handshakeOutbound(tracked, localVersion())
+	if err != nil {
+		tracked.Close()
+		return nil, nil, fmt.Errorf("handshake with %s failed: %w", node.Address, err)
+	}
+	pm.Activate(peerConn, peer, identity)
+	return negotiateWire(tracked, peer, identity), peer, nil
+}
+
+// VersionPayload's methods:
+
+// ServiceNames returns the names of the peer's services.
+func (version *VersionPayload) ServiceNames() []string {
+	var names []string
+	for _, name := range []string{"miner", "storage", "observer"} {
+		if version.Services&serviceNames[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
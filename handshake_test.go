@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// testPeerVersion returns the version of another node of the same network.
+func testPeerVersion() *VersionPayload {
+	version := localVersion()
+	version.Nonce = newNonce()
+	return version
+}
+
+// acceptTestPeer runs the TLS and version handshakes of a connection accepted by a test peer.
+func acceptTestPeer(conn net.Conn, peerVersion *VersionPayload) (net.Conn, error) {
+	secured, identity, err := secureInbound(conn)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := readMsg(secured)
+	if err != nil {
+		return secured, err
+	}
+	peer, err := handshakeInbound(secured, peerVersion, msg)
+	if err != nil {
+		return secured, err
+	}
+	return negotiateWire(secured, peer, identity), nil
+}
+
+func TestCheckPeerVersion(t *testing.T) {
+	local := &VersionPayload{Protocol: WIRE_PROTOCOL_VERSION, ChainID: 1, GenesisHash: "aa", Nonce: 1}
+	tests := []struct {
+		name   string
+		peer   VersionPayload
+		expect error
+	}{
+		{"compatible", VersionPayload{Protocol: WIRE_PROTOCOL_VERSION, ChainID: 1, GenesisHash: "aa", Nonce: 2}, nil},
+		{"no genesis", VersionPayload{Protocol: WIRE_PROTOCOL_VERSION, ChainID: 1, Nonce: 2}, ErrIncompatiblePeer},
+		{"itself", VersionPayload{Protocol: WIRE_PROTOCOL_VERSION, ChainID: 1, GenesisHash: "aa", Nonce: 1}, ErrSelfConnection},
+		{"old protocol", VersionPayload{Protocol: 0, ChainID: 1, Nonce: 2}, ErrIncompatiblePeer},
+		{"other chain", VersionPayload{Protocol: WIRE_PROTOCOL_VERSION, ChainID: 2, Nonce: 2}, ErrIncompatiblePeer},
+		{"other genesis", VersionPayload{Protocol: WIRE_PROTOCOL_VERSION, ChainID: 1, GenesisHash: "bb", Nonce: 2}, ErrIncompatiblePeer},
+	}
+	for _, test := range tests {
+		if err := checkPeerVersion(local, &test.peer); !errors.Is(err, test.expect) || (test.expect == nil && err != nil) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expect, err)
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{Network: Network{Services: []string{"observer"}}})
+
+	run := func(outbound, inbound *VersionPayload) (*VersionPayload, error, error) {
+		client, server := net.Pipe()
+		defer client.Close()
+		inboundErr := make(chan error, 1)
+		go func() {
+			defer server.Close()
+			msg, err := readMsg(server)
+			if err == nil {
+				_, err = handshakeInbound(server, inbound, msg)
+			}
+			inboundErr <- err
+		}()
+		peer, err := handshakeOutbound(client, outbound)
+		client.Close()
+		return peer, err, <-inboundErr
+	}
+
+	peer, err, inErr := run(testPeerVersion(), testPeerVersion())
+	if err != nil || inErr != nil {
+		t.Fatalf("Handshake failed: %v / %v", err, inErr)
+	}
+	if peer.UserAgent != NODE_USER_AGENT || strings.Join(peer.ServiceNames(), ",") != "observer" {
+		t.Errorf("Unexpected peer version %+v", peer)
+	}
+
+	// The inbound node refuses another chain, the outbound node gets its reason.
+	foreign := testPeerVersion()
+	foreign.ChainID++
+	_, err, inErr = run(foreign, testPeerVersion())
+	if !errors.Is(inErr, ErrIncompatiblePeer) || !errors.Is(err, ErrPeerRejected) || !strings.Contains(err.Error(), "chain ID") {
+		t.Errorf("Expected a rejection for the chain ID, got %v / %v", err, inErr)
+	}
+
+	// A node connecting to itself gives up.
+	self := testPeerVersion()
+	if _, err, _ = run(self, self); !errors.Is(err, ErrPeerRejected) {
+		t.Errorf("Expected a self connection to be refused, got %v", err)
+	}
+}
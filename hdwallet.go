@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Hierarchical deterministic (HD) keys: every key of the wallet is derived from one seed,
+// so that backing up the mnemonic phrase is enough to restore all of them.
+// The derivation follows SLIP-0010 (BIP-32 generalized to the NIST P-256 curve).
+//
+// Derivation path: m / 44' / HD_COIN_TYPE' / account' / chain / index
+//	. chain = 0 : external addresses (given away to receive funds).
+//	. chain = 1 : internal addresses (receiving the change of our own transactions).
+
+const (
+	HD_HARDENED       = uint32(0x80000000)
+	HD_PURPOSE        = 44
+	HD_COIN_TYPE      = 1 // SLIP-0044 "testnet" coin type, shared by all the networks of this chain.
+	HD_EXTERNAL_CHAIN = 0
+	HD_CHANGE_CHAIN   = 1
+	HD_GAP_LIMIT      = 20
+
+	// HMAC key of the master node, as specified by SLIP-0010 for the P-256 curve.
+	HD_SEED_KEY = "Nist256p1 seed"
+)
+
+var ErrInvalidPath = errors.New("invalid derivation path")
+
+// Names of the derivation chains, used to label the derived keys.
+var hdChainNames = [2]string{"receive", "change"}
+
+// HDKey is one node of the derivation tree.
+type HDKey struct {
+	PrivateKey []byte // 32 bytes private key.
+	ChainCode  []byte // 32 bytes chain code.
+	Depth      int    // Depth of the node in the tree (master = 0).
+}
+
+// Utility functions start from here.
+
+// newMasterKey derives the root of the derivation tree from the given seed.
+func newMasterKey(seed []byte) *HDKey {
+	curveN := elliptic.P256().Params().N
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, []byte(HD_SEED_KEY))
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		// Retry with the whole output as data while the key is not a valid scalar.
+		key := new(big.Int).SetBytes(sum[:32])
+		if key.Sign() != 0 && key.Cmp(curveN) < 0 {
+			return &HDKey{PrivateKey: sum[:32], ChainCode: sum[32:]}
+		}
+		data = sum
+	}
+}
+
+// parseHDPath parses a derivation path such as `m/44'/1'/0'/0/3` into child indexes.
+func parseHDPath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, ErrInvalidPath
+	}
+
+	var indexes []uint32
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		part = strings.TrimRight(part, "'h")
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPath, path)
+		}
+		if hardened {
+			index += uint64(HD_HARDENED)
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return indexes, nil
+}
+
+// appendUint32 appends the big-endian encoding of the index to the given bytes.
+func appendUint32(data []byte, index uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], index)
+	return append(data, buf[:]...)
+}
+
+// hdAccountPath returns the derivation path of the given key of an account.
+func hdAccountPath(account, chain, index uint32) string {
+	return fmt.Sprintf("m/%d'/%d'/%d'/%d/%d", HD_PURPOSE, HD_COIN_TYPE, account, chain, index)
+}
+
+// deriveHDWallet returns the wallet of the given derivation path under the seed.
+func deriveHDWallet(seed []byte, path string) (*Wallet, error) {
+	indexes, err := parseHDPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := newMasterKey(seed)
+	for _, index := range indexes {
+		key = key.Child(index)
+	}
+	return key.Wallet(), nil
+}
+
+// HDKey's methods:
+
+// Child derives the child key at the given index (hardened if index >= HD_HARDENED).
+func (k *HDKey) Child(index uint32) *HDKey {
+	curve := elliptic.P256()
+	curveN := curve.Params().N
+	parentKey := new(big.Int).SetBytes(k.PrivateKey)
+
+	var data []byte
+	if index >= HD_HARDENED {
+		data = append([]byte{0x00}, k.PrivateKey...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.PrivateKey)
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = appendUint32(data, index)
+
+	for {
+		mac := hmac.New(sha512.New, k.ChainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		// childKey := (IL + parentKey) mod N, retried when invalid as specified by SLIP-0010.
+		tweak := new(big.Int).SetBytes(sum[:32])
+		childKey := new(big.Int).Add(tweak, parentKey)
+		childKey.Mod(childKey, curveN)
+		if tweak.Cmp(curveN) < 0 && childKey.Sign() != 0 {
+			return &HDKey{
+				PrivateKey: childKey.FillBytes(make([]byte, 32)),
+				ChainCode:  sum[32:],
+				Depth:      k.Depth + 1,
+			}
+		}
+		data = appendUint32(append([]byte{0x01}, sum[32:]...), index)
+	}
+}
+
+// Wallet returns the wallet owning the key.
+func (k *HDKey) Wallet() *Wallet {
+	curve := elliptic.P256()
+	w := &Wallet{KeyType: KEY_TYPE_P256}
+	w.PrivateKey = ecdsa.PrivateKey{D: new(big.Int).SetBytes(k.PrivateKey)}
+	w.PrivateKey.PublicKey.Curve = curve
+	w.PrivateKey.PublicKey.X, w.PrivateKey.PublicKey.Y = curve.ScalarBaseMult(k.PrivateKey)
+	w.PublicKey = encodePubKey(&w.PrivateKey.PublicKey)
+	w.Address = genAddr(w.PublicKey)
+	return w
+}
+
+// HDChain is the HD section of the wallet store.
+type HDChain struct {
+	Seed     string    `json:"seed,omitempty"`     // Hex-encoded seed.
+	Keystore string    `json:"keystore,omitempty"` // Path to the encrypted seed (replaces `Seed`).
+	Account  uint32    `json:"account"`            // Account used to derive new keys.
+	Next     [2]uint32 `json:"next"`               // Next unused index of the external and change chains.
+
+	seed []byte // Decrypted seed, cached once unlocked.
+}
+
+// HDChain's methods:
+
+// Unlock returns the seed, decrypting its keystore first if needed.
+func (hd *HDChain) Unlock() []byte {
+	if hd.seed != nil {
+		return hd.seed
+	}
+
+	seedHex := hd.Seed
+	if hd.Keystore != "" {
+		seedHex = unlockKeystore(&WalletJson{Keystore: hd.Keystore}).PrivateKey
+	}
+	seed, err := hex.DecodeString(seedHex)
+	if err != nil || len(seed) == 0 {
+		Error.Printf("HD seed is corrupted: %v", err)
+		return nil
+	}
+	hd.seed = seed
+	return seed
+}
+
+// Derive returns the path and the wallet of the given key of the current account.
+func (hd *HDChain) Derive(chain, index uint32) (string, *Wallet) {
+	path := hdAccountPath(hd.Account, chain, index)
+	w, err := deriveHDWallet(hd.Unlock(), path)
+	if err != nil {
+		Error.Panic(err)
+	}
+	return path, w
+}
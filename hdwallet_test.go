@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestMnemonicVectors(t *testing.T) {
+	vectors := []struct {
+		entropy  string
+		mnemonic string
+	}{
+		{"00000000000000000000000000000000", strings.Repeat("abandon ", 11) + "about"},
+		{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f", "legal winner thank year wave sausage worth useful legal winner thank yellow"},
+		{"80808080808080808080808080808080", "letter advice cage absurd amount doctor acoustic avoid letter advice cage above"},
+		{"ffffffffffffffffffffffffffffffff", strings.Repeat("zoo ", 11) + "wrong"},
+		{"9e885d952ad362caeb4efe34a8e91bd2", "ozone drill grab fiber curtain grace pudding thank cruise elder eight picnic"},
+	}
+	for _, v := range vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		mnemonic, err := entropyToMnemonic(entropy)
+		if err != nil || mnemonic != v.mnemonic {
+			t.Errorf("entropyToMnemonic(%s) = %q, %v", v.entropy, mnemonic, err)
+		}
+		decoded, err := mnemonicToEntropy(v.mnemonic)
+		if err != nil || !bytes.Equal(decoded, entropy) {
+			t.Errorf("mnemonicToEntropy(%q) = %x, %v", v.mnemonic, decoded, err)
+		}
+	}
+
+	seed, err := mnemonicToSeed(vectors[0].mnemonic, "TREZOR")
+	expected := "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+	if err != nil || hex.EncodeToString(seed) != expected {
+		t.Errorf("mnemonicToSeed = %x, %v", seed, err)
+	}
+
+	// A wrong last word breaks the checksum.
+	if _, err := mnemonicToSeed(strings.Repeat("abandon ", 12), ""); !errors.Is(err, ErrInvalidMnemonic) {
+		t.Errorf("Invalid checksum should be rejected, got %v", err)
+	}
+}
+
+func TestHDDerivation(t *testing.T) {
+	// SLIP-0010 test vector 1 for the NIST P-256 curve.
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master := newMasterKey(seed)
+	if hex.EncodeToString(master.ChainCode) != "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea" ||
+		hex.EncodeToString(master.PrivateKey) != "612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2" {
+		t.Errorf("Unexpected master key %x / %x", master.ChainCode, master.PrivateKey)
+	}
+
+	path := hdAccountPath(0, HD_CHANGE_CHAIN, 3)
+	indexes, err := parseHDPath(path)
+	if err != nil || len(indexes) != 5 || indexes[0] != HD_HARDENED+HD_PURPOSE || indexes[4] != 3 {
+		t.Errorf("parseHDPath(%s) = %v, %v", path, indexes, err)
+	}
+	if _, err := parseHDPath("44'/0"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Path without master node should be rejected, got %v", err)
+	}
+
+	first, _ := deriveHDWallet(seed, path)
+	second, _ := deriveHDWallet(seed, path)
+	other, _ := deriveHDWallet(seed, hdAccountPath(0, HD_CHANGE_CHAIN, 4))
+	if first.Address != second.Address || first.Address == other.Address {
+		t.Errorf("Derivation must be deterministic and distinct per index!")
+	}
+}
+
+func TestWalletStoreHD(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	seed, _ := mnemonicToSeed(strings.Repeat("abandon ", 11)+"about", "")
+	ws := &WalletStore{Version: WALLET_STORE_VERSION}
+	if err := ws.InitHD(seed, 0, "", nil); err != nil {
+		t.Fatalf("InitHD failed: %v", err)
+	}
+
+	receive, _, err := ws.AddHD(HD_EXTERNAL_CHAIN, "")
+	if err != nil {
+		t.Fatalf("AddHD failed: %v", err)
+	}
+	change, _, _ := ws.AddHD(HD_CHANGE_CHAIN, "")
+	if receive.Label != "receive-0" || change.Label != "change-0" || ws.HD.Next != [2]uint32{1, 1} {
+		t.Errorf("Unexpected HD entries %s, %s, next %v", receive.Label, change.Label, ws.HD.Next)
+	}
+	if receive.Wallet.PrivateKey != "" {
+		t.Errorf("Private key of HD entries must not be stored!")
+	}
+
+	// The same mnemonic restores the same keys.
+	restored := &WalletStore{Version: WALLET_STORE_VERSION}
+	restored.InitHD(seed, 0, "", nil)
+	entry, w, _ := restored.AddHD(HD_EXTERNAL_CHAIN, "")
+	if entry.Wallet.Address != receive.Wallet.Address || ws.Wallet(receive).Address != w.Address {
+		t.Errorf("Restored wallet differs from the original one!")
+	}
+}
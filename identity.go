@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Node identities: every node owns an ed25519 key pair, apart from the coin wallets, and
+// the connections between nodes run over TLS 1.3 with a certificate signed by that key on
+// both ends. The ID of a node is its hex public key, checked against `network.allowed_peers`
+// and against the `id` pinned for a neighbor, whatever address the peer claims.
+
+const NODE_KEY_FILE = "node_key.json"
+
+var (
+	ErrPeerNotAllowed   = errors.New("peer identity not allowed")
+	ErrUnexpectedPeerID = errors.New("unexpected peer identity")
+)
+
+// NodeIdentity is the key pair identifying the node to its peers.
+type NodeIdentity struct {
+	key  ed25519.PrivateKey
+	cert tls.Certificate // Self-signed certificate of the key.
+}
+
+// NodeKeyJson is the stored form of a node identity.
+type NodeKeyJson struct {
+	PrivateKey string `json:"private_key"` // Hex seed of the ed25519 key.
+	ID         string `json:"id"`
+}
+
+// Identity of the running node, ephemeral until loaded from the node's key file.
+var (
+	nodeIdentityMu sync.Mutex
+	nodeIdentity   *NodeIdentity
+)
+
+// Utility functions start from here.
+
+func setNodeIdentity(identity *NodeIdentity) {
+	nodeIdentityMu.Lock()
+	defer nodeIdentityMu.Unlock()
+	nodeIdentity = identity
+}
+
+// getNodeIdentity returns the identity of the local node. Until a running node loads it (eg: in the
+// CLI commands querying a node), the node's key file is read if it exists, else an ephemeral key is used.
+func getNodeIdentity() *NodeIdentity {
+	nodeIdentityMu.Lock()
+	defer nodeIdentityMu.Unlock()
+	if nodeIdentity == nil {
+		if cfgPath := getNwCfgPath(); cfgPath != "" {
+			nodeIdentity, _ = readNodeIdentity(cfgPath)
+		}
+	}
+	if nodeIdentity == nil {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		nodeIdentity = newNodeIdentity(key)
+	}
+	return nodeIdentity
+}
+
+func newNodeIdentity(key ed25519.PrivateKey) *NodeIdentity {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(100, 0, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	return &NodeIdentity{key: key, cert: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+// nodeKeyPath returns the path of the node's key file next to the given config file.
+func nodeKeyPath(cfgFilePath string) string {
+	return filepath.ToSlash(filepath.Join(filepath.Dir(cfgFilePath), NODE_KEY_FILE))
+}
+
+// loadNodeIdentity reads the node's key file next to the config file,
+// creating it with a new key on the first run.
+func loadNodeIdentity(cfgFilePath string) (*NodeIdentity, error) {
+	identity, err := readNodeIdentity(cfgFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		keyPath := nodeKeyPath(cfgFilePath)
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		identity := newNodeIdentity(key)
+		keyJson, _ := json.MarshalIndent(NodeKeyJson{PrivateKey: hex.EncodeToString(key.Seed()), ID: identity.ID()}, "", "  ")
+		if err = ioutil.WriteFile(keyPath, keyJson, 0600); err != nil {
+			return nil, err
+		}
+		Info.Printf("New node identity %s stored in %s", identity.ID(), keyPath)
+		return identity, nil
+	}
+	return identity, err
+}
+
+// readNodeIdentity reads the node's key file next to the config file.
+func readNodeIdentity(cfgFilePath string) (*NodeIdentity, error) {
+	keyPath := nodeKeyPath(cfgFilePath)
+	contents, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keyJson := new(NodeKeyJson)
+	if err = json.Unmarshal(contents, keyJson); err != nil {
+		return nil, fmt.Errorf("malformed node key %s: %v", keyPath, err)
+	}
+	seed, err := hex.DecodeString(keyJson.PrivateKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("malformed node key %s", keyPath)
+	}
+	return newNodeIdentity(ed25519.NewKeyFromSeed(seed)), nil
+}
+
+// checkNodeIDs returns an error if an identity of the network configuration is not a node's public key.
+func checkNodeIDs(network Network) error {
+	ids := append([]string{}, network.AllowedPeers...)
+	for _, node := range network.NeighborNodes {
+		if node.ID != "" {
+			ids = append(ids, node.ID)
+		}
+	}
+	for _, id := range ids {
+		if key, err := hex.DecodeString(id); err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid node identity %q", id)
+		}
+	}
+	return nil
+}
+
+// checkPeerID returns an error if the peer of the given ID may not connect with the local node,
+// `expected` being the ID pinned for the peer (may be empty).
+func checkPeerID(id, expected string) error {
+	if expected != "" && id != expected {
+		return fmt.Errorf("%w: %s, expected %s", ErrUnexpectedPeerID, id, expected)
+	}
+	cfg := getNetworkCfg()
+	if cfg == nil || len(cfg.Network.AllowedPeers) == 0 || id == getNodeIdentity().ID() {
+		return nil
+	}
+	for _, allowed := range cfg.Network.AllowedPeers {
+		if id == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrPeerNotAllowed, id)
+}
+
+// transportConfig returns the TLS configuration of the connections with the peers,
+// accepting the certificates of the allowed identities only.
+func transportConfig(expected string) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{getNodeIdentity().cert},
+		MinVersion:   tls.VersionTLS13,
+		// The certificates are self-signed: the peer is authenticated by its key, checked below.
+		InsecureSkipVerify: true,
+		ClientAuth:         tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			id, err := certPeerID(rawCerts)
+			if err != nil {
+				return err
+			}
+			return checkPeerID(id, expected)
+		},
+	}
+}
+
+// certPeerID returns the ID of the peer from its certificate chain.
+func certPeerID(rawCerts [][]byte) (string, error) {
+	if len(rawCerts) == 0 {
+		return "", errors.New("no peer certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return "", err
+	}
+	pubKey, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return "", errors.New("peer certificate without ed25519 key")
+	}
+	return hex.EncodeToString(pubKey), nil
+}
+
+// pinnedID returns the identity pinned for the neighbor of the given address, if any.
+func pinnedID(address string) string {
+	cfg := getNetworkCfg()
+	if cfg == nil {
+		return ""
+	}
+	for _, node := range cfg.Network.NeighborNodes {
+		if node.Address == address {
+			return node.ID
+		}
+	}
+	return ""
+}
+
+// secureOutbound runs the TLS handshake of a connection opened to the given node
+// and returns the secured connection with the ID of the peer. The identity pinned in
+// the config applies to the nodes found by discovery as well.
+func secureOutbound(conn net.Conn, node Node) (*tls.Conn, string, error) {
+	expected := node.ID
+	if expected == "" {
+		expected = pinnedID(node.Address)
+	}
+	return secureConn(tls.Client(conn, transportConfig(expected)))
+}
+
+// secureInbound runs the TLS handshake of a connection accepted by the local node
+// and returns the secured connection with the ID of the peer.
+func secureInbound(conn net.Conn) (*tls.Conn, string, error) {
+	return secureConn(tls.Server(conn, transportConfig("")))
+}
+
+func secureConn(conn *tls.Conn) (*tls.Conn, string, error) {
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+
+	if err := conn.Handshake(); err != nil {
+		return nil, "", err
+	}
+	var rawCerts [][]byte
+	for _, cert := range conn.ConnectionState().PeerCertificates {
+		rawCerts = append(rawCerts, cert.Raw)
+	}
+	id, err := certPeerID(rawCerts)
+	return conn, id, err
+}
+
+// NodeIdentity's methods:
+
+// ID returns the hex public key identifying the node.
+func (identity *NodeIdentity) ID() string {
+	return hex.EncodeToString(identity.key.Public().(ed25519.PublicKey))
+}
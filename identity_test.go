@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadNodeIdentity(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+	// Reading the identity never creates it.
+	if _, err := readNodeIdentity(cfgPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected a missing node key, got %v", err)
+	}
+	if _, err := os.Stat(nodeKeyPath(cfgPath)); !os.IsNotExist(err) {
+		t.Fatalf("Reading the node identity should not create its key")
+	}
+	created, err := loadNodeIdentity(cfgPath)
+	if err != nil {
+		t.Fatalf("Cannot create node identity: %v", err)
+	}
+	loaded, err := loadNodeIdentity(cfgPath)
+	if err != nil || loaded.ID() != created.ID() {
+		t.Errorf("Expected the stored identity %s, got %v (%v)", created.ID(), loaded, err)
+	}
+}
+
+func TestPeerAllowlist(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	stranger := newNodeIdentity(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize)))
+	friend := newNodeIdentity(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize)))
+	setTestConfig(t, &Config{Network: Network{AllowedPeers: []string{friend.ID()}}})
+
+	connect := func(identity *NodeIdentity) (string, error) {
+		client, server := net.Pipe()
+		defer server.Close()
+		go func() {
+			tls.Client(client, &tls.Config{
+				Certificates:       []tls.Certificate{identity.cert},
+				MinVersion:         tls.VersionTLS13,
+				InsecureSkipVerify: true,
+			}).Handshake()
+			client.Close()
+		}()
+		_, id, err := secureInbound(server)
+		return id, err
+	}
+	if id, err := connect(friend); err != nil || id != friend.ID() {
+		t.Errorf("Allowed peer %s refused: %s, %v", friend.ID(), id, err)
+	}
+	if _, err := connect(stranger); !errors.Is(err, ErrPeerNotAllowed) {
+		t.Errorf("Peer out of the allowlist should be refused, got %v", err)
+	}
+	if err := checkPeerID(getNodeIdentity().ID(), ""); err != nil {
+		t.Errorf("Local node should always be allowed: %v", err)
+	}
+}
+
+func TestPinnedPeerID(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+	node := serveTestChain(t, bc)
+
+	node.ID = newNodeIdentity(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))).ID()
+	if _, err := getDepthNeighbor(node); err == nil {
+		t.Errorf("Node answering with another identity than the pinned one should be refused")
+	}
+	node.ID = getNodeIdentity().ID()
+	if depth, err := getDepthNeighbor(node); err != nil || depth != 1 {
+		t.Errorf("Unexpected depth %d from the pinned node: %v", depth, err)
+	}
+	// The open connection is not shared with the requests expecting another node (eg: the source of a message).
+	other := Node{Address: node.Address, ID: strings.Repeat("00", ed25519.PublicKeySize)}
+	if _, err := getDepthNeighbor(other); !errors.Is(err, ErrUnexpectedPeerID) {
+		t.Errorf("Connection to another node than the expected one should be refused, got %v", err)
+	}
+	closePeerClients()
+
+	// The identity pinned in the config applies to the address found by discovery.
+	nwConfig.Network.NeighborNodes = []Node{{Address: node.Address, ID: strings.Repeat("00", ed25519.PublicKeySize)}}
+	if _, err := getDepthNeighbor(Node{Address: node.Address}); err == nil {
+		t.Errorf("Neighbor answering with another identity than the pinned one should be refused")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Inventory gossip: a node announces the IDs of its new blocks and transactions (INV) to its peers,
+// which answer with the ones they lack (GET_DATA), then receive them (RES_DATA, carrying the INV's ID).
+// Accepted items are announced in turn to the other peers; the seen-set remembers the recent items
+// so that an announcement going around the network is not relayed again.
+
+const (
+	INV_BLOCK = "block"
+	INV_TX    = "tx"
+
+	MAX_INV_PER_MSG  = 1000             // Largest number of items announced in one INV.
+	MAX_SEEN_INV     = 50000            // Items remembered by the seen-set.
+	INV_DATA_TIMEOUT = 30 * time.Second // Delay to receive the requested items.
+)
+
+// InvItem identifies a block or a transaction.
+type InvItem struct {
+	Type string `json:"type"` // INV_BLOCK or INV_TX.
+	Hash []byte `json:"hash"` // Block hash or transaction ID.
+}
+
+// InvData holds the items requested by GET_DATA.
+type InvData struct {
+	InvID  uint32   `json:"inv_id"` // ID of the INV message announcing the items.
+	Blocks []*Block `json:"blocks,omitempty"`
+	Txs    [][]byte `json:"txs,omitempty"` // Serialized transactions.
+}
+
+// SeenInv is a bounded set of the items recently seen, the oldest ones being forgotten first.
+type SeenInv struct {
+	mu    sync.Mutex
+	max   int
+	items map[string]bool
+	order []string
+}
+
+// Items seen by the running node.
+var seenInv = newSeenInv(MAX_SEEN_INV)
+
+// Utility functions start from here.
+
+func newSeenInv(max int) *SeenInv {
+	return &SeenInv{max: max, items: make(map[string]bool)}
+}
+
+// announceBlock announces a new block of the local chain to the peers, except `exclude`.
+func announceBlock(bc *Blockchain, block *Block, exclude string) {
+	item := InvItem{Type: INV_BLOCK, Hash: block.Header.Hash}
+	seenInv.Add(item)
+	relayInv(bc, []InvItem{item}, exclude)
+}
+
+// announceTx announces a new transaction of the mempool to the peers, except `exclude`.
+func announceTx(bc *Blockchain, tx *Transaction, exclude string) {
+	item := InvItem{Type: INV_TX, Hash: tx.ID}
+	seenInv.Add(item)
+	relayInv(bc, []InvItem{item}, exclude)
+}
+
+// relayInv sends the announcement to the peers, except `exclude` (eg: the node it came from).
+func relayInv(bc *Blockchain, items []InvItem, exclude string) {
+	for _, node := range getPeerNodes() {
+		if node.Address != exclude {
+			go sendInv(bc, node, items)
+		}
+	}
+}
+
+// sendInv announces the items to the node and sends the ones it requests.
+func sendInv(bc *Blockchain, node Node, items []InvItem) {
+	client, err := getPeerClient(node)
+	if err != nil {
+		Info.Printf("Cannot announce to %s: %v", node.Address, err)
+		return
+	}
+	inv := createMsgInv(items)
+	msgRes, err := client.Request(inv)
+	if err == nil {
+		err = checkMsgCmd(msgRes, CGetData)
+	}
+	if err != nil {
+		Warning.Printf("No answer to the announcement from %s: %v", node.Address, err)
+		return
+	}
+	wanted, err := deserializeInv(msgRes.Data)
+	if err != nil {
+		getPeerManager().Misbehave(peerKey(node.Address), MISBEHAVIOUR_MALFORMED_MSG, err.Error())
+		return
+	}
+	if len(wanted) == 0 {
+		return
+	}
+
+	data := &InvData{InvID: inv.ID}
+	for _, item := range wanted {
+		switch {
+		case !containsInv(items, item):
+			// Only the announced items are sent.
+		case item.Type == INV_BLOCK:
+			if block := bc.GetBlockByHash(item.Hash); block != nil {
+				data.Blocks = append(data.Blocks, block)
+			}
+		case item.Type == INV_TX:
+			if tx, ok := getMempool().Get(item.Hash); ok {
+				data.Txs = append(data.Txs, tx.Serialize())
+			}
+		}
+	}
+	compact := client.Version().Protocol >= BINARY_PROTOCOL_VERSION
+	if err = client.Send(createMsgResData(data, compact)); err != nil {
+		Warning.Printf("Cannot send the announced items to %s: %v", node.Address, err)
+	}
+}
+
+// receiveInvData accepts the items requested from the announcing node.
+func receiveInvData(bc *Blockchain, data *InvData, source Node, key string, wanted []InvItem) {
+	if len(data.Blocks)+len(data.Txs) == 0 {
+		getPeerManager().Misbehave(key, MISBEHAVIOUR_BOGUS_ANNOUNCE, "announced items not delivered")
+		return
+	}
+
+	for _, encoded := range data.Txs {
+		tx, err := DeserializeTx(encoded)
+		if err != nil {
+			getPeerManager().Misbehave(key, MISBEHAVIOUR_MALFORMED_MSG, fmt.Sprintf("malformed transaction: %v", err))
+			return
+		}
+		item := InvItem{Type: INV_TX, Hash: tx.ID}
+		if !containsInv(wanted, item) || !seenInv.Add(item) {
+			continue
+		}
+		if acceptTx(bc, tx, key) {
+			Info.Printf("Relaying transaction %x from %s", tx.ID, source.Address)
+			announceTx(bc, tx, source.Address)
+		}
+	}
+
+	for _, block := range data.Blocks {
+		if block == nil {
+			continue
+		}
+		item := InvItem{Type: INV_BLOCK, Hash: block.Header.Hash}
+		if !containsInv(wanted, item) || seenInv.Has(item) {
+			continue
+		}
+		acceptBlock(bc, block, source, key)
+	}
+}
+
+// acceptBlock adds an announced block extending the local chain, or synchronizes with its
+// announcing node (headers first) if the block is higher than the local chain without extending it:
+// the local chain misses its ancestors, or forks from the node's one.
+func acceptBlock(bc *Blockchain, block *Block, source Node, key string) {
+	if !bytes.Equal(block.Header.PrevBlockHash, bc.GetLatestHash()) || bc.IsEmpty() {
+		if block.Header.Depth > bc.GetDepth() {
+			Info.Printf("Block %x does not extend the local chain, synchronizing with %s", block.Header.Hash, source.Address)
+			reqConnectBC(source, bc)
+		}
+		if bc.GetBlockByHash(block.Header.Hash) != nil {
+			announceBlock(bc, block, source.Address)
+		}
+		return
+	}
+
+	if !newProofOfWork(block).ValidateHash() || !bc.AddBlock(block) {
+		getPeerManager().Misbehave(key, MISBEHAVIOUR_INVALID_BLOCK, fmt.Sprintf("invalid block [%d] %x", block.Header.Depth, block.Header.Hash))
+		return
+	}
+	Info.Printf("Relaying block [%d] %x from %s", block.Header.Depth, block.Header.Hash, source.Address)
+	announceBlock(bc, block, source.Address)
+}
+
+// haveInv returns true if the local node already has the item.
+func haveInv(bc *Blockchain, item InvItem) bool {
+	if seenInv.Has(item) {
+		return true
+	}
+	switch item.Type {
+	case INV_BLOCK:
+		return bc.GetBlockByHash(item.Hash) != nil
+	case INV_TX:
+		_, ok := getMempool().Get(item.Hash)
+		return ok
+	}
+	return false
+}
+
+// deserializeInv decodes an INV or GET_DATA payload.
+func deserializeInv(data []byte) ([]InvItem, error) {
+	var items []InvItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("malformed inventory: %v", err)
+	}
+	if len(items) > MAX_INV_PER_MSG {
+		return nil, fmt.Errorf("inventory of %d items exceeds %d", len(items), MAX_INV_PER_MSG)
+	}
+	for _, item := range items {
+		if item.Type != INV_BLOCK && item.Type != INV_TX {
+			return nil, fmt.Errorf("unknown inventory type %q", item.Type)
+		}
+	}
+	return items, nil
+}
+
+func containsInv(items []InvItem, item InvItem) bool {
+	for _, other := range items {
+		if other.Type == item.Type && bytes.Equal(other.Hash, item.Hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// InvItem's methods:
+
+func (item InvItem) key() string {
+	return item.Type + ":" + hex.EncodeToString(item.Hash)
+}
+
+// SeenInv's methods:
+
+// Add remembers the item and returns false if it was already seen.
+func (seen *SeenInv) Add(item InvItem) bool {
+	seen.mu.Lock()
+	defer seen.mu.Unlock()
+
+	key := item.key()
+	if seen.items[key] {
+		return false
+	}
+	seen.items[key] = true
+	seen.order = append(seen.order, key)
+	if len(seen.order) > seen.max {
+		delete(seen.items, seen.order[0])
+		seen.order = seen.order[1:]
+	}
+	return true
+}
+
+func (seen *SeenInv) Has(item InvItem) bool {
+	seen.mu.Lock()
+	defer seen.mu.Unlock()
+	return seen.items[item.key()]
+}
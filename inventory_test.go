@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSeenInv(t *testing.T) {
+	seen := newSeenInv(2)
+	first, second, third := InvItem{INV_TX, []byte{1}}, InvItem{INV_TX, []byte{2}}, InvItem{INV_BLOCK, []byte{1}}
+	if !seen.Add(first) || seen.Add(first) {
+		t.Errorf("An item should be added once")
+	}
+	seen.Add(second)
+	seen.Add(third)
+	if seen.Has(first) || !seen.Has(second) || !seen.Has(third) {
+		t.Errorf("The oldest item should be forgotten first")
+	}
+}
+
+func TestInvGossip(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	seenInv = newSeenInv(MAX_SEEN_INV)
+	remote := newTestChain(t, "remote")
+	remote.AddBlock(getChainParams().GenesisBlock())
+	remote.AddBlock(newBlock([]Transaction{*newCoinBaseTx(newWallet().Address)}, remote.GetLatestHash(), 2))
+	local := newTestChain(t, "local")
+	local.AddBlock(remote.GetBlockByDepth(1))
+
+	requested := make(chan []InvItem)
+	delivered := make(chan []InvItem, 1)
+	node := serveTestConns(t, nil, func(req *reqConn, msg *Message) {
+		if msg.Cmd == CInv {
+			var wanted []InvItem
+			items, _ := deserializeInv(msg.Data)
+			for _, item := range items {
+				if !haveInv(local, item) {
+					wanted = append(wanted, item)
+				}
+			}
+			if len(wanted) == 0 {
+				defer func() { requested <- wanted }()
+			} else {
+				delivered <- wanted
+			}
+		}
+		handleMsg(req, local, msg, "")
+		if msg.Cmd == CResData {
+			requested <- <-delivered
+		}
+	})
+	item := InvItem{Type: INV_BLOCK, Hash: remote.GetLatestHash()}
+	sendInv(remote, node, []InvItem{item})
+	if wanted := <-requested; len(wanted) != 1 {
+		t.Fatalf("The missing block should be requested, got %v", wanted)
+	}
+	if !bytes.Equal(local.GetLatestHash(), remote.GetLatestHash()) || !seenInv.Has(item) {
+		t.Errorf("The announced block should be added and marked as seen")
+	}
+
+	// Announced again (eg: by another peer), the block is not requested twice.
+	sendInv(remote, node, []InvItem{item})
+	if wanted := <-requested; len(wanted) != 0 {
+		t.Errorf("A known block should not be requested, got %v", wanted)
+	}
+}
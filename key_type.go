@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// Key types of the wallets. P-256 keys keep their historical encodings (raw `X || Y` public key,
+// address without key type), the other types are tagged so that a public key or an address
+// tells which scheme verifies it:
+//	. public key : KEY_TAG || key (secp256k1: X || Y padded to 32 bytes, ed25519: 32 bytes)
+//	. address    : base58Encode(nwVersion + KEY_TAG + Pk_Hash + checksum)
+
+const (
+	KEY_TYPE_P256      = "p256"
+	KEY_TYPE_SECP256K1 = "secp256k1"
+	KEY_TYPE_ED25519   = "ed25519"
+
+	KEY_TAG_SECP256K1 = byte(0x01)
+	KEY_TAG_ED25519   = byte(0x02)
+
+	DEFAULT_KEY_TYPE = KEY_TYPE_P256
+)
+
+// Utility functions start from here.
+
+// normalizeKeyType returns the canonical name of the key type ("" = P-256).
+func normalizeKeyType(keyType string) (string, error) {
+	switch keyType {
+	case "", KEY_TYPE_P256:
+		return KEY_TYPE_P256, nil
+	case KEY_TYPE_SECP256K1, KEY_TYPE_ED25519:
+		return keyType, nil
+	}
+	return "", fmt.Errorf("unknown key type %q (known: %s, %s, %s)",
+		keyType, KEY_TYPE_P256, KEY_TYPE_SECP256K1, KEY_TYPE_ED25519)
+}
+
+// keyTypeOf returns the key type of the given (possibly tagged) public key.
+func keyTypeOf(pubKey []byte) string {
+	switch {
+	case len(pubKey) == 1+64 && pubKey[0] == KEY_TAG_SECP256K1:
+		return KEY_TYPE_SECP256K1
+	case len(pubKey) == 1+ed25519.PublicKeySize && pubKey[0] == KEY_TAG_ED25519:
+		return KEY_TYPE_ED25519
+	}
+	return KEY_TYPE_P256
+}
+
+// keyTagOf returns the tag of the key type, or 0 for the untagged P-256 keys.
+func keyTagOf(keyType string) byte {
+	switch keyType {
+	case KEY_TYPE_SECP256K1:
+		return KEY_TAG_SECP256K1
+	case KEY_TYPE_ED25519:
+		return KEY_TAG_ED25519
+	}
+	return 0
+}
+
+// ecdsaCurveOf returns the ECDSA curve of the key type.
+func ecdsaCurveOf(keyType string) elliptic.Curve {
+	if keyType == KEY_TYPE_SECP256K1 {
+		return Secp256k1()
+	}
+	return elliptic.P256()
+}
+
+// newWalletOf returns a new wallet with a random key of the given type.
+func newWalletOf(keyType string) (*Wallet, error) {
+	keyType, err := normalizeKeyType(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	switch keyType {
+	case KEY_TYPE_P256:
+		return newWallet(), nil
+	case KEY_TYPE_ED25519:
+		_, privKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return walletFromPrivKey(keyType, privKey.Seed())
+	}
+
+	curveN := ecdsaCurveOf(keyType).Params().N
+	d, err := rand.Int(rand.Reader, new(big.Int).Sub(curveN, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	return walletFromPrivKey(keyType, d.Add(d, big.NewInt(1)).Bytes())
+}
+
+// walletFromPrivKey returns the wallet owning the given private key
+// (the scalar of the ECDSA keys, the 32 bytes seed of the ed25519 ones).
+func walletFromPrivKey(keyType string, privKey []byte) (*Wallet, error) {
+	keyType, err := normalizeKeyType(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Wallet{KeyType: keyType}
+	if keyType == KEY_TYPE_ED25519 {
+		if len(privKey) != ed25519.SeedSize {
+			return nil, fmt.Errorf("ed25519 private key must be %d bytes", ed25519.SeedSize)
+		}
+		w.EdPrivateKey = ed25519.NewKeyFromSeed(privKey)
+		w.PublicKey = append([]byte{KEY_TAG_ED25519}, w.EdPrivateKey.Public().(ed25519.PublicKey)...)
+		w.Address = genAddr(w.PublicKey)
+		return w, nil
+	}
+
+	curve := ecdsaCurveOf(keyType)
+	d := new(big.Int).SetBytes(privKey)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("private key is out of the curve's range")
+	}
+	w.PrivateKey.D = d
+	w.PrivateKey.PublicKey.Curve = curve
+	w.PrivateKey.PublicKey.X, w.PrivateKey.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	if keyType == KEY_TYPE_P256 {
+		w.PublicKey = encodePubKey(&w.PrivateKey.PublicKey)
+	} else {
+		w.PublicKey = append([]byte{KEY_TAG_SECP256K1}, encodePubKey(&w.PrivateKey.PublicKey)...)
+	}
+	w.Address = genAddr(w.PublicKey)
+	return w, nil
+}
+
+// verifyPubKeySig checks the signature of the data, dispatching on the public key's type.
+func verifyPubKeySig(pubKey, data, signature []byte) bool {
+	keyType := keyTypeOf(pubKey)
+	if keyType == KEY_TYPE_ED25519 {
+		return len(signature) == ed25519.SignatureSize && ed25519.Verify(pubKey[1:], data, signature)
+	}
+
+	signLen := len(signature)
+	if signLen == 0 || signLen%2 != 0 {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:(signLen / 2)])
+	s := new(big.Int).SetBytes(signature[(signLen / 2):])
+
+	var x, y *big.Int
+	if keyType == KEY_TYPE_SECP256K1 {
+		x, y = new(big.Int).SetBytes(pubKey[1:33]), new(big.Int).SetBytes(pubKey[33:])
+	} else {
+		x, y = splitPubKey(pubKey)
+	}
+	curve := ecdsaCurveOf(keyType)
+	if !curve.IsOnCurve(x, y) {
+		return false
+	}
+	return ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, data, r, s)
+}
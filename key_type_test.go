@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSecp256k1Curve(t *testing.T) {
+	curve := Secp256k1()
+	params := curve.Params()
+	if !curve.IsOnCurve(params.Gx, params.Gy) {
+		t.Fatalf("Generator must lie on the curve!")
+	}
+
+	// Known multiples of the generator.
+	vectors := map[byte][2]string{
+		2: {"C6047F9441ED7D6D3045406E95C07CD85C778E4B8CEF3CA7ABAC09B95C709EE5", "1AE168FEA63DC339A3C58419466CEAEEF7F632653266D0E1236431A950CFE52A"},
+		3: {"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9", "388F7B0F632DE8140FE337E62A37F3566500A99934C2231B6CB9FD7584B8E672"},
+	}
+	for k, point := range vectors {
+		x, y := curve.ScalarBaseMult([]byte{k})
+		if strings.ToUpper(hex.EncodeToString(x.Bytes())) != point[0] || strings.ToUpper(hex.EncodeToString(y.Bytes())) != point[1] {
+			t.Errorf("%d*G = (%X, %X)", k, x, y)
+		}
+	}
+	if x, y := curve.ScalarMult(params.Gx, params.Gy, params.N.Bytes()); x.Sign() != 0 || y.Sign() != 0 {
+		t.Errorf("N*G must be the point at infinity!")
+	}
+}
+
+func TestKeyTypeVectors(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	vectors := []struct {
+		keyType string
+		privKey string
+		pubKey  string
+		address string
+	}{
+		// Existing P-256 wallet (`config/node1/config.json`): encodings are unchanged.
+		{
+			KEY_TYPE_P256,
+			"31aef6a29ba48c6d20d6e5c81b790b365cea17285d27fd8ed020a81c2e6fd1d4",
+			"6b1b2afc327f7aba258d2bfca3934a29be68b038f7690bfd4e598a496ccd684e87e97f6e8275c2e4a3f9d5a2036c6d85ad3b5b10169c616995e512485702c98c",
+			"1N4SVwrbdbwfdTVafJaWrcYREeqPVhS8Zg",
+		},
+		{
+			KEY_TYPE_SECP256K1,
+			"0000000000000000000000000000000000000000000000000000000000000001",
+			"0179be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8",
+			"1fNGcgdgLiahyrmRTVWjrmdytCW3awU2Mj",
+		},
+		// RFC 8032, test 1.
+		{
+			KEY_TYPE_ED25519,
+			"9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60",
+			"02d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+			"127LQfqwBWSkW2NPFDq85PLHKRsdZ9rW4jE",
+		},
+	}
+
+	for _, v := range vectors {
+		w, err := importPrivKey(v.keyType, v.privKey)
+		if err != nil {
+			t.Fatalf("importPrivKey(%s) failed: %v", v.keyType, err)
+		}
+		if hex.EncodeToString(w.PublicKey) != v.pubKey || w.Address != v.address {
+			t.Errorf("%s: public key %x, address %s", v.keyType, w.PublicKey, w.Address)
+		}
+		if keyTypeOf(w.PublicKey) != v.keyType || !validateAddr(w.Address) {
+			t.Errorf("%s: key type or address not recognized", v.keyType)
+		}
+
+		// JSON round trip keeps the key type.
+		restored := w.ToJson().ToWallet()
+		if restored.KeyType != v.keyType || restored.ToJson().PrivateKey != v.privKey {
+			t.Errorf("%s: JSON round trip lost the key", v.keyType)
+		}
+	}
+
+	edWallet, _ := importPrivKey(KEY_TYPE_ED25519, vectors[2].privKey)
+	expectedSig := "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b"
+	if signature, _ := edWallet.SignData(nil); hex.EncodeToString(signature) != expectedSig {
+		t.Errorf("Unexpected ed25519 signature of the empty message")
+	}
+}
+
+func TestSignWithMixedKeyTypes(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	var wallets []*Wallet
+	tx := &Transaction{TxOuts: []TxOutput{*newTxOut(5, newWallet().Address)}}
+	for idx, keyType := range []string{KEY_TYPE_P256, KEY_TYPE_SECP256K1, KEY_TYPE_ED25519} {
+		w, err := newWalletOf(keyType)
+		if err != nil {
+			t.Fatalf("newWalletOf(%s) failed: %v", keyType, err)
+		}
+		wallets = append(wallets, w)
+		tx.TxIns = append(tx.TxIns, TxInput{TxID: []byte("prev"), TxOutIdx: idx, PubKey: w.PublicKey})
+
+		message := "key type " + keyType
+		signature, _ := signWalletMessage(w, message)
+		if err := verifyWalletMessage(w.Address, signature, message); err != nil {
+			t.Errorf("%s: message signature rejected: %v", keyType, err)
+		}
+	}
+	tx.ID = tx.HashTx()
+
+	if err := tx.SignWith(wallets); err != nil {
+		t.Fatalf("SignWith failed: %v", err)
+	}
+	if !tx.VerifySignature() {
+		t.Fatalf("Signatures of mixed key types should be valid!")
+	}
+
+	// A signature verified with the scheme of another key type must fail.
+	tx.TxIns[1].Signature, tx.TxIns[0].Signature = tx.TxIns[0].Signature, tx.TxIns[1].Signature
+	if tx.VerifySignature() {
+		t.Errorf("Swapped signatures should be rejected!")
+	}
+}
+
+func TestLegacyShortP256Key(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	for _, short := range []string{"x", "y"} {
+		// Keys of older wallets were stored as `X || Y` without padding the coordinates.
+		var w *Wallet
+		var legacyKey []byte
+		for legacyKey == nil {
+			w = newWallet()
+			x, y := w.PrivateKey.PublicKey.X.Bytes(), w.PrivateKey.PublicKey.Y.Bytes()
+			if (short == "x" && len(x) < 32 && len(y) == 32) || (short == "y" && len(y) < 32 && len(x) == 32) {
+				legacyKey = append(x, y...)
+			}
+		}
+		wj := w.ToJson()
+		wj.PublicKey = hex.EncodeToString(legacyKey)
+		wj.Address = genAddr(legacyKey)
+		legacy := wj.ToWallet()
+
+		tx := &Transaction{
+			TxIns:  []TxInput{{TxID: []byte("prev"), TxOutIdx: 0, PubKey: legacy.PublicKey}},
+			TxOuts: []TxOutput{*newTxOut(5, newWallet().Address)},
+		}
+		tx.ID = tx.HashTx()
+		if err := tx.SignWith([]*Wallet{legacy}); err != nil {
+			t.Fatalf("Short %s: SignWith failed: %v", short, err)
+		}
+		if !tx.VerifySignature() {
+			t.Errorf("Short %s: signature of a legacy key should be valid", short)
+		}
+		if !bytes.Equal(hashPubKey(tx.TxIns[0].PubKey), addrPubKeyHash(legacy.Address)) {
+			t.Errorf("Short %s: legacy key should still unlock the funds of its address", short)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// Encrypted keystore: the wallet's private key is never written in plain text.
+//
+// Schema:
+//	. scrypt(passphrase, salt, N, r, p) -> 32 bytes key
+//	. AES-256-GCM(key, nonce, WalletJson, aad = version + address) -> ciphertext
+//	--------------------------------------------------------------------------
+//	{version, address, public_key, crypto{kdf, kdf_params, cipher, nonce, ciphertext}}
+
+const (
+	KEYSTORE_VERSION = 1
+	KEYSTORE_FILE    = "keystore.json"
+	KEYSTORE_KDF     = "scrypt"
+	KEYSTORE_CIPHER  = "aes-256-gcm"
+
+	// Default scrypt cost parameters (~64 MiB of memory per derivation).
+	SCRYPT_N       = 1 << 16
+	SCRYPT_R       = 8
+	SCRYPT_P       = 1
+	SCRYPT_KEY_LEN = 32
+	SCRYPT_SALT    = 32
+
+	// Highest scrypt cost parameters accepted from a keystore file, so that a crafted file
+	// cannot make the node allocate more than SCRYPT_MAX_MEM bytes (128 * N * r).
+	SCRYPT_MAX_N   = 1 << 20
+	SCRYPT_MAX_R   = 32
+	SCRYPT_MAX_P   = 16
+	SCRYPT_MAX_MEM = 1 << 30
+)
+
+var (
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupted keystore")
+	ErrScryptParams    = errors.New("unsupported scrypt parameters")
+)
+
+// ScryptParams stores the parameters used to derive the encryption key from the passphrase.
+type ScryptParams struct {
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	KeyLen int    `json:"key_len"`
+	Salt   string `json:"salt"`
+}
+
+// KeystoreCrypto stores the encrypted wallet and everything needed to decrypt it.
+type KeystoreCrypto struct {
+	Kdf        string       `json:"kdf"`
+	KdfParams  ScryptParams `json:"kdf_params"`
+	Cipher     string       `json:"cipher"`
+	Nonce      string       `json:"nonce"`
+	CipherText string       `json:"ciphertext"`
+}
+
+// KeystoreJson is the file format of an encrypted wallet.
+// The public fields are kept in clear so that the node can be identified while locked.
+type KeystoreJson struct {
+	Version   int            `json:"version"`
+	Address   string         `json:"address"`
+	PublicKey string         `json:"public_key"`
+	Crypto    KeystoreCrypto `json:"crypto"`
+}
+
+// Utility functions start from here.
+
+// encryptWallet encrypts the given wallet's JSON with a key derived from the passphrase.
+func encryptWallet(wj *WalletJson, passphrase []byte) (*KeystoreJson, error) {
+	params := ScryptParams{N: SCRYPT_N, R: SCRYPT_R, P: SCRYPT_P, KeyLen: SCRYPT_KEY_LEN}
+	salt := make([]byte, SCRYPT_SALT)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	params.Salt = hex.EncodeToString(salt)
+
+	aead, err := params.newAEAD(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	plainWallet := *wj
+	plainWallet.Keystore = ""
+	plainText, err := json.Marshal(plainWallet)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeystoreJson{
+		Version:   KEYSTORE_VERSION,
+		Address:   wj.Address,
+		PublicKey: wj.PublicKey,
+	}
+	ks.Crypto = KeystoreCrypto{
+		Kdf:        KEYSTORE_KDF,
+		KdfParams:  params,
+		Cipher:     KEYSTORE_CIPHER,
+		Nonce:      hex.EncodeToString(nonce),
+		CipherText: hex.EncodeToString(aead.Seal(nil, nonce, plainText, ks.additionalData())),
+	}
+	return ks, nil
+}
+
+// importKeystore reads an encrypted wallet from the given file path.
+func importKeystore(path string) (*KeystoreJson, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := new(KeystoreJson)
+	if err = json.Unmarshal(contents, ks); err != nil {
+		return nil, err
+	}
+	if ks.Version != KEYSTORE_VERSION {
+		return nil, fmt.Errorf("unsupported keystore version %d", ks.Version)
+	}
+	if ks.Crypto.Kdf != KEYSTORE_KDF || ks.Crypto.Cipher != KEYSTORE_CIPHER {
+		return nil, fmt.Errorf("unsupported keystore scheme %s/%s", ks.Crypto.Kdf, ks.Crypto.Cipher)
+	}
+	if err = ks.Crypto.KdfParams.Check(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// keystorePath returns the path of the keystore file stored next to the given config file.
+func keystorePath(cfgFilePath string) string {
+	return filepath.ToSlash(filepath.Join(filepath.Dir(cfgFilePath), KEYSTORE_FILE))
+}
+
+// walletKeystorePath returns the path of the keystore of an extra key of the wallet store.
+func walletKeystorePath(cfgFilePath, address string) string {
+	return filepath.ToSlash(filepath.Join(filepath.Dir(cfgFilePath), "keystore-"+address+".json"))
+}
+
+// lockWalletJson encrypts the private key of the given wallet's JSON into the keystore file
+// at `ksPath`, and replaces the key by the keystore's path.
+func lockWalletJson(wj *WalletJson, ksPath string, passphrase []byte) error {
+	ks, err := encryptWallet(wj, passphrase)
+	if err != nil {
+		return err
+	}
+	if err = ks.Export(ksPath); err != nil {
+		return err
+	}
+
+	wj.PrivateKey = ""
+	wj.Keystore = ksPath
+	return nil
+}
+
+// ScryptParams's methods:
+
+// Check returns an error if the parameters exceed the limits of the keystores accepted.
+func (params ScryptParams) Check() error {
+	n, r, p := params.N, params.R, params.P
+	if n <= 1 || n > SCRYPT_MAX_N || n&(n-1) != 0 || r <= 0 || r > SCRYPT_MAX_R || p <= 0 || p > SCRYPT_MAX_P ||
+		128*n*r > SCRYPT_MAX_MEM || params.KeyLen != SCRYPT_KEY_LEN {
+		return fmt.Errorf("%w: N=%d r=%d p=%d key_len=%d", ErrScryptParams, n, r, p, params.KeyLen)
+	}
+	return nil
+}
+
+// newAEAD derives the encryption key from the passphrase and returns the AES-GCM cipher.
+func (params ScryptParams) newAEAD(passphrase []byte) (cipher.AEAD, error) {
+	if err := params.Check(); err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(passphrase, salt, params.N, params.R, params.P, params.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeystoreJson's methods:
+
+// Decrypt returns the wallet's JSON stored inside the keystore.
+func (ks *KeystoreJson) Decrypt(passphrase []byte) (*WalletJson, error) {
+	aead, err := ks.Crypto.KdfParams.newAEAD(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(ks.Crypto.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	cipherText, err := hex.DecodeString(ks.Crypto.CipherText)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	plainText, err := aead.Open(nil, nonce, cipherText, ks.additionalData())
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	wj := new(WalletJson)
+	if err = json.Unmarshal(plainText, wj); err != nil {
+		return nil, err
+	}
+	return wj, nil
+}
+
+// Export writes the keystore to the given file path, readable by the owner only.
+func (ks *KeystoreJson) Export(path string) error {
+	prettyMarshal, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, prettyMarshal, 0600)
+}
+
+// additionalData binds the clear fields of the keystore to the ciphertext,
+// so that swapping the address or the version breaks the decryption.
+func (ks *KeystoreJson) additionalData() []byte {
+	return []byte(fmt.Sprintf("%d:%s:%s", ks.Version, ks.Address, ks.PublicKey))
+}
+
+// Passphrase reading:
+
+// File descriptor to read passphrases from (-1 = prompt on the terminal).
+var passphraseFd = -1
+
+// Reader shared by all passphrase reads, so that successive calls consume successive lines.
+var passphraseReader *bufio.Reader
+
+// readPassphrase reads one passphrase, either as the next line of `passphraseFd`
+// or by prompting the user on the terminal, without echoing it.
+func readPassphrase(prompt string) []byte {
+	if stdinFd := int(os.Stdin.Fd()); passphraseFd < 0 && term.IsTerminal(stdinFd) {
+		fmt.Fprintf(os.Stderr, "%s: ", prompt)
+		passphrase, err := term.ReadPassword(stdinFd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			Error.Printf("Cannot read passphrase: %v", err)
+			os.Exit(1)
+		}
+		return passphrase
+	}
+	if passphraseReader == nil {
+		if passphraseFd >= 0 {
+			passphraseReader = bufio.NewReader(os.NewFile(uintptr(passphraseFd), "passphrase"))
+		} else {
+			passphraseReader = bufio.NewReader(os.Stdin)
+		}
+	}
+	if passphraseFd < 0 {
+		fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	}
+
+	line, err := passphraseReader.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		Error.Printf("Cannot read passphrase: %v", err)
+		os.Exit(1)
+	}
+	return []byte(strings.TrimRight(line, "\r\n"))
+}
+
+// readNewPassphrase reads a new passphrase, asking for a confirmation when prompting.
+func readNewPassphrase(prompt string) []byte {
+	passphrase := readPassphrase(prompt)
+	if len(passphrase) == 0 {
+		Error.Print("Passphrase must not be empty!")
+		os.Exit(1)
+	}
+	if passphraseFd < 0 && string(readPassphrase("Repeat "+strings.ToLower(prompt))) != string(passphrase) {
+		Error.Print("Passphrases do not match!")
+		os.Exit(1)
+	}
+	return passphrase
+}
+
+// unlockKeystore decrypts the keystore referenced by the given wallet's JSON.
+func unlockKeystore(wj *WalletJson) *WalletJson {
+	ks, err := importKeystore(wj.Keystore)
+	if err != nil {
+		Error.Printf("Cannot read keystore %s: %v", wj.Keystore, err)
+		os.Exit(1)
+	}
+
+	plainWallet, err := ks.Decrypt(readPassphrase(fmt.Sprintf("Passphrase for %s", ks.Address)))
+	if err != nil {
+		Error.Printf("Cannot unlock keystore %s: %v", wj.Keystore, err)
+		os.Exit(1)
+	}
+	return plainWallet
+}
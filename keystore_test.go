@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	wj := newWallet().ToJson()
+
+	ks, err := encryptWallet(wj, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), KEYSTORE_FILE)
+	if err = ks.Export(path); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	imported, err := importKeystore(path)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	decrypted, err := imported.Decrypt([]byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if *decrypted != *wj {
+		t.Errorf("Decrypted wallet mismatch: %v != %v", decrypted, wj)
+	}
+}
+
+func TestKeystoreRejectsWrongPassphrase(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	ks, err := encryptWallet(newWallet().ToJson(), []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	if _, err = ks.Decrypt([]byte("battery staple")); err != ErrWrongPassphrase {
+		t.Errorf("Wrong passphrase should be rejected, got: %v", err)
+	}
+	// The clear address is authenticated along with the ciphertext.
+	ks.Address = newWallet().Address
+	if _, err = ks.Decrypt([]byte("correct horse")); err != ErrWrongPassphrase {
+		t.Errorf("Tampered address should be rejected, got: %v", err)
+	}
+}
+
+func TestKeystoreRejectsCostlyParams(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	ks, err := encryptWallet(newWallet().ToJson(), []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	for _, tamper := range []func(params *ScryptParams){
+		func(params *ScryptParams) { params.N = 1 << 30 },
+		func(params *ScryptParams) { params.N = 3 },
+		func(params *ScryptParams) { params.R = 1 << 20 },
+		func(params *ScryptParams) { params.P = 1 << 20 },
+		func(params *ScryptParams) { params.N, params.R = SCRYPT_MAX_N, SCRYPT_MAX_R },
+		func(params *ScryptParams) { params.KeyLen = 1 << 30 },
+	} {
+		crafted := *ks
+		tamper(&crafted.Crypto.KdfParams)
+		path := filepath.Join(t.TempDir(), KEYSTORE_FILE)
+		if err = crafted.Export(path); err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+		if _, err = importKeystore(path); !errors.Is(err, ErrScryptParams) {
+			t.Errorf("Keystore with %+v should be rejected, got: %v", crafted.Crypto.KdfParams, err)
+		}
+		if _, err = crafted.Decrypt([]byte("correct horse")); !errors.Is(err, ErrScryptParams) {
+			t.Errorf("Decrypt with %+v should be refused, got: %v", crafted.Crypto.KdfParams, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Node lifecycle: the subsystems of a running node start in order with a context canceled
+// on shutdown, then stop in the reverse order, waiting for their ongoing work to end until
+// the drain timeout, so that nothing is cut off before the database is closed.
+
+const SHUTDOWN_TIMEOUT = 30 * time.Second // Delay for the subsystems to drain their work.
+
+// Lifecycle starts and stops the subsystems of the node.
+type Lifecycle struct {
+	subsystems []*subsystem
+	started    []*subsystem
+	cancel     context.CancelFunc
+}
+
+type subsystem struct {
+	name  string
+	start func(ctx context.Context) error // Starts the work, until `ctx` is canceled (may be nil).
+	stop  func(ctx context.Context) error // Waits for the work to end, until `ctx` expires (may be nil).
+}
+
+// Miner mines the blocks of the accepted transactions until it is stopped.
+type Miner struct {
+	mu      sync.Mutex
+	stopped bool
+	jobs    sync.WaitGroup
+}
+
+// Miner of the running node.
+var miner = &Miner{}
+
+// Utility functions start from here.
+
+func newLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// newNodeLifecycle returns the lifecycle of a node running the given chain, its subsystems
+// started in this order: identity, database, mempool, sync, miner and server.
+func newNodeLifecycle(bc *Blockchain) *Lifecycle {
+	lc := newLifecycle()
+	lc.Add("identity", func(ctx context.Context) error {
+		identity, err := loadNodeIdentity(getNwCfgPath())
+		if err != nil {
+			return fmt.Errorf("cannot load node identity: %w", err)
+		}
+		setNodeIdentity(identity)
+		return nil
+	}, nil)
+	lc.Add("database", nil, func(ctx context.Context) error {
+		if err := bc.DB.Sync(); err != nil {
+			Warning.Printf("Cannot flush the database: %v", err)
+		}
+		return bc.DB.Close()
+	})
+	lc.Add("mempool", nil, func(ctx context.Context) error {
+		if size := getMempool().Size(); size > 0 {
+			Warning.Printf("%d pending transaction(s) dropped from the mempool", size)
+		}
+		return nil
+	})
+
+	var discoveryDone <-chan struct{}
+	lc.Add("sync", func(ctx context.Context) error {
+		discoveryDone = startDiscovery(ctx, bc)
+		syncNeighborBC(ctx, bc)
+		if bc.IsEmpty() && ctx.Err() == nil {
+			genesis := getChainParams().GenesisBlock()
+			if genesis == nil {
+				return fmt.Errorf("genesis block %s not found on the peers", getChainParams().GenesisHash)
+			}
+			Info.Printf("Pull failed, no available node for synchronization. Create new blockchain instead.\n")
+			bc.AddBlock(genesis)
+		}
+		return nil
+	}, func(ctx context.Context) error {
+		err := waitCtx(ctx, func() { <-discoveryDone })
+		closePeerClients()
+		return err
+	})
+	lc.Add("miner", nil, getMiner().Stop)
+
+	var srv *BCServer
+	lc.Add("server", func(ctx context.Context) (err error) {
+		srv, err = startBCServer(ctx, bc)
+		return err
+	}, func(ctx context.Context) error {
+		return srv.Stop(ctx)
+	})
+	return lc
+}
+
+func getMiner() *Miner {
+	return miner
+}
+
+// waitCtx runs the waiting function, giving up once `ctx` expires.
+func waitCtx(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Lifecycle's methods:
+
+// Add appends a subsystem, started after the previous ones and stopped before them.
+func (lc *Lifecycle) Add(name string, start, stop func(ctx context.Context) error) {
+	lc.subsystems = append(lc.subsystems, &subsystem{name: name, start: start, stop: stop})
+}
+
+// Start starts the subsystems in order. Their context is canceled once `ctx` is done or the
+// node stops. If a subsystem fails to start, the started ones are stopped.
+func (lc *Lifecycle) Start(ctx context.Context) error {
+	ctx, lc.cancel = context.WithCancel(ctx)
+	for _, sub := range lc.subsystems {
+		if err := ctx.Err(); err != nil {
+			lc.Stop(SHUTDOWN_TIMEOUT)
+			return fmt.Errorf("start interrupted before %s: %w", sub.name, err)
+		}
+		if sub.start != nil {
+			if err := sub.start(ctx); err != nil {
+				lc.Stop(SHUTDOWN_TIMEOUT)
+				return fmt.Errorf("cannot start %s: %w", sub.name, err)
+			}
+		}
+		lc.started = append(lc.started, sub)
+	}
+	return nil
+}
+
+// Stop cancels the context of the subsystems, then stops them in the reverse order,
+// all of them within the given timeout.
+func (lc *Lifecycle) Stop(timeout time.Duration) {
+	if lc.cancel != nil {
+		lc.cancel()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for i := len(lc.started) - 1; i >= 0; i-- {
+		sub := lc.started[i]
+		if sub.stop == nil {
+			continue
+		}
+		if err := sub.stop(ctx); err != nil {
+			Warning.Printf("Stopping %s: %v", sub.name, err)
+		} else {
+			Info.Printf("Stopped %s", sub.name)
+		}
+	}
+	lc.started = nil
+}
+
+// Miner's methods:
+
+// Mine mines a block of the transactions on top of the chain, adds it and announces it.
+// Returns false if the miner is stopped or the block was not added.
+func (miner *Miner) Mine(bc *Blockchain, txs []Transaction) bool {
+	miner.mu.Lock()
+	if miner.stopped {
+		miner.mu.Unlock()
+		Info.Printf("Miner is stopped, no block mined")
+		return false
+	}
+	miner.jobs.Add(1)
+	miner.mu.Unlock()
+	defer miner.jobs.Done()
+
+	block := newBlock(txs, bc.GetLatestHash(), bc.GetDepth()+1)
+	if !bc.AddBlock(block) {
+		return false
+	}
+	announceBlock(bc, block, "")
+	return true
+}
+
+// Stop refuses the new blocks to mine and waits for the ongoing ones until `ctx` expires.
+func (miner *Miner) Stop(ctx context.Context) error {
+	miner.mu.Lock()
+	miner.stopped = true
+	miner.mu.Unlock()
+	return waitCtx(ctx, miner.jobs.Wait)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLifecycleOrder(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	var events []string
+	lc := newLifecycle()
+	for _, name := range []string{"first", "second", "broken", "never"} {
+		name := name
+		lc.Add(name, func(ctx context.Context) error {
+			if name == "broken" {
+				return errors.New("broken")
+			}
+			events = append(events, "start "+name)
+			return nil
+		}, func(ctx context.Context) error {
+			events = append(events, "stop "+name)
+			return nil
+		})
+	}
+
+	if err := lc.Start(context.Background()); err == nil {
+		t.Fatalf("Start should fail with the broken subsystem")
+	}
+	expected := []string{"start first", "start second", "stop second", "stop first"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %v, got %v", expected, events)
+	}
+}
+
+func TestServerDrain(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{Network: Network{LocalNode: Node{Address: "127.0.0.1:0"}}})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv, err := startBCServer(ctx, bc)
+	if err != nil {
+		t.Skipf("Cannot listen: %v", err)
+	}
+	address := srv.listener.Addr().String()
+	rawConn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Cannot connect: %v", err)
+	}
+	defer rawConn.Close()
+	conn, _, err := secureOutbound(rawConn, Node{})
+	if err == nil {
+		_, err = handshakeOutbound(conn, testPeerVersion())
+	}
+	if err == nil {
+		msg := createMsgReqDepth()
+		msg.ID = 1
+		err = writeMsg(conn, msg)
+	}
+	if err == nil {
+		_, err = expectMsg(conn, CResDepth)
+	}
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	// The open connection of the peer does not delay the shutdown.
+	cancel()
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelDrain()
+	if err := srv.Stop(drainCtx); err != nil {
+		t.Fatalf("Server did not drain: %v", err)
+	}
+	if conn, err := net.DialTimeout("tcp", address, time.Second); err == nil {
+		conn.Close()
+		t.Errorf("Stopped server should not accept connections")
+	}
+}
+
+func TestMinerStop(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+
+	miner := &Miner{}
+	if !miner.Mine(bc, []Transaction{}) || bc.GetDepth() != 2 {
+		t.Fatalf("Block should be mined")
+	}
+	if err := miner.Stop(context.Background()); err != nil {
+		t.Fatalf("Idle miner should stop at once: %v", err)
+	}
+	if miner.Mine(bc, []Transaction{}) || bc.GetDepth() != 2 {
+		t.Errorf("Stopped miner should not mine")
+	}
+}
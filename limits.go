@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Inbound limits: the node caps the connections opened by its peers, in total and per IP
+// (except the local host's), and the rate of each peer's requests with a token bucket per
+// class of command. The expensive requests run on a bounded pool of workers.
+
+const (
+	DEFAULT_MAX_INBOUND        = 64
+	DEFAULT_MAX_INBOUND_PER_IP = 8
+	MAX_INFLIGHT_PER_CONN      = 32               // Requests of a connection handled at once.
+	WRITE_TIMEOUT              = 30 * time.Second // Delay for the peer to read a message.
+
+	CLASS_SYNC  = "sync"  // Requests of the chain's headers and blocks.
+	CLASS_RELAY = "relay" // Announcements and transactions.
+	CLASS_QUERY = "query" // Other requests about the node's state.
+)
+
+var (
+	ErrTooManyConns = errors.New("too many connections")
+	ErrRateLimited  = errors.New("rate limit exceeded")
+)
+
+// Default rate limits of each peer, by class of command.
+var defaultRateLimits = map[string]RateLimit{
+	CLASS_SYNC:  {Rate: 20, Burst: 100},
+	CLASS_RELAY: {Rate: 10, Burst: 50},
+	CLASS_QUERY: {Rate: 5, Burst: 20},
+}
+
+// RateLimit is the number of requests a peer may send per second, in bursts of `Burst` at most.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst,omitempty"` // Default to the rate.
+}
+
+// InboundStats counts the inbound connections and the refused ones.
+type InboundStats struct {
+	Open          int    `json:"open"`            // Connections currently open.
+	Accepted      uint64 `json:"accepted"`        // Connections accepted since the start.
+	RejectedTotal uint64 `json:"rejected_total"`  // Connections refused over `max_inbound`.
+	RejectedPerIP uint64 `json:"rejected_per_ip"` // Connections refused over `max_inbound_per_ip`.
+	RateLimited   uint64 `json:"rate_limited"`    // Requests refused over the rate limits.
+}
+
+// InboundLimiter enforces the limits of the inbound connections.
+type InboundLimiter struct {
+	mu    sync.Mutex
+	perIP map[string]int
+	peers map[string]*peerLimits // Rate limits of the connected peers, by peer key.
+	stats InboundStats
+}
+
+// peerLimits are the token buckets of a peer, shared by its connections.
+type peerLimits struct {
+	conns   int
+	buckets map[string]*tokenBucket // By class of command.
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// Limiter of the running node's inbound connections.
+var inboundLimiter = newInboundLimiter()
+
+// Pool of workers running the expensive requests, sized on first use.
+var workerPool struct {
+	once  sync.Once
+	slots chan struct{}
+}
+
+// Utility functions start from here.
+
+func newInboundLimiter() *InboundLimiter {
+	return &InboundLimiter{perIP: make(map[string]int), peers: make(map[string]*peerLimits)}
+}
+
+func getInboundLimiter() *InboundLimiter {
+	return inboundLimiter
+}
+
+// maxInbound returns the number of inbound connections accepted at once, in total and per IP.
+func maxInbound() (int, int) {
+	total, perIP := DEFAULT_MAX_INBOUND, DEFAULT_MAX_INBOUND_PER_IP
+	if cfg := getNetworkCfg(); cfg != nil {
+		if cfg.Network.MaxInbound > 0 {
+			total = cfg.Network.MaxInbound
+		}
+		if cfg.Network.MaxInboundPerIP > 0 {
+			perIP = cfg.Network.MaxInboundPerIP
+		}
+	}
+	return total, perIP
+}
+
+// rateLimit returns the rate limit of each peer for the given class of command.
+func rateLimit(class string) RateLimit {
+	limit := defaultRateLimits[class]
+	if cfg := getNetworkCfg(); cfg != nil {
+		if custom, ok := cfg.Network.RateLimits[class]; ok {
+			limit = custom
+		}
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+	return limit
+}
+
+// checkRateLimits returns an error if the configured rate limits are invalid.
+func checkRateLimits(limits map[string]RateLimit) error {
+	for class, limit := range limits {
+		if _, ok := defaultRateLimits[class]; !ok {
+			return fmt.Errorf("unknown class of command %q", class)
+		}
+		if limit.Rate <= 0 || limit.Burst < 0 {
+			return fmt.Errorf("invalid rate limit of %s: %v requests per second, bursts of %d", class, limit.Rate, limit.Burst)
+		}
+	}
+	return nil
+}
+
+// runOnWorker runs the function once a worker of the pool is free.
+func runOnWorker(fn func()) {
+	workerPool.once.Do(func() {
+		workers := runtime.NumCPU()
+		if cfg := getNetworkCfg(); cfg != nil && cfg.Network.Workers > 0 {
+			workers = cfg.Network.Workers
+		}
+		workerPool.slots = make(chan struct{}, workers)
+	})
+	workerPool.slots <- struct{}{}
+	defer func() { <-workerPool.slots }()
+	fn()
+}
+
+// InboundLimiter's methods:
+
+// Accept registers a new inbound connection, unless the limits are reached.
+// An accepted connection must be released once closed.
+func (limiter *InboundLimiter) Accept(conn net.Conn) error {
+	total, perIP := maxInbound()
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.stats.Open >= total {
+		limiter.stats.RejectedTotal++
+		return fmt.Errorf("%w: %d inbound connections open", ErrTooManyConns, limiter.stats.Open)
+	}
+	if !isLoopbackHost(host) && limiter.perIP[host] >= perIP {
+		limiter.stats.RejectedPerIP++
+		return fmt.Errorf("%w: %d inbound connections open from %s", ErrTooManyConns, limiter.perIP[host], host)
+	}
+	limiter.stats.Open++
+	limiter.stats.Accepted++
+	limiter.perIP[host]++
+	return nil
+}
+
+// Release unregisters a closed inbound connection.
+func (limiter *InboundLimiter) Release(conn net.Conn) {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.stats.Open--
+	if limiter.perIP[host]--; limiter.perIP[host] <= 0 {
+		delete(limiter.perIP, host)
+	}
+}
+
+// Join starts limiting the rate of a connection of the peer, until it leaves.
+func (limiter *InboundLimiter) Join(key string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	peer, ok := limiter.peers[key]
+	if !ok {
+		peer = &peerLimits{buckets: make(map[string]*tokenBucket)}
+		limiter.peers[key] = peer
+	}
+	peer.conns++
+}
+
+// Leave forgets the rate limits of the peer once its last connection is closed.
+func (limiter *InboundLimiter) Leave(key string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if peer, ok := limiter.peers[key]; ok {
+		if peer.conns--; peer.conns <= 0 {
+			delete(limiter.peers, key)
+		}
+	}
+}
+
+// Allow returns true if the peer, which joined, may send the command now.
+func (limiter *InboundLimiter) Allow(key, cmd string) bool {
+	class := cmdClass(cmd)
+	if class == "" {
+		return true
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	peer, ok := limiter.peers[key]
+	if !ok {
+		return true
+	}
+	bucket, ok := peer.buckets[class]
+	if !ok {
+		limit := rateLimit(class)
+		bucket = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+		peer.buckets[class] = bucket
+	}
+	if !bucket.Take(time.Now()) {
+		limiter.stats.RateLimited++
+		return false
+	}
+	return true
+}
+
+// ResetRates forgets the token buckets of the peers, refilled with the current rate limits.
+func (limiter *InboundLimiter) ResetRates() {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	for _, peer := range limiter.peers {
+		peer.buckets = make(map[string]*tokenBucket)
+	}
+}
+
+// Stats returns the counters of the inbound connections.
+func (limiter *InboundLimiter) Stats() InboundStats {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return limiter.stats
+}
+
+// tokenBucket's methods:
+
+// Take removes a token from the bucket, refilled since the last call, if any is left.
+func (bucket *tokenBucket) Take(now time.Time) bool {
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = minVal(bucket.tokens+elapsed*bucket.limit.Rate, float64(bucket.limit.Burst))
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addrConn is a connection from the given remote address.
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (conn *addrConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+func TestInboundLimiter(t *testing.T) {
+	setTestConfig(t, &Config{Network: Network{MaxInbound: 4, MaxInboundPerIP: 2}})
+	limiter := newInboundLimiter()
+	from := func(host string) net.Conn {
+		return &addrConn{remote: &net.TCPAddr{IP: net.ParseIP(host), Port: 1234}}
+	}
+
+	first := from("10.0.0.1")
+	for i := 0; i < 2; i++ {
+		if err := limiter.Accept(first); err != nil {
+			t.Fatalf("Connection %d refused: %v", i, err)
+		}
+	}
+	if err := limiter.Accept(from("10.0.0.1")); !errors.Is(err, ErrTooManyConns) {
+		t.Errorf("Connection over the per-IP limit should be refused, got %v", err)
+	}
+	// The local host is only subject to the total limit.
+	for i := 0; i < 2; i++ {
+		if err := limiter.Accept(from("127.0.0.1")); err != nil {
+			t.Fatalf("Local connection %d refused: %v", i, err)
+		}
+	}
+	if err := limiter.Accept(from("10.0.0.2")); !errors.Is(err, ErrTooManyConns) {
+		t.Errorf("Connection over the total limit should be refused, got %v", err)
+	}
+	limiter.Release(first)
+	if err := limiter.Accept(from("10.0.0.2")); err != nil {
+		t.Errorf("Connection refused after another one closed: %v", err)
+	}
+
+	stats := limiter.Stats()
+	if stats.Open != 4 || stats.Accepted != 5 || stats.RejectedPerIP != 1 || stats.RejectedTotal != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := &tokenBucket{limit: RateLimit{Rate: 2, Burst: 3}, tokens: 3, last: now}
+	for i := 0; i < 3; i++ {
+		if !bucket.Take(now) {
+			t.Fatalf("Request %d of the burst refused", i)
+		}
+	}
+	if bucket.Take(now) {
+		t.Errorf("Request over the burst should be refused")
+	}
+	if !bucket.Take(now.Add(500*time.Millisecond)) || bucket.Take(now.Add(500*time.Millisecond)) {
+		t.Errorf("Bucket should be refilled with 1 token after half a second")
+	}
+	if bucket.Take(now.Add(time.Hour)); bucket.tokens != 2 {
+		t.Errorf("Bucket should not be refilled past its burst, got %v tokens", bucket.tokens)
+	}
+}
+
+func TestRateLimitedRequests(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{Network: Network{RateLimits: map[string]RateLimit{CLASS_SYNC: {Rate: 0.01, Burst: 2}}}})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+	node := serveTestChain(t, bc)
+
+	refused := getInboundLimiter().Stats().RateLimited
+	for i := 0; i < 2; i++ {
+		if _, err := getDepthNeighbor(node); err != nil {
+			t.Fatalf("Request %d refused: %v", i, err)
+		}
+	}
+	if _, err := getDepthNeighbor(node); err == nil || !strings.Contains(err.Error(), ErrRateLimited.Error()) {
+		t.Errorf("Request over the rate limit should be refused, got %v", err)
+	}
+	if _, err := reqNeighbor(createMsgPing(), node); err != nil {
+		t.Errorf("Pings should not be rate limited: %v", err)
+	}
+	if stats := getInboundLimiter().Stats(); stats.RateLimited != refused+1 {
+		t.Errorf("Expected 1 more refused request, got %+v", stats)
+	}
+
+	if err := checkRateLimits(map[string]RateLimit{"mining": {Rate: 1}}); err == nil {
+		t.Errorf("Unknown class of command should be refused")
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	TX_STATE_UNKNOWN    = "unknown"    // The node never heard about the transaction.
+	TX_STATE_MEMPOOL    = "mempool"    // The transaction is valid and waiting to be mined.
+	TX_STATE_CONFIRMED  = "confirmed"  // The transaction is stored inside a block of the chain.
+	TX_STATE_CONFLICTED = "conflicted" // The transaction double-spends an output spent by another one.
+)
+
+const (
+	TX_STATE_POLL_INTERVAL = 5 * time.Second // Interval between two state requests of `tx-status --wait`.
+	MAX_MEMPOOL_CONFLICTS  = 10000           // Conflicted transactions remembered, the oldest forgotten first.
+)
+
+// TxStatus reports the state of one transaction as seen by a node.
+type TxStatus struct {
+	TxID          string `json:"tx_id"`                   // Hex-encoded transaction ID.
+	State         string `json:"state"`                   // One of the `TX_STATE_*` values.
+	Depth         int    `json:"depth,omitempty"`         // Depth of the block containing the transaction.
+	Confirmations int    `json:"confirmations"`           // Number of blocks on top of (and including) that block.
+	ConflictWith  string `json:"conflict_with,omitempty"` // Transaction ID that spent the same outputs.
+}
+
+// Mempool holds the transactions accepted by the local node which are not mined yet,
+// and remembers the ones dropped because of a conflict.
+type Mempool struct {
+	mu            sync.Mutex
+	txs           map[string]Transaction // Pending transactions by hex ID.
+	conflicts     map[string]string      // Dropped transaction ID -> conflicting transaction ID.
+	conflictOrder []string               // Dropped transaction IDs, oldest first.
+}
+
+// Utility functions start from here.
+
+var mempool = newMempool()
+
+func getMempool() *Mempool {
+	return mempool
+}
+
+// newMempool returns an empty Mempool instance.
+func newMempool() *Mempool {
+	return &Mempool{
+		txs:       make(map[string]Transaction),
+		conflicts: make(map[string]string),
+	}
+}
+
+// outpointKey returns the key identifying the output spent by the given input.
+func outpointKey(txIn TxInput) string {
+	return fmt.Sprintf("%x:%d", txIn.TxID, txIn.TxOutIdx)
+}
+
+// Mempool's methods:
+
+// Add stores the transaction as pending. It returns the ID of a pending
+// transaction already spending one of its inputs, if any (the new one is then dropped).
+func (mp *Mempool) Add(tx *Transaction) (string, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	txID := hex.EncodeToString(tx.ID)
+	if conflictID, ok := mp.findSpender(tx); ok && conflictID != txID {
+		mp.markConflict(txID, conflictID)
+		return conflictID, false
+	}
+	mp.txs[txID] = *tx
+	delete(mp.conflicts, txID)
+	return "", true
+}
+
+// Get returns the pending transaction with the given ID.
+func (mp *Mempool) Get(txID []byte) (Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	tx, ok := mp.txs[hex.EncodeToString(txID)]
+	return tx, ok
+}
+
+// Conflict returns the ID of the transaction which caused the given one to be dropped.
+func (mp *Mempool) Conflict(txID []byte) (string, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	conflictID, ok := mp.conflicts[hex.EncodeToString(txID)]
+	return conflictID, ok
+}
+
+// MarkConflict remembers that the given transaction was dropped because of another one.
+func (mp *Mempool) MarkConflict(txID []byte, conflictID []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.markConflict(hex.EncodeToString(txID), hex.EncodeToString(conflictID))
+}
+
+// RemoveConfirmed drops the transactions stored in the given block from the pool.
+// Pending transactions spending the same outputs as the block's ones are marked as conflicted.
+func (mp *Mempool) RemoveConfirmed(block *Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		txID := hex.EncodeToString(tx.ID)
+		delete(mp.txs, txID)
+		delete(mp.conflicts, txID)
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for {
+			pendingID, ok := mp.findSpender(&tx)
+			if !ok {
+				break
+			}
+			delete(mp.txs, pendingID)
+			mp.markConflict(pendingID, txID)
+		}
+	}
+}
+
+// Size returns the number of pending transactions.
+func (mp *Mempool) Size() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return len(mp.txs)
+}
+
+// markConflict remembers the conflict, forgetting the oldest ones past MAX_MEMPOOL_CONFLICTS.
+// NOTE: the caller must hold the lock.
+func (mp *Mempool) markConflict(txID, conflictID string) {
+	if _, ok := mp.conflicts[txID]; !ok {
+		mp.conflictOrder = append(mp.conflictOrder, txID)
+	}
+	mp.conflicts[txID] = conflictID
+	for len(mp.conflictOrder) > MAX_MEMPOOL_CONFLICTS {
+		delete(mp.conflicts, mp.conflictOrder[0])
+		mp.conflictOrder = mp.conflictOrder[1:]
+	}
+}
+
+// findSpender returns the ID of a pending transaction spending one of the given transaction's inputs.
+// NOTE: the caller must hold the lock.
+func (mp *Mempool) findSpender(tx *Transaction) (string, bool) {
+	spent := make(map[string]bool)
+	for _, txIn := range tx.TxIns {
+		spent[outpointKey(txIn)] = true
+	}
+	for pendingID, pendingTx := range mp.txs {
+		for _, txIn := range pendingTx.TxIns {
+			if spent[outpointKey(txIn)] {
+				return pendingID, true
+			}
+		}
+	}
+	return "", false
+}
+
+// TxStatus's methods:
+
+// Stringify returns a string representation for the given transaction's state.
+func (status *TxStatus) Stringify() string {
+	strStatus := fmt.Sprintf("Transaction %s : %s", status.TxID, status.State)
+	switch status.State {
+	case TX_STATE_CONFIRMED:
+		strStatus += fmt.Sprintf(" in block [%d], %d confirmation(s)", status.Depth, status.Confirmations)
+	case TX_STATE_CONFLICTED:
+		strStatus += fmt.Sprintf(" with transaction %s", status.ConflictWith)
+	}
+	return strStatus
+}
+
+// Blockchain's methods:
+
+// GetTxStatus reports the state of the given transaction from both the chain and the mempool.
+func (bc *Blockchain) GetTxStatus(txID []byte) *TxStatus {
+	status := &TxStatus{TxID: hex.EncodeToString(txID), State: TX_STATE_UNKNOWN}
+
+	if block, ok := bc.FindTxBlock(txID); ok {
+		status.State = TX_STATE_CONFIRMED
+		status.Depth = block.Header.Depth
+		status.Confirmations = bc.GetDepth() - block.Header.Depth + 1
+		return status
+	}
+	if _, ok := getMempool().Get(txID); ok {
+		status.State = TX_STATE_MEMPOOL
+		return status
+	}
+	if conflictID, ok := getMempool().Conflict(txID); ok {
+		status.State = TX_STATE_CONFLICTED
+		status.ConflictWith = conflictID
+	}
+	return status
+}
+
+// FindSpender returns the ID of a confirmed transaction spending one of the given transaction's inputs.
+func (bc *Blockchain) FindSpender(tx *Transaction) ([]byte, bool) {
+	if bc.IsEmpty() || tx.IsCoinbase() {
+		return nil, false
+	}
+
+	spent := make(map[string]bool)
+	for _, txIn := range tx.TxIns {
+		spent[outpointKey(txIn)] = true
+	}
+
+	bcIter := bc.Iterator()
+	for {
+		block := bcIter.Next()
+		for _, blockTx := range block.Transactions {
+			if blockTx.IsCoinbase() || bytes.Equal(blockTx.ID, tx.ID) {
+				continue
+			}
+			for _, txIn := range blockTx.TxIns {
+				if spent[outpointKey(txIn)] {
+					return blockTx.ID, true
+				}
+			}
+		}
+		if block.IsGenesis() {
+			break
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMempoolConflicts(t *testing.T) {
+	spend := func(id string) *Transaction {
+		return &Transaction{
+			ID:    []byte(id),
+			TxIns: []TxInput{{TxID: []byte("prev"), TxOutIdx: 0}},
+		}
+	}
+	mp := newMempool()
+
+	if _, ok := mp.Add(spend("first")); !ok {
+		t.Fatalf("First spend should be accepted!")
+	}
+	if conflictID, ok := mp.Add(spend("second")); ok || conflictID != hex.EncodeToString([]byte("first")) {
+		t.Errorf("Double spend should conflict with the pending transaction!")
+	}
+
+	// Mining a third spend of the same output drops the pending one.
+	mp.RemoveConfirmed(&Block{Transactions: []Transaction{*spend("third")}})
+	if _, ok := mp.Get([]byte("first")); ok || mp.Size() != 0 {
+		t.Errorf("Pending transaction should be dropped after a conflicting block!")
+	}
+	if conflictID, ok := mp.Conflict([]byte("first")); !ok || conflictID != hex.EncodeToString([]byte("third")) {
+		t.Errorf("Dropped transaction should be reported as conflicted!")
+	}
+
+	// Only the latest conflicts are remembered.
+	for i := 0; i < MAX_MEMPOOL_CONFLICTS; i++ {
+		mp.MarkConflict([]byte(fmt.Sprintf("dropped %d", i)), []byte("third"))
+	}
+	if _, ok := mp.Conflict([]byte("first")); ok || len(mp.conflicts) != MAX_MEMPOOL_CONFLICTS {
+		t.Errorf("Oldest conflict should be forgotten, %d remembered", len(mp.conflicts))
+	}
+}
+
+func TestAcceptTxForgedConflict(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{Network: Network{LocalNode: Node{Address: "localhost:3331"}}})
+	oldPM, oldMempool := getPeerManager(), mempool
+	t.Cleanup(func() { setPeerManager(oldPM); mempool = oldMempool })
+	pm, _ := newPeerManager(nil)
+	setPeerManager(pm)
+	mempool = newMempool()
+
+	sender := newWallet()
+	bc := newTestChain(t, "chain")
+	genesis := getChainParams().GenesisBlock()
+	coinbase := newCoinBaseTx(sender.Address)
+	bc.AddBlock(genesis)
+	bc.AddBlock(newBlock([]Transaction{*coinbase}, genesis.Header.Hash, 2))
+	spent := &Transaction{
+		TxIns:  []TxInput{{TxID: coinbase.ID, TxOutIdx: 0, PubKey: sender.PublicKey}},
+		TxOuts: []TxOutput{*newTxOut(SUBSIDY, newWallet().Address)},
+	}
+	spent.ID = spent.HashTx()
+	if err := spent.SignWith([]*Wallet{sender}); err != nil {
+		t.Fatalf("SignWith failed: %v", err)
+	}
+	bc.AddBlock(newBlock([]Transaction{*spent}, bc.GetLatestHash(), 3))
+
+	victim := &Transaction{
+		ID:     []byte("pending transaction of another wallet"),
+		TxIns:  []TxInput{{TxID: []byte("prev"), TxOutIdx: 0}},
+		TxOuts: []TxOutput{*newTxOut(1, newWallet().Address)},
+	}
+	getMempool().Add(victim)
+
+	// An unsigned transaction carrying the victim's ID, spending the confirmed output.
+	forged := &Transaction{
+		ID:     victim.ID,
+		TxIns:  []TxInput{{TxID: coinbase.ID, TxOutIdx: 0, PubKey: sender.PublicKey}},
+		TxOuts: []TxOutput{*newTxOut(SUBSIDY, newWallet().Address)},
+	}
+	if acceptTx(bc, forged, "10.0.0.7") {
+		t.Fatalf("Forged transaction should be refused")
+	}
+	if status := bc.GetTxStatus(victim.ID); status.State != TX_STATE_MEMPOOL {
+		t.Errorf("Pending transaction should stay in the mempool, got %s", status.Stringify())
+	}
+	if pm.Report().Scores["10.0.0.7"] != MISBEHAVIOUR_INVALID_TX {
+		t.Errorf("Sender of the forged transaction should misbehave")
+	}
+}
+
+func TestAcceptTxSpendingMissingOutput(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{Network: Network{LocalNode: Node{Address: "localhost:3331"}}})
+	oldPM := getPeerManager()
+	t.Cleanup(func() { setPeerManager(oldPM) })
+	pm, _ := newPeerManager(nil)
+	setPeerManager(pm)
+
+	sender := newWallet()
+	bc := newTestChain(t, "chain")
+	genesis := getChainParams().GenesisBlock()
+	coinbase := newCoinBaseTx(sender.Address)
+	bc.AddBlock(genesis)
+	bc.AddBlock(newBlock([]Transaction{*coinbase}, genesis.Header.Hash, 2))
+
+	oldMempool := mempool
+	t.Cleanup(func() { mempool = oldMempool })
+	mempool = newMempool()
+
+	sign := func(tx *Transaction) *Transaction {
+		if err := tx.SignWith([]*Wallet{sender}); err != nil {
+			t.Fatalf("SignWith failed: %v", err)
+		}
+		return tx
+	}
+	spend := func(value int, txID []byte, txOutIdxs ...int) *Transaction {
+		tx := &Transaction{TxOuts: []TxOutput{*newTxOut(value, newWallet().Address)}}
+		for _, txOutIdx := range txOutIdxs {
+			tx.TxIns = append(tx.TxIns, TxInput{TxID: txID, TxOutIdx: txOutIdx, PubKey: sender.PublicKey})
+		}
+		tx.ID = tx.HashTx()
+		return sign(tx)
+	}
+	forged := spend(SUBSIDY, coinbase.ID, 0)
+	forged.ID = []byte("id of another transaction")
+	sign(forged)
+
+	score := 0
+	for name, tx := range map[string]*Transaction{
+		"unknown transaction":   spend(1, []byte("no such transaction"), 0),
+		"out of range output":   spend(1, coinbase.ID, len(coinbase.TxOuts)),
+		"negative output index": spend(1, coinbase.ID, -1),
+		"output spent twice":    spend(2*SUBSIDY, coinbase.ID, 0, 0),
+		"forged ID":             forged,
+	} {
+		if acceptTx(bc, tx, "10.0.0.7") {
+			t.Errorf("%s: transaction should be refused", name)
+		}
+		score += MISBEHAVIOUR_INVALID_TX
+		if got := pm.Report().Scores["10.0.0.7"]; got != score {
+			t.Errorf("%s: expected a misbehaviour score of %d, got %d", name, score, got)
+		}
+	}
+	if !acceptTx(bc, spend(SUBSIDY, coinbase.ID, 0), "10.0.0.7") {
+		t.Errorf("Transaction spending the output once should be accepted")
+	}
+
+	prevTxs := map[string]Transaction{hex.EncodeToString(coinbase.ID): *coinbase}
+	if spend(1, coinbase.ID, len(coinbase.TxOuts)).VerifyValues(prevTxs) || spend(1, []byte("no such transaction"), 0).VerifyValues(prevTxs) {
+		t.Errorf("Inputs spending a missing output should not be valid")
+	}
+}
@@ -4,59 +4,67 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
-	"strconv"
 )
 
 const (
-	CFwHashList = "FW_HASH_LIST" // Request to forward the source hash list to other nodes.
 	CReqDepth   = "REQ_DEPTH"    // Request to fetch the depth of the current node.
 	CReqBlock   = "REQ_BLOCK"    // Request to fetch the given block contents.
 	CReqHeader  = "REQ_HEADER"   // Request to fetch the given block's header to validate against the hash list.
-	CReqAddr    = "REQ_ADDR"     // Request to get node's address.
+	CReqAddr    = "REQ_ADDR"     // Request to get the addresses of the nodes known by the node.
 	CReqPrf     = "REQ_PRF"      // Request to get block's proof.
 	CPrintChain = "PRINT_CHAIN"  // Request to print the blockchain from the given node.
 	CAddBlock   = "ADD_BLOCK"    // Request to add a new block to the given chain.
 	CAddTx      = "ADD_TX"       // Request to add a new transaction to the provided block.
-
-	CResDepth  = "RES_DEPTH"  // Response to the requested fetch depth.
-	CResBlock  = "RES_BLOCK"  // Response to the requested fetch block contents.
-	CResTx     = "RES_Tx"     // Response to the requested adding new transaction to the provided block.
-	CResAddr   = "RES_ADDR"   // Response to the requested fetch node's address.
-	CResPrf    = "RES_PRF"    // Response to the validate block's proof request.
-	CResHeader = "RES_HEADER" // Response to the requested fetch header validation code with block's data.
+	CReqTxState = "REQ_TX_STATE" // Request to fetch the state of the given transaction.
+	CVersion    = "VERSION"      // Handshake message describing the sending node.
+	CVerAck     = "VERACK"       // Handshake message accepting the peer's version.
+	CReject     = "REJECT"       // Refusal of the peer, with the reason.
+	CInv        = "INV"          // Announcement of new blocks and transactions.
+	CGetData    = "GET_DATA"     // Request of the announced items the node lacks.
+	CReqHeaders = "REQ_HEADERS"  // Request to fetch the headers following a block locator.
+	CReqBlocks  = "REQ_BLOCKS"   // Request to fetch the blocks of the given hashes.
+	CReqTip     = "REQ_TIP"      // Request to fetch the height and work of the node's chain.
+	CPing       = "PING"         // Keepalive request of an idle connection.
+	CReqPeers   = "REQ_PEERS"    // Admin request to fetch the peer manager's state.
+	CBanPeer    = "BAN_PEER"     // Admin request to ban a peer.
+	CUnbanPeer  = "UNBAN_PEER"   // Admin request to lift the ban of a peer.
+	CReloadCfg  = "RELOAD_CFG"   // Admin request to reload the config file.
+
+	CResDepth   = "RES_DEPTH"    // Response to the requested fetch depth.
+	CResBlock   = "RES_BLOCK"    // Response to the requested fetch block contents.
+	CResTx      = "RES_Tx"       // Response to the requested adding new transaction to the provided block.
+	CResAddr    = "RES_ADDR"     // Response to the requested fetch known addresses.
+	CResPrf     = "RES_PRF"      // Response to the validate block's proof request.
+	CResHeader  = "RES_HEADER"   // Response to the requested fetch header validation code with block's data.
+	CResTxState = "RES_TX_STATE" // Response to the requested fetch transaction's state.
+	CResData    = "RES_DATA"     // Response to the requested announced items.
+	CResHeaders = "RES_HEADERS"  // Response to the requested fetch headers.
+	CResBlocks  = "RES_BLOCKS"   // Response to the requested fetch blocks.
+	CResTip     = "RES_TIP"      // Response to the requested fetch chain tip.
+	CPong       = "PONG"         // Response to the keepalive request.
+	CResPeers   = "RES_PEERS"    // Response to the admin requests with the peer manager's state.
+	CResReload  = "RES_RELOAD"   // Response to the config reload with the changes applied.
 )
 
-// Using when commands stored as enums type.
-type MsgCmd int
-
 // `Message` is the method that's describe how data exchange between each node.
 type Message struct {
-	Cmd    string `json:"cmd"`      // Request command.
-	Data   []byte `json:"data"`     // Contents of message.
-	Source Node   `json:"src_node"` // Contents from source node.
+	ID     uint32 `json:"id,omitempty"` // ID of the request, echoed by its response (0 = no response).
+	Magic  uint32 `json:"magic"`        // Magic value of the sender's network.
+	Cmd    string `json:"cmd"`          // Request command.
+	Data   []byte `json:"data"`         // Contents of message.
+	Source Node   `json:"src_node"`     // Contents from source node.
+
+	Timestamp int64  `json:"ts,omitempty"`    // Unix time the message was sent at.
+	Nonce     uint64 `json:"nonce,omitempty"` // Random value telling the replays apart.
+	Signature []byte `json:"sig,omitempty"`   // Signature of the source's node key (see `msgDigest`).
 }
 
 // Utility functions start from here.
 
-// Stringify invoked when message stored as enum type (unused yet).
-func (pos MsgCmd) Stringify() string {
-	codeAsStr := [...]string{
-		"FW_HASH_LIST",
-		"REQ_DEPTH",
-		"REQ_BLOCK",
-		"REQ_HEADER",
-		"PRINT_CHAIN",
-		"ADD_BLOCK",
-		"RES_DEPTH",
-		"RES_BLOCK",
-		"RES_HEADER",
-	}[pos]
-	return codeAsStr
-}
-
 // createMsg is the common method for creating a new message with a given code and data.
 func createMsg(cmd string, data []byte) *Message {
 	return &Message{
+		Magic:  getChainParams().Magic,
 		Cmd:    cmd,
 		Data:   data,
 		Source: getLocalNode(),
@@ -65,14 +73,13 @@ func createMsg(cmd string, data []byte) *Message {
 
 // Request Messages:
 
-// createMsgFwHash used to forwards list of hashes from local node to other nodes.
-func createMsgFwHash(hashes [][]byte) *Message {
-	data, err := json.Marshal(hashes)
+// createMsgInv returns a new message announcing the given items.
+func createMsgInv(items []InvItem) *Message {
+	data, err := json.Marshal(items)
 	if err != nil {
 		Error.Panic("Marshal Failed!\n")
-		os.Exit(1)
 	}
-	return createMsg(CFwHashList, data)
+	return createMsg(CInv, data)
 }
 
 // createMsgReqDepth returns a new request message to fetch
@@ -92,9 +99,37 @@ func createMsgReqHeader(header Header) *Message {
 	return createMsg(CReqHeader, header.Serialize())
 }
 
-// NOTE: unused yet!
-// createMsgReqAddr returns a new request message to fetch a node's address.
-func CreateMsgReqAddr() *Message {
+// createMsgReqHeaders returns a new request message to fetch the headers
+// following the latest block of the locator known by the node.
+func createMsgReqHeaders(locator [][]byte) *Message {
+	data, err := json.Marshal(locator)
+	if err != nil {
+		Error.Panic("Marshal Failed!\n")
+	}
+	return createMsg(CReqHeaders, data)
+}
+
+// createMsgReqBlocks returns a new request message to fetch the blocks of the given hashes.
+func createMsgReqBlocks(hashes [][]byte) *Message {
+	data, err := json.Marshal(hashes)
+	if err != nil {
+		Error.Panic("Marshal Failed!\n")
+	}
+	return createMsg(CReqBlocks, data)
+}
+
+// createMsgReqTip returns a new request message to fetch the tip of the node's chain.
+func createMsgReqTip() *Message {
+	return createMsg(CReqTip, []byte{})
+}
+
+// createMsgPing returns a new keepalive request message.
+func createMsgPing() *Message {
+	return createMsg(CPing, []byte{})
+}
+
+// createMsgReqAddr returns a new request message to fetch the addresses known by a node.
+func createMsgReqAddr() *Message {
 	return createMsg(CReqAddr, []byte{})
 }
 
@@ -103,41 +138,71 @@ func createMsgReqPrf(prf []byte) *Message {
 	return createMsg(CReqPrf, prf)
 }
 
+// createMsgVersion returns the handshake message describing the local node.
+func createMsgVersion(version *VersionPayload) *Message {
+	data, err := json.Marshal(version)
+	if err != nil {
+		Error.Panic("Marshal Failed!\n")
+	}
+	return createMsg(CVersion, data)
+}
+
+// createMsgVerAck returns the handshake message accepting the peer.
+func createMsgVerAck() *Message {
+	return createMsg(CVerAck, []byte{})
+}
+
+// createMsgReject returns a message refusing the peer for the given reason.
+func createMsgReject(reason string) *Message {
+	return createMsg(CReject, []byte(reason))
+}
+
 // createMsgReqAddTx creates a new message to request adding a transaction to a new block.
 func createMsgReqAddTx(tx *Transaction) *Message {
 	return createMsg(CAddTx, tx.Serialize())
 }
 
-// Response Messages:
+// createMsgReqTxState returns a new request message to fetch the state of a transaction.
+func createMsgReqTxState(txID []byte) *Message {
+	return createMsg(CReqTxState, txID)
+}
 
-// createMsgResDepth returns a message to response the fetch depth request.
-func createMsgResDepth(depth int) *Message {
-	return createMsg(CResDepth, Itobytes(depth))
+// createMsgReqPeers returns a new admin request message to fetch the peer manager's state.
+func createMsgReqPeers() *Message {
+	return createMsg(CReqPeers, []byte{})
 }
 
-// createMsgResBlock returns a message to response the fetch block request.
-func createMsgResBlock(block *Block) *Message {
-	return createMsg(CResBlock, block.Serialize())
+// createMsgReloadCfg returns a new admin request message to reload the config file.
+func createMsgReloadCfg() *Message {
+	return createMsg(CReloadCfg, []byte{})
 }
 
-// createMsgResAddTx returns a message to response the adding transaction request.
-func createMsgResAddTx(isSuccess bool) *Message {
-	return createMsg(CResTx, []byte(strconv.FormatBool(isSuccess)))
+// createMsgBanPeer returns a new admin request message to ban a peer.
+func createMsgBanPeer(req *BanRequest) *Message {
+	data, err := json.Marshal(req)
+	if err != nil {
+		Error.Panic("Marshal Failed!\n")
+	}
+	return createMsg(CBanPeer, data)
 }
 
-// createMsgResHeader returns a message containing the result of the checking validation header request.
-func createMsgResHeader(isValid bool) *Message {
-	return createMsg(CResHeader, []byte(strconv.FormatBool(isValid)))
+// createMsgUnbanPeer returns a new admin request message to lift the ban of a peer.
+func createMsgUnbanPeer(key string) *Message {
+	return createMsg(CUnbanPeer, []byte(key))
 }
 
-// createMsgResAddr returns a message to response the fetch node's address request.
-func createMsgResAddr() *Message {
-	return createMsg(CResAddr, []byte{})
+// Response Messages (the responses of the registered commands are encoded by their payloads):
+
+// createMsgResData returns a message containing the requested announced items,
+// in the compact form if `compact`.
+func createMsgResData(invData *InvData, compact bool) *Message {
+	return createMsg(CResData, invDataPayload.Encode(invData, WireCodec{Binary: compact}))
 }
 
-// @@@
-func createMsgResPrf(isValid bool) *Message {
-	return createMsg(CResPrf, []byte(strconv.FormatBool(isValid)))
+// createMsgResBlocks returns a message to response the fetch blocks request,
+// with the blocks in the compact form if `compact`.
+func createMsgResBlocks(blocks []*Block, compact bool) *Message {
+	return createMsg(CResBlocks, blocksPayload.Encode(blocks, WireCodec{Binary: compact}))
 }
 
 // Utility functions start from here.
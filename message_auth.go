@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Message authentication: every message is signed with the node key of its sender when written,
+// over its ID, magic, command, source node, timestamp, nonce and data (see `msgDigest`).
+// Receivers check the signature against the node ID of the source, which must be the identity
+// authenticated by the connection and the one pinned in the config for the source's address.
+// Messages older or newer than MSG_MAX_CLOCK_SKEW, and the ones received before, are refused.
+// Unsigned messages are only accepted by the nodes running in `dev_mode`.
+
+const MSG_MAX_CLOCK_SKEW = 2 * time.Minute
+
+var (
+	ErrUnsignedMsg = errors.New("unsigned message")
+	ErrBadMsgSig   = errors.New("invalid message signature")
+	ErrStaleMsg    = errors.New("stale message")
+	ErrReplayedMsg = errors.New("replayed message")
+)
+
+// ReplayGuard remembers the nonces of the messages received, as long as their timestamp is accepted.
+type ReplayGuard struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time // Expiry of the nonces, by node ID and nonce.
+	lastPrune time.Time
+}
+
+// Nonces of the messages received by the running node.
+var replayGuard = newReplayGuard()
+
+// Utility functions start from here.
+
+func newReplayGuard() *ReplayGuard {
+	return &ReplayGuard{seen: make(map[string]time.Time)}
+}
+
+// isDevMode returns true if the node accepts unsigned messages.
+func isDevMode() bool {
+	cfg := getNetworkCfg()
+	return cfg != nil && cfg.Network.DevMode
+}
+
+// msgDigest returns the hash of the message signed by its sender.
+func msgDigest(msg *Message) []byte {
+	unsigned := *msg
+	unsigned.Signature = nil
+	digest := sha256.Sum256(encodeBinaryMsg(&unsigned))
+	return digest[:]
+}
+
+// signMsg stamps the message and signs it with the given node identity.
+func signMsg(msg *Message, identity *NodeIdentity) {
+	msg.Source.ID = identity.ID()
+	msg.Timestamp = time.Now().Unix()
+	msg.Nonce = newNonce()
+	msg.Signature = ed25519.Sign(identity.key, msgDigest(msg))
+}
+
+// verifyMsg returns an error unless the message is signed by its source node, being the peer
+// of the given ID (if known), and neither stale nor replayed.
+func verifyMsg(msg *Message, peerID string) error {
+	if len(msg.Signature) == 0 {
+		if isDevMode() {
+			return nil
+		}
+		return ErrUnsignedMsg
+	}
+
+	source := msg.Source
+	if peerID != "" && source.ID != peerID {
+		return fmt.Errorf("%w: signed by %s, connected with %s", ErrBadMsgSig, source.ID, peerID)
+	}
+	if pinned := pinnedID(source.Address); pinned != "" && pinned != source.ID {
+		return fmt.Errorf("%w: %s is not the node pinned at %s", ErrBadMsgSig, source.ID, source.Address)
+	}
+	pubKey, err := hex.DecodeString(source.ID)
+	if err != nil || len(pubKey) != ed25519.PublicKeySize || !ed25519.Verify(pubKey, msgDigest(msg), msg.Signature) {
+		return fmt.Errorf("%w: %s from %s", ErrBadMsgSig, msg.Cmd, source.Address)
+	}
+
+	now := time.Now()
+	sent := time.Unix(msg.Timestamp, 0)
+	if sent.Before(now.Add(-MSG_MAX_CLOCK_SKEW)) || sent.After(now.Add(MSG_MAX_CLOCK_SKEW)) {
+		return fmt.Errorf("%w: %s sent at %v", ErrStaleMsg, msg.Cmd, sent.UTC())
+	}
+	if !replayGuard.Add(source.ID, msg.Nonce, sent, now) {
+		return fmt.Errorf("%w: %s from %s", ErrReplayedMsg, msg.Cmd, source.Address)
+	}
+	return nil
+}
+
+// isForgedMsg returns true if the message authentication failed because of its sender.
+func isForgedMsg(err error) bool {
+	return errors.Is(err, ErrBadMsgSig) || errors.Is(err, ErrReplayedMsg)
+}
+
+// ReplayGuard's methods:
+
+// Add remembers the nonce of the node's message sent at the given time,
+// returning false if it was received before.
+func (guard *ReplayGuard) Add(nodeID string, nonce uint64, sent, now time.Time) bool {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+
+	if now.Sub(guard.lastPrune) > time.Second {
+		for key, expiry := range guard.seen {
+			if expiry.Before(now) {
+				delete(guard.seen, key)
+			}
+		}
+		guard.lastPrune = now
+	}
+	key := fmt.Sprintf("%s/%d", nodeID, nonce)
+	if _, ok := guard.seen[key]; ok {
+		return false
+	}
+	// Once expired, the message is refused as stale anyway.
+	guard.seen[key] = sent.Add(MSG_MAX_CLOCK_SKEW + time.Second)
+	return true
+}
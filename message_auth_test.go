@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// writeUnsignedMsg writes the framed message without signing it.
+func writeUnsignedMsg(w io.Writer, msg *Message) error {
+	format, payload, err := encodeFrame(msg, WireCodec{})
+	if err != nil {
+		return err
+	}
+	header := WireHeader{
+		Magic:    getChainParams().Magic,
+		Version:  format,
+		Cmd:      msg.Cmd,
+		Length:   uint32(len(payload)),
+		Checksum: wireChecksum(payload),
+	}
+	_, err = w.Write(append(header.Serialize(), payload...))
+	return err
+}
+
+func TestVerifyMsg(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	identity := newNodeIdentity(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize)))
+	newSigned := func() *Message {
+		msg := &Message{Cmd: CInv, Data: []byte("items"), Source: Node{Address: "127.0.0.1:3341"}}
+		signMsg(msg, identity)
+		return msg
+	}
+
+	msg := newSigned()
+	if err := verifyMsg(msg, identity.ID()); err != nil {
+		t.Fatalf("Signed message refused: %v", err)
+	}
+	if err := verifyMsg(msg, identity.ID()); !errors.Is(err, ErrReplayedMsg) {
+		t.Errorf("Replayed message should be refused, got %v", err)
+	}
+
+	tampered := newSigned()
+	tampered.Data = []byte("other items")
+	if err := verifyMsg(tampered, ""); !errors.Is(err, ErrBadMsgSig) {
+		t.Errorf("Tampered message should be refused, got %v", err)
+	}
+	if err := verifyMsg(newSigned(), getNodeIdentity().ID()); !errors.Is(err, ErrBadMsgSig) {
+		t.Errorf("Message signed by another node than the peer should be refused, got %v", err)
+	}
+
+	stale := newSigned()
+	stale.Timestamp = time.Now().Add(-2 * MSG_MAX_CLOCK_SKEW).Unix()
+	stale.Signature = ed25519.Sign(identity.key, msgDigest(stale))
+	if err := verifyMsg(stale, ""); !errors.Is(err, ErrStaleMsg) {
+		t.Errorf("Stale message should be refused, got %v", err)
+	}
+
+	// Only the pinned node may claim its address.
+	nwConfig.Network.NeighborNodes = []Node{{Address: "127.0.0.1:3341", ID: getNodeIdentity().ID()}}
+	if err := verifyMsg(newSigned(), ""); !errors.Is(err, ErrBadMsgSig) {
+		t.Errorf("Message claiming the address of a pinned node should be refused, got %v", err)
+	}
+
+	unsigned := &Message{Cmd: CInv}
+	if err := verifyMsg(unsigned, ""); !errors.Is(err, ErrUnsignedMsg) {
+		t.Errorf("Unsigned message should be refused, got %v", err)
+	}
+	nwConfig.Network.DevMode = true
+	if err := verifyMsg(unsigned, ""); err != nil {
+		t.Errorf("Unsigned message should be accepted in dev mode: %v", err)
+	}
+}
+
+func TestUnsignedRequest(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+	node := serveTestChain(t, bc)
+
+	rawConn, err := net.Dial("tcp", node.Address)
+	if err != nil {
+		t.Fatalf("Cannot connect: %v", err)
+	}
+	defer rawConn.Close()
+	conn, _, err := secureOutbound(rawConn, Node{})
+	if err == nil {
+		_, err = handshakeOutbound(conn, testPeerVersion())
+	}
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	msg := createMsgReqDepth()
+	msg.ID = 1
+	if err = writeUnsignedMsg(conn, msg); err != nil {
+		t.Fatalf("Cannot send request: %v", err)
+	}
+	if _, err = expectMsg(conn, CResDepth); !errors.Is(err, ErrPeerRejected) {
+		t.Errorf("Unsigned request should be rejected, got %v", err)
+	}
+}
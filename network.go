@@ -1,12 +1,10 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"io"
 	"net"
-	"os"
 	"strconv"
 	"time"
 )
@@ -20,15 +18,44 @@ const (
 type Node struct {
 	// Address of the node itself.
 	Address string `json:"address"`
+	// Expected identity of the node (hex public key of its node key), checked when connecting to it.
+	ID string `json:"id,omitempty"`
 }
 
 // Define the connection between the local node was running
 // and the others node in the P2P network.
 type Network struct {
+	// Named network the node runs on (mainnet, testnet, regtest), default to mainnet.
+	Name string `json:"name,omitempty"`
+	// Expected hash of the genesis block, overriding the network's default one.
+	GenesisHash string `json:"genesis_hash,omitempty"`
 	// Node itself was running/mentioning in the network.
 	LocalNode Node `json:"local_node"`
 	// Other nodes were connected in the network.
 	NeighborNodes []Node `json:"neighbor_nodes"`
+	// Misbehaviour score banning a peer, default to 100.
+	BanThreshold int `json:"ban_threshold,omitempty"`
+	// How long a misbehaving peer is banned (eg: "24h"), default to 24 hours.
+	BanDuration string `json:"ban_duration,omitempty"`
+	// Number of outbound peers the node keeps connected to, default to 8.
+	TargetOutbound int `json:"target_outbound,omitempty"`
+	// Services announced to the peers (miner, storage, observer), default to miner and storage.
+	Services []string `json:"services,omitempty"`
+	// Identities of the nodes allowed to connect with the node, default to any node.
+	AllowedPeers []string `json:"allowed_peers,omitempty"`
+	// Inbound connections accepted at once, default to 64.
+	MaxInbound int `json:"max_inbound,omitempty"`
+	// Inbound connections accepted at once from a same IP (except the local host), default to 8.
+	MaxInboundPerIP int `json:"max_inbound_per_ip,omitempty"`
+	// Requests each peer may send per second, by class of command (sync, relay, query).
+	RateLimits map[string]RateLimit `json:"rate_limits,omitempty"`
+	// Workers handling the expensive requests (transactions, proofs), default to the number of CPUs.
+	Workers int `json:"workers,omitempty"`
+	// Messages of at least this many bytes are compressed for the peers accepting it, default to 1024
+	// (negative = never compress nor accept compressed messages).
+	CompressMinSize int `json:"compress_min_size,omitempty"`
+	// Accept the unsigned messages, for development only.
+	DevMode bool `json:"dev_mode,omitempty"`
 }
 
 // Utility functions start from here.
@@ -45,16 +72,20 @@ func getLocalNode() Node {
 	return cfg.Network.LocalNode
 }
 
-// syncNeighborBC pulls the neighbor blockchain from the other node in
-// the network and synchronizes the local node with it.
-func syncNeighborBC(bc *Blockchain) {
+// syncNeighborBC synchronizes the local node with the best chain of its peers:
+// the one with the most work, the others being tried in turn if it cannot be used, until `ctx` is canceled.
+func syncNeighborBC(ctx context.Context, bc *Blockchain) {
 	Info.Printf("Pulling blockchain from other node in Network...")
-	nw := getNetwork()
-
-	for i := 0; i < MAX_ASK_TIME; i++ {
-		for _, node := range nw.NeighborNodes {
-			Info.Printf("Try to synchronize with node: %v", node.Address)
-			if reqConnectBC(node, bc) {
+	for i := 0; i < MAX_ASK_TIME && ctx.Err() == nil; i++ {
+		for _, tip := range getChainTips(getPeerNodes()) {
+			if ctx.Err() != nil {
+				return
+			}
+			if !bc.IsEmpty() && tip.Work.Cmp(chainWork(bc.GetDepth())) <= 0 {
+				return
+			}
+			Info.Printf("Try to synchronize with node: %v (height %d)", tip.Node.Address, tip.Height)
+			if reqConnectBC(tip.Node, bc) {
 				Info.Printf("Sync blockchain succeeded. Current height: %d", bc.GetDepth())
 				return
 			}
@@ -62,216 +93,118 @@ func syncNeighborBC(bc *Blockchain) {
 	}
 }
 
-// reqConnectBC send the connection request to the given node.
-// Connection succeeded if the given node existed in the network,
-// its address is connectable, and the owner has fewer blocks identical to the other.
-func reqConnectBC(node Node, bc *Blockchain) bool {
-	// Checking if the local node is empty or not.
-	// If it's empty, depth is equal `0`.
-	localDepth := bc.GetDepth()
+// pushNeighborBC announces the local chain to the peers behind it.
+func pushNeighborBC(bc *Blockchain) {
+	pushTip(bc, getChainTips(getPeerNodes()))
+}
 
-	// Retrieve the neighbor node's depth/length.
-	neighborDepth, err := getDepthNeighbor(node)
+func checkBlockPrf(bc *Blockchain, posBlock int) {
+	msg := createMsgReqPrf(bc.GetBlockByDepth(posBlock).GenPrf())
+	msgRes, err := reqNeighbor(msg, Node{Address: "localhost:3331"})
 	if err != nil {
-		return false
+		return
 	}
-
-	Info.Printf("Depth comparison between [local - neighbor]: [%v - %v]", localDepth, neighborDepth)
-
-	detectIdentical(node, bc, localDepth, neighborDepth)
-	syncBlocks(node, bc, localDepth, neighborDepth)
-
-	return true
+	Info.Printf(string(msgRes.Data))
 }
 
-func detectIdentical(node Node, bc *Blockchain, local, neighbor int) {
-	minDepth := minVal(local, neighbor)
-
-	// Compare the identical minimum of blocks from both sides.
-	// NOTE: block position starts from index 1 not 0 like usual case.
-	for pos := 1; pos <= minDepth; pos++ {
-		if isIdentical := cmpBlockWithNeighbor(bc.GetBlockByDepth(pos), node); isIdentical {
-			Info.Printf("Block [%d] similarity detects completed. Progress: %d%%", pos, pos*100/minDepth)
-		} else {
-			Error.Fatalf("Block [%d] detected distinction. Exit prompt!", pos)
-			os.Exit(1)
-		}
+// getDepthNeighbor returns the depth of the given node
+// that was connected with local node.
+func getDepthNeighbor(node Node) (int, error) {
+	msgRes, err := reqNeighbor(createMsgReqDepth(), node)
+	if err != nil {
+		return 0, err
 	}
-}
-
-// syncBlocks pulls/synchronize blocks from any side if the opposite side
-// has more blocks than the other.
-func syncBlocks(node Node, bc *Blockchain, local, neighbor int) {
-	if local <= neighbor {
-		Info.Printf("Pull [%d] blocks from neighbor node", neighbor-local)
-		for pos := local + 1; pos <= neighbor; pos++ {
-			pullBlockNeighbor(bc, node, pos)
-			Info.Printf("Pulled block [%d] completed. Progress: %d%%", pos, pos*100/neighbor)
-		}
-	} else {
-		// TODO: implement the pulling process in the case the neighbor node has fewer blocks than the local.
-		// NOTE: 1. Need to detect which neighbor node has more blocks than the local.
-		//       2. Then figure out the number of blocks needed to pull from the neighbor.
-		panic("Not implemented yet!")
+	if err = checkMsgCmd(msgRes, CResDepth); err != nil {
+		return 0, err
 	}
+	return Bytestoi(msgRes.Data), nil
 }
 
-// cmpBlockWithNeighbor returns true if blocks in the same position have identical data.
-func cmpBlockWithNeighbor(block *Block, node Node) bool {
-	msg := createMsgReqHeader(block.Header)
-	data := msg.Serialize()
-
-	// Checking if the node address/port is reachable or available.
-	conn, err := net.Dial("tcp", node.Address)
+// sendMsg send new message to the the given node.
+func sendMsg(msg *Message, node Node) {
+	client, err := getPeerClient(node)
 	if err != nil {
-		Error.Printf("%s is not available!\n", node.Address)
-		return false
+		Error.Printf("%s is not available: %v", node.Address, err)
+		return
 	}
-	defer conn.Close()
-
-	// Copy the msg bytes data to the connected node.
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		Error.Panic(err)
+	if err = client.Send(msg); err != nil {
+		Error.Printf("Cannot send %s to %s: %v", msg.Cmd, node.Address, err)
 	}
+}
 
-	// Scan the buffer data and convert it to bytes message.
-	scanner := bufio.NewScanner(bufio.NewReader(conn))
-	scanner.Scan()
-	msgAsBytes := scanner.Bytes()
-
-	// Deserialize the bytes message to `*Message` response.
-	msgRes := deserializeMsg(msgAsBytes)
-
-	// Parse the message response validation header and checking if it's valid.
-	isValid, err := strconv.ParseBool(string(msgRes.Data))
+// reqNeighbor sends the given request message to a node and returns its response message.
+func reqNeighbor(msg *Message, node Node) (*Message, error) {
+	// Checking if the node address/port is reachable and speaks the same protocol.
+	client, err := getPeerClient(node)
 	if err != nil {
-		Error.Printf("Parse failed!")
-		return false
+		Error.Printf("%s is not available: %v", node.Address, err)
+		return nil, err
 	}
-	return isValid
+	return client.Request(msg)
 }
 
-// pullBlockNeighbor pulls some limited amount of blocks from the neighbor node
-// if their blockchain's total length is bigger than the local.
-func pullBlockNeighbor(bc *Blockchain, node Node, posBlock int) {
-	msg := createMsgReqBlock(posBlock)
-	data := msg.Serialize()
-
-	// Checking if the node address/port is reachable or available.
-	conn, err := net.Dial("tcp", node.Address)
+// sendTxNeighbor submits the given signed transaction to a node
+// and returns whether the node accepted it.
+func sendTxNeighbor(tx *Transaction, node Node) (bool, error) {
+	msgRes, err := reqNeighbor(createMsgReqAddTx(tx), node)
 	if err != nil {
-		Error.Printf("%s is not available!\n", node.Address)
-		return
+		return false, err
 	}
-	defer conn.Close()
-
-	// Copy the msg bytes data to the connected node.
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		Error.Panic(err)
+	if err = checkMsgCmd(msgRes, CResTx); err != nil {
+		return false, err
 	}
-
-	// Scan the buffer data and convert it to bytes message.
-	scanner := bufio.NewScanner(bufio.NewReader(conn))
-	scanner.Scan()
-	msgAsBytes := scanner.Bytes()
-
-	// Deserialize the bytes message to `*Message` response.
-	msgRes := deserializeMsg(msgAsBytes)
-	block := deserializeBlock(msgRes.Data)
-
-	// Adding new block to the current node's blockchain.
-	bc.AddBlock(block)
+	return strconv.ParseBool(string(msgRes.Data))
 }
 
-func checkBlockPrf(bc *Blockchain, posBlock int) {
-	msg := createMsgReqPrf(bc.GetBlockByDepth(posBlock).GenPrf())
-	data := msg.Serialize()
-
-	// Checking if the node address/port is reachable or available.
-	bcAddr := "localhost:3331"
-	conn, err := net.Dial("tcp", bcAddr)
+// getTxStateNeighbor returns the state of the given transaction as seen by a node.
+func getTxStateNeighbor(txID []byte, node Node) (*TxStatus, error) {
+	msgRes, err := reqNeighbor(createMsgReqTxState(txID), node)
 	if err != nil {
-		Error.Printf("%s is not available!\n", bcAddr)
-		return
+		return nil, err
 	}
-	defer conn.Close()
-
-	// Copy the msg bytes data to the connected node.
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		Error.Panic(err)
+	if err = checkMsgCmd(msgRes, CResTxState); err != nil {
+		return nil, err
 	}
 
-	// Scan the buffer data and convert it to bytes message.
-	scanner := bufio.NewScanner(bufio.NewReader(conn))
-	scanner.Scan()
-	msgAsBytes := scanner.Bytes()
-
-	// Deserialize the bytes message to `*Message` response.
-	msgRes := deserializeMsg(msgAsBytes)
-	Info.Printf(string(msgRes.Data))
-}
-
-// fwHashes forwards the new message's hash data to all neighbor nodes.
-func fwHashes(bc *Blockchain) {
-	nw := getNetwork()
-	for _, node := range nw.NeighborNodes {
-		msg := createMsgFwHash(bc.GetHashes())
-		sendMsg(msg, node)
+	status := new(TxStatus)
+	if err = json.Unmarshal(msgRes.Data, status); err != nil {
+		return nil, err
 	}
+	return status, nil
 }
 
-// getDepthNeighbor returns the depth of the given node
-// that was connected with local node.
-func getDepthNeighbor(node Node) (int, error) {
-	msg := createMsgReqDepth()
-
-	// Checking if the node address/port is reachable or available.
-	conn, err := net.Dial("tcp", node.Address)
+// reqPeersNeighbor sends a `peers` admin request to a node and returns the state of its peer manager.
+func reqPeersNeighbor(msg *Message, node Node) (*PeersReport, error) {
+	msgRes, err := reqNeighbor(msg, node)
 	if err != nil {
-		Error.Printf("%s is not available!\n", node.Address)
-		return 0, err
+		return nil, err
 	}
-	defer conn.Close()
-
-	// Copy the msg bytes data to the connected node.
-	_, err = io.Copy(conn, bytes.NewReader(msg.Serialize()))
-	if err != nil {
-		Error.Panic(err)
-		return 0, err
+	if msgRes.Cmd == CReject {
+		return nil, fmt.Errorf("%w: %s", ErrPeerRejected, msgRes.Data)
 	}
 
-	// Scan the buffer data and convert it to bytes message.
-	scanner := bufio.NewScanner(bufio.NewReader(conn))
-	ok := scanner.Scan()
-	if !ok {
-		return 0, nil
+	report := new(PeersReport)
+	if err = json.Unmarshal(msgRes.Data, report); err != nil {
+		return nil, err
 	}
-	msgAsBytes := scanner.Bytes()
-
-	// Deserialize the bytes message to `*Message` response.
-	msgRes := deserializeMsg(msgAsBytes)
-	neighborDepth := Bytestoi(msgRes.Data)
-
-	return neighborDepth, nil
+	return report, nil
 }
 
-// sendMsg send new message to the the given node.
-func sendMsg(msg *Message, node Node) {
-	conn, err := net.Dial("tcp", node.Address)
+// reqReloadNeighbor asks a node to reload its config file and returns the changes applied.
+func reqReloadNeighbor(node Node) (*ReloadReport, error) {
+	msgRes, err := reqNeighbor(createMsgReloadCfg(), node)
 	if err != nil {
-		Error.Printf("%s is not available!\n", node.Address)
-		return
+		return nil, err
+	}
+	if msgRes.Cmd == CReject {
+		return nil, fmt.Errorf("%w: %s", ErrPeerRejected, msgRes.Data)
 	}
-	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(msg.Serialize()))
-	if err != nil {
-		Error.Panic(err)
-		return
+	report := new(ReloadReport)
+	if err = json.Unmarshal(msgRes.Data, report); err != nil {
+		return nil, err
 	}
+	return report, nil
 }
 
 // checkPort returns true if the connection to the given port was established.
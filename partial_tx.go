@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Offline signing workflow (the private key never has to touch a network-facing host):
+//
+//	1. `build-tx`     : an online host with the chain DB selects the UTxOs of the sender
+//	                    and exports an unsigned `PartialTx` file.
+//	2. `sign-tx`      : an offline host holding only the wallet checks the file
+//	                    and adds the signatures.
+//	3. `broadcast-tx` : any host submits the signed transaction to a node as `ADD_TX`.
+
+const (
+	// Version of the partially-signed transaction file format.
+	PARTIAL_TX_VERSION = 1
+)
+
+// PrevTxOut describes one of the previous outputs being spent by a transaction,
+// so that the signer can check the amounts without the chain database.
+type PrevTxOut struct {
+	TxID     []byte   `json:"tx_id"`      // Transaction ID that created the output.
+	TxOutIdx int      `json:"tx_out_idx"` // Index of the output inside that transaction.
+	TxOut    TxOutput `json:"tx_out"`     // The output itself (value and owner's hash).
+}
+
+// PartialTx is the file format exchanged between the build, sign and broadcast steps.
+type PartialTx struct {
+	Version  int         `json:"version"`   // Version of the file format.
+	Tx       Transaction `json:"tx"`        // Transaction which is (or will be) signed.
+	PrevOuts []PrevTxOut `json:"prev_outs"` // Previous outputs consumed by `Tx.TxIns`.
+}
+
+// Utility functions start from here.
+
+// newPartialTx wraps an unsigned transaction together with the outputs it spends.
+func newPartialTx(tx *Transaction, prevOuts []PrevTxOut) *PartialTx {
+	return &PartialTx{
+		Version:  PARTIAL_TX_VERSION,
+		Tx:       *tx,
+		PrevOuts: prevOuts,
+	}
+}
+
+// importPartialTx reads a partially-signed transaction from the given file path.
+func importPartialTx(path string) (*PartialTx, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ptx := new(PartialTx)
+	if err = json.Unmarshal(contents, ptx); err != nil {
+		return nil, err
+	}
+	if ptx.Version != PARTIAL_TX_VERSION {
+		return nil, fmt.Errorf("unsupported partial transaction version %d", ptx.Version)
+	}
+	return ptx, nil
+}
+
+// PartialTx's methods:
+
+// Export writes the partially-signed transaction to the given file path.
+func (ptx *PartialTx) Export(path string) {
+	prettyMarshal, e := json.MarshalIndent(ptx, "", "  ")
+	if e != nil {
+		Error.Println(e.Error())
+		os.Exit(1)
+	}
+
+	e = ioutil.WriteFile(path, prettyMarshal, 0644)
+	if e != nil {
+		Error.Println(e.Error())
+		os.Exit(1)
+	}
+}
+
+// FindPrevOut returns the previous output referenced by the given input.
+func (ptx *PartialTx) FindPrevOut(txIn TxInput) (*PrevTxOut, bool) {
+	for idx, prevOut := range ptx.PrevOuts {
+		if bytes.Equal(prevOut.TxID, txIn.TxID) && prevOut.TxOutIdx == txIn.TxOutIdx {
+			return &ptx.PrevOuts[idx], true
+		}
+	}
+	return nil, false
+}
+
+// TotalIn returns the total amount of values carried by the previous outputs.
+func (ptx *PartialTx) TotalIn() int {
+	total := 0
+	for _, prevOut := range ptx.PrevOuts {
+		total += prevOut.TxOut.Value
+	}
+	return total
+}
+
+// TotalOut returns the total amount of values sent by the transaction.
+func (ptx *PartialTx) TotalOut() int {
+	total := 0
+	for _, txOut := range ptx.Tx.TxOuts {
+		total += txOut.Value
+	}
+	return total
+}
+
+// IsSigned returns true if every input of the transaction carries a signature.
+func (ptx *PartialTx) IsSigned() bool {
+	for _, txIn := range ptx.Tx.TxIns {
+		if len(txIn.Signature) == 0 {
+			return false
+		}
+	}
+	return len(ptx.Tx.TxIns) > 0
+}
+
+// Check validates the structure of the partial transaction: every input must
+// reference exactly one of the listed previous outputs, the ID must match the
+// contents, and the inputs must cover the outputs.
+func (ptx *PartialTx) Check() error {
+	if len(ptx.Tx.TxIns) == 0 {
+		return errors.New("transaction has no inputs")
+	}
+	if len(ptx.Tx.TxIns) != len(ptx.PrevOuts) {
+		return fmt.Errorf("transaction has %d inputs but %d previous outputs",
+			len(ptx.Tx.TxIns), len(ptx.PrevOuts))
+	}
+	for _, txIn := range ptx.Tx.TxIns {
+		if _, ok := ptx.FindPrevOut(txIn); !ok {
+			return fmt.Errorf("missing previous output %x:%d", txIn.TxID, txIn.TxOutIdx)
+		}
+	}
+
+	if !ptx.Tx.HasValidID() {
+		return errors.New("transaction ID does not match its contents")
+	}
+	if ptx.TotalIn() != ptx.TotalOut() {
+		return fmt.Errorf("inputs (%d) do not match outputs (%d)", ptx.TotalIn(), ptx.TotalOut())
+	}
+	return nil
+}
+
+// Sign adds the signatures of the given wallet to the transaction,
+// after checking that the wallet owns every previous output being spent.
+func (ptx *PartialTx) Sign(w *Wallet) error {
+	if err := ptx.Check(); err != nil {
+		return err
+	}
+
+	pubKeyHash := hashPubKey(w.PublicKey)
+	for _, txIn := range ptx.Tx.TxIns {
+		prevOut, _ := ptx.FindPrevOut(txIn)
+		if !prevOut.TxOut.IsLockedWith(pubKeyHash) {
+			return fmt.Errorf("output %x:%d is not owned by %s", txIn.TxID, txIn.TxOutIdx, w.Address)
+		}
+		if !bytes.Equal(txIn.PubKey, w.PublicKey) {
+			return fmt.Errorf("input %x:%d expects another public key", txIn.TxID, txIn.TxOutIdx)
+		}
+	}
+
+	if err := ptx.Tx.SignWith([]*Wallet{w}); err != nil {
+		return err
+	}
+	if !ptx.Tx.VerifySignature() {
+		return errors.New("produced signature does not verify")
+	}
+	return nil
+}
+
+// Stringify returns a summary of the partial transaction to be reviewed before signing.
+func (ptx *PartialTx) Stringify() string {
+	strTx := fmt.Sprintf("\n  ** Transaction %s **\n", hex.EncodeToString(ptx.Tx.ID))
+	for _, prevOut := range ptx.PrevOuts {
+		strTx += fmt.Sprintf("  - Spend  %x:%d (%d coins)\n", prevOut.TxID, prevOut.TxOutIdx, prevOut.TxOut.Value)
+	}
+	for _, txOut := range ptx.Tx.TxOuts {
+		strTx += fmt.Sprintf("  + Pay    %x (%d coins)\n", txOut.PubKeyHash, txOut.Value)
+	}
+	strTx += fmt.Sprintf("  Signed : %t\n", ptx.IsSigned())
+	return strTx
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// newTestPartialTx returns an unsigned transaction spending one fake output owned by `w`.
+func newTestPartialTx(w *Wallet, toAddr string) *PartialTx {
+	prevOut := PrevTxOut{TxID: []byte("prev-tx-id"), TxOutIdx: 0, TxOut: *newTxOut(10, w.Address)}
+	tx := &Transaction{
+		TxIns:  []TxInput{{TxID: prevOut.TxID, TxOutIdx: prevOut.TxOutIdx, PubKey: w.PublicKey}},
+		TxOuts: []TxOutput{*newTxOut(7, toAddr), *newTxOut(3, w.Address)},
+	}
+	tx.ID = tx.HashTx()
+	return newPartialTx(tx, []PrevTxOut{prevOut})
+}
+
+func TestPartialTxSignRoundTrip(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	sender, receiver := newWallet(), newWallet()
+
+	ptx := newTestPartialTx(sender, receiver.Address)
+	if ptx.IsSigned() {
+		t.Errorf("Unsigned transaction reported as signed!")
+	}
+	path := filepath.Join(t.TempDir(), "unsigned.json")
+	ptx.Export(path)
+
+	imported, err := importPartialTx(path)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if err = imported.Sign(sender); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if !imported.IsSigned() || !imported.Tx.VerifySignature() {
+		t.Errorf("Signed transaction does not verify!")
+	}
+	if err = imported.Check(); err != nil {
+		t.Errorf("Signed transaction fails the check: %v", err)
+	}
+}
+
+func TestPartialTxRejectsForeignWallet(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	sender, receiver := newWallet(), newWallet()
+
+	ptx := newTestPartialTx(sender, receiver.Address)
+	if err := ptx.Sign(receiver); err == nil {
+		t.Errorf("Signing with a wallet that does not own the inputs should fail!")
+	}
+
+	ptx.Tx.TxOuts[0].Value++
+	if err := ptx.Check(); err == nil {
+		t.Errorf("Tampered transaction should fail the check!")
+	}
+}
+
+func TestNewUnsignedTxNotEnoughFunds(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	sender, receiver := newWallet(), newWallet()
+	bc := newTestChain(t, "chain")
+	genesis := getChainParams().GenesisBlock()
+	bc.AddBlock(genesis)
+	bc.AddBlock(newBlock([]Transaction{*newCoinBaseTx(sender.Address)}, genesis.Header.Hash, 2))
+
+	tx, prevOuts, err := bc.NewUnsignedTx(sender.PublicKey, receiver.Address, SUBSIDY)
+	if err != nil || len(prevOuts) != 1 || len(tx.TxOuts) != 1 {
+		t.Fatalf("Expected a transaction spending the coinbase, got %+v, %v", tx, err)
+	}
+	if _, _, err = bc.NewUnsignedTx(sender.PublicKey, receiver.Address, SUBSIDY+1); !errors.Is(err, ErrNotEnoughFunds) {
+		t.Errorf("Expected %v, got %v", ErrNotEnoughFunds, err)
+	}
+	// The funds of a key given twice are only counted once.
+	pubKeys := [][]byte{sender.PublicKey, sender.PublicKey}
+	if _, _, err = bc.NewUnsignedTxFrom(pubKeys, receiver.Address, sender.Address, 2*SUBSIDY); !errors.Is(err, ErrNotEnoughFunds) {
+		t.Errorf("Expected %v for a duplicated key, got %v", ErrNotEnoughFunds, err)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Peer clients: the local node keeps one long-lived connection per peer it talks to, instead of
+// dialing for every request. Each request carries an ID, echoed by its response, so that many
+// requests can be in flight at once on the same connection. An idle connection is kept alive by
+// pings; a lost one is reopened in the background, waiting longer after each failed attempt.
+
+const (
+	REQUEST_TIMEOUT       = 2 * time.Minute  // Delay to receive the response of a request.
+	PING_INTERVAL         = 30 * time.Second // Delay between two keepalive pings.
+	PING_TIMEOUT          = 10 * time.Second // Delay to receive the answer of a ping.
+	CONN_IDLE_TIMEOUT     = 3 * PING_INTERVAL
+	CLIENT_IDLE_TIMEOUT   = 5 * time.Minute // Clients without requests for this long are closed.
+	MIN_RECONNECT_BACKOFF = time.Second
+	MAX_RECONNECT_BACKOFF = time.Minute
+)
+
+var ErrPeerUnavailable = errors.New("peer unavailable")
+
+// PeerClient is the connection of the local node to a peer, shared by all the requests to it.
+type PeerClient struct {
+	node     Node
+	mu       sync.Mutex
+	conn     net.Conn        // Nil while reconnecting.
+	version  *VersionPayload // Version of the peer, from the latest handshake.
+	peerID   string          // Identity of the peer, from the latest handshake.
+	lastErr  error           // Why the connection was lost, or the latest reconnection failed.
+	nextID   uint32
+	pending  map[uint32]chan *Message // Requests waiting for their response, by ID.
+	lastUsed time.Time
+	dialErr  error         // Failure of the first connection attempt.
+	ready    chan struct{} // Closed once the first connection attempt is over.
+	closed   bool
+	done     chan struct{}
+}
+
+// Clients of the running node, by peer address.
+var (
+	peerClientsMu sync.Mutex
+	peerClients   = make(map[string]*PeerClient)
+)
+
+// Utility functions start from here.
+
+// getPeerClient returns the client connected to the node, connecting to it first if needed.
+// Concurrent callers share the same connection attempt. The node ID, if any, must be the peer's one.
+func getPeerClient(node Node) (*PeerClient, error) {
+	peerClientsMu.Lock()
+	pc, ok := peerClients[node.Address]
+	if !ok {
+		pc = &PeerClient{
+			node:     node,
+			pending:  make(map[uint32]chan *Message),
+			lastUsed: time.Now(),
+			ready:    make(chan struct{}),
+			done:     make(chan struct{}),
+		}
+		peerClients[node.Address] = pc
+	}
+	peerClientsMu.Unlock()
+	if ok {
+		<-pc.ready
+		if pc.dialErr != nil {
+			return nil, pc.dialErr
+		}
+		if id := pc.PeerID(); node.ID != "" && id != node.ID {
+			return nil, fmt.Errorf("%w: %s at %s, expected %s", ErrUnexpectedPeerID, id, node.Address, node.ID)
+		}
+		return pc, nil
+	}
+
+	conn, version, err := dialPeer(node)
+	if err != nil {
+		pc.dialErr = err
+		peerClientsMu.Lock()
+		delete(peerClients, node.Address)
+		peerClientsMu.Unlock()
+		close(pc.ready)
+		return nil, err
+	}
+	pc.attach(conn, version)
+	close(pc.ready)
+	go pc.keepAlive()
+	return pc, nil
+}
+
+// closePeerClients closes the connections to all the peers.
+func closePeerClients() {
+	peerClientsMu.Lock()
+	var clients []*PeerClient
+	for _, pc := range peerClients {
+		clients = append(clients, pc)
+	}
+	peerClientsMu.Unlock()
+
+	for _, pc := range clients {
+		pc.Close()
+	}
+}
+
+// closeDisallowedPeerClients closes the connections to the peers no longer allowed to connect
+// (eg: removed from the allowed peers of the reloaded config), returning their addresses.
+func closeDisallowedPeerClients() []string {
+	peerClientsMu.Lock()
+	var clients []*PeerClient
+	for _, pc := range peerClients {
+		clients = append(clients, pc)
+	}
+	peerClientsMu.Unlock()
+
+	var addresses []string
+	for _, pc := range clients {
+		peerID := pc.PeerID()
+		if peerID == "" || checkPeerID(peerID, "") == nil {
+			continue
+		}
+		pc.Close()
+		addresses = append(addresses, pc.node.Address)
+	}
+	return addresses
+}
+
+// closePeerClient closes the connection to the peer of the given address, if any.
+func closePeerClient(address string) {
+	peerClientsMu.Lock()
+	pc, ok := peerClients[address]
+	peerClientsMu.Unlock()
+	if ok {
+		<-pc.ready
+		pc.Close()
+	}
+}
+
+// PeerClient's methods:
+
+// Request sends the request to the peer and returns its response.
+// The ID of the request is set in the given message.
+func (pc *PeerClient) Request(msg *Message) (*Message, error) {
+	pc.touch()
+	return pc.request(msg, REQUEST_TIMEOUT)
+}
+
+// Send sends a message the peer does not answer.
+func (pc *PeerClient) Send(msg *Message) error {
+	pc.touch()
+	conn, err := pc.current()
+	if err != nil {
+		return err
+	}
+	msg.ID = 0
+	conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	if err = writeMsg(conn, msg); err != nil {
+		pc.drop(conn, err)
+	}
+	return err
+}
+
+// Version returns the version the peer sent in the latest handshake.
+func (pc *PeerClient) Version() *VersionPayload {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.version
+}
+
+// PeerID returns the identity of the peer, from the latest handshake.
+func (pc *PeerClient) PeerID() string {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.peerID
+}
+
+// Close closes the connection for good and forgets the client.
+func (pc *PeerClient) Close() {
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		return
+	}
+	pc.closed = true
+	close(pc.done)
+	conn := pc.conn
+	pc.mu.Unlock()
+	if conn != nil {
+		pc.drop(conn, fmt.Errorf("connection to %s closed", pc.node.Address))
+	}
+
+	peerClientsMu.Lock()
+	if peerClients[pc.node.Address] == pc {
+		delete(peerClients, pc.node.Address)
+	}
+	peerClientsMu.Unlock()
+}
+
+func (pc *PeerClient) request(msg *Message, timeout time.Duration) (*Message, error) {
+	resCh := make(chan *Message, 1)
+	pc.mu.Lock()
+	conn := pc.conn
+	if conn == nil {
+		pc.mu.Unlock()
+		return nil, pc.unavailable()
+	}
+	pc.nextID++
+	if pc.nextID == 0 {
+		pc.nextID++ // ID 0 is kept for the messages without response.
+	}
+	msg.ID = pc.nextID
+	pc.pending[msg.ID] = resCh
+	pc.mu.Unlock()
+
+	defer func() {
+		pc.mu.Lock()
+		delete(pc.pending, msg.ID)
+		pc.mu.Unlock()
+	}()
+
+	conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	if err := writeMsg(conn, msg); err != nil {
+		pc.drop(conn, err)
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res, ok := <-resCh:
+		if !ok {
+			return nil, pc.unavailable()
+		}
+		return res, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%s did not answer %s within %v", pc.node.Address, msg.Cmd, timeout)
+	}
+}
+
+// attach starts serving the requests on a new connection to the peer.
+func (pc *PeerClient) attach(conn net.Conn, version *VersionPayload) {
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		conn.Close()
+		return
+	}
+	pc.conn, pc.version, pc.peerID, pc.lastErr = conn, version, connPeerID(conn), nil
+	pc.mu.Unlock()
+	go pc.readLoop(conn)
+}
+
+// readLoop delivers the responses read from the connection to their requests.
+func (pc *PeerClient) readLoop(conn net.Conn) {
+	for {
+		msg, err := readMsg(conn)
+		if err == nil {
+			err = verifyMsg(msg, connPeerID(conn))
+		}
+		if err != nil {
+			if isMalformedMsg(err) {
+				getPeerManager().Misbehave(peerKey(pc.node.Address), MISBEHAVIOUR_MALFORMED_MSG, err.Error())
+			} else if isForgedMsg(err) {
+				getPeerManager().Misbehave(peerKey(pc.node.Address), MISBEHAVIOUR_FORGED_MSG, err.Error())
+			}
+			pc.drop(conn, err)
+			return
+		}
+
+		pc.mu.Lock()
+		resCh, ok := pc.pending[msg.ID]
+		delete(pc.pending, msg.ID)
+		pc.mu.Unlock()
+		if ok {
+			resCh <- msg
+		} else {
+			Info.Printf("Dropped %s from %s answering no pending request", msg.Cmd, pc.node.Address)
+		}
+	}
+}
+
+// drop closes the lost connection, failing its pending requests, and reconnects in the background.
+func (pc *PeerClient) drop(conn net.Conn, reason error) {
+	pc.mu.Lock()
+	if pc.conn != conn {
+		pc.mu.Unlock()
+		return
+	}
+	pc.conn, pc.lastErr = nil, reason
+	for id, resCh := range pc.pending {
+		close(resCh)
+		delete(pc.pending, id)
+	}
+	closed := pc.closed
+	pc.mu.Unlock()
+
+	conn.Close()
+	if !closed {
+		Info.Printf("Connection to %s lost: %v", pc.node.Address, reason)
+		go pc.reconnect()
+	}
+}
+
+// reconnect reopens the connection, waiting twice longer after each failed attempt.
+func (pc *PeerClient) reconnect() {
+	backoff := MIN_RECONNECT_BACKOFF
+	for {
+		select {
+		case <-pc.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		conn, version, err := dialPeer(pc.node)
+		if err == nil {
+			Info.Printf("Reconnected to %s", pc.node.Address)
+			pc.attach(conn, version)
+			return
+		}
+		if errors.Is(err, ErrPeerBanned) {
+			pc.Close()
+			return
+		}
+		pc.mu.Lock()
+		pc.lastErr = err
+		pc.mu.Unlock()
+		backoff = minVal(2*backoff, MAX_RECONNECT_BACKOFF)
+	}
+}
+
+// keepAlive pings the peer of an idle connection, and closes the client once unused.
+func (pc *PeerClient) keepAlive() {
+	ticker := time.NewTicker(PING_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pc.done:
+			return
+		case <-ticker.C:
+		}
+
+		pc.mu.Lock()
+		conn, idle := pc.conn, time.Since(pc.lastUsed)
+		pc.mu.Unlock()
+		if idle > CLIENT_IDLE_TIMEOUT {
+			pc.Close()
+			return
+		}
+		if conn == nil {
+			continue
+		}
+		if _, err := pc.request(createMsgPing(), PING_TIMEOUT); err != nil {
+			pc.drop(conn, err)
+		}
+	}
+}
+
+// current returns the open connection to the peer.
+func (pc *PeerClient) current() (net.Conn, error) {
+	pc.mu.Lock()
+	conn := pc.conn
+	pc.mu.Unlock()
+	if conn == nil {
+		return nil, pc.unavailable()
+	}
+	return conn, nil
+}
+
+// unavailable returns the error of the requests sent while the peer is not connected.
+func (pc *PeerClient) unavailable() error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.closed {
+		return fmt.Errorf("%w: connection to %s closed", ErrPeerUnavailable, pc.node.Address)
+	}
+	return fmt.Errorf("%w: reconnecting to %s: %v", ErrPeerUnavailable, pc.node.Address, pc.lastErr)
+}
+
+func (pc *PeerClient) touch() {
+	pc.mu.Lock()
+	pc.lastUsed = time.Now()
+	pc.mu.Unlock()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPeerClientMultiplexing(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+
+	accepted := make(chan net.Conn, 4)
+	node := serveTestConns(t, accepted, func(req *reqConn, msg *Message) {
+		handleMsg(req, bc, msg, "")
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if depth, err := getDepthNeighbor(node); err != nil || depth != 1 {
+				t.Errorf("Unexpected depth %d: %v", depth, err)
+			}
+		}()
+	}
+	wg.Wait()
+	conn := <-accepted
+	if len(accepted) != 0 {
+		t.Errorf("Requests should share one connection, got %d more", len(accepted))
+	}
+
+	// The lost connection is reopened in the background.
+	conn.Close()
+	select {
+	case <-accepted:
+	case <-time.After(5 * MIN_RECONNECT_BACKOFF):
+		t.Fatalf("Client did not reconnect")
+	}
+	for start := time.Now(); time.Since(start) < HANDSHAKE_TIMEOUT; time.Sleep(10 * time.Millisecond) {
+		if depth, err := getDepthNeighbor(node); err == nil && depth == 1 {
+			return
+		}
+	}
+	t.Errorf("Requests should succeed after reconnecting")
+}
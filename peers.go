@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Peer manager: tracks the connections with the other nodes and the misbehaviour of the peers.
+// A peer sending malformed messages, invalid blocks or transactions, or bogus announcements
+// collects misbehaviour points and is banned for `ban_duration` once it reaches `ban_threshold`.
+// The bans are persisted in the `banned` bucket of the node's database.
+//
+// Peers are identified by their IP, except the loopback ones (eg: a local test network)
+// which are identified by their listening address.
+
+const (
+	BANNED_BUCKET         = "banned"
+	DEFAULT_BAN_THRESHOLD = 100
+	DEFAULT_BAN_DURATION  = 24 * time.Hour
+
+	MISBEHAVIOUR_MALFORMED_MSG  = 20  // Unreadable frame or payload.
+	MISBEHAVIOUR_UNKNOWN_CMD    = 10  // Command the node does not know.
+	MISBEHAVIOUR_INVALID_TX     = 10  // Transaction failing the verification.
+	MISBEHAVIOUR_BOGUS_ANNOUNCE = 20  // Announced items not delivered.
+	MISBEHAVIOUR_INVALID_BLOCK  = 100 // Block which does not fit the chain.
+	MISBEHAVIOUR_FORGED_MSG     = 50  // Message with an invalid signature, or replayed.
+)
+
+// States of a connection.
+const (
+	PEER_HANDSHAKING = "handshaking"
+	PEER_ACTIVE      = "active"
+)
+
+var (
+	ErrPeerBanned = errors.New("peer is banned")
+	ErrNotAdmin   = errors.New("admin commands are only accepted from the local host")
+)
+
+// PeerConn describes one open connection with a peer.
+type PeerConn struct {
+	ID        uint64 `json:"id"`
+	Key       string `json:"key"`                  // Identity of the peer (IP or loopback address).
+	Address   string `json:"address"`              // Listening address of the peer, if known.
+	Remote    string `json:"remote"`               // Remote end of the connection.
+	Inbound   bool   `json:"inbound"`              // True if the peer opened the connection.
+	State     string `json:"state"`                // PEER_* state.
+	UserAgent string `json:"user_agent,omitempty"` // Software of the peer, once handshaked.
+	Identity  string `json:"identity,omitempty"`   // Node ID authenticated by the transport.
+	Since     int64  `json:"since"`                // Unix time the connection was opened.
+}
+
+// Ban is a banned peer.
+type Ban struct {
+	Key    string `json:"key"`
+	Until  int64  `json:"until"` // Unix time the ban expires.
+	Reason string `json:"reason"`
+}
+
+// PeersReport is the state of the peer manager sent to the admin commands.
+type PeersReport struct {
+	Conns   []PeerConn     `json:"conns"`
+	Scores  map[string]int `json:"scores"`
+	Bans    []Ban          `json:"bans"`
+	Inbound InboundStats   `json:"inbound"`
+}
+
+// BanRequest is the payload of the BAN_PEER admin command.
+type BanRequest struct {
+	Key      string `json:"key"`
+	Duration int64  `json:"duration"` // Seconds, 0 = the configured ban duration.
+	Reason   string `json:"reason"`
+}
+
+// PeerManager holds the open connections, the misbehaviour scores and the bans.
+type PeerManager struct {
+	mu     sync.Mutex
+	db     *bolt.DB
+	nextID uint64
+	conns  map[uint64]*PeerConn
+	scores map[string]int
+	bans   map[string]*Ban
+}
+
+// trackedConn unregisters its peer connection when closed.
+type trackedConn struct {
+	net.Conn
+	pm   *PeerManager
+	peer *PeerConn
+	once sync.Once
+}
+
+// Peer manager of the running node, in memory until the node's database is opened.
+var peerManager, _ = newPeerManager(nil)
+
+// Utility functions start from here.
+
+func setPeerManager(pm *PeerManager) {
+	peerManager = pm
+}
+
+func getPeerManager() *PeerManager {
+	return peerManager
+}
+
+// newPeerManager loads the bans stored in the given database (nil = in memory only).
+func newPeerManager(db *bolt.DB) (*PeerManager, error) {
+	pm := &PeerManager{
+		db:     db,
+		conns:  make(map[uint64]*PeerConn),
+		scores: make(map[string]int),
+		bans:   make(map[string]*Ban),
+	}
+	if db == nil {
+		return pm, nil
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(BANNED_BUCKET))
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(key, value []byte) error {
+			ban := new(Ban)
+			if err := json.Unmarshal(value, ban); err != nil {
+				Warning.Printf("Skip malformed ban %s: %v", key, err)
+				return nil
+			}
+			pm.bans[ban.Key] = ban
+			return nil
+		})
+	})
+	return pm, err
+}
+
+// loadPeerManager loads the bans of the node's database into the peer manager.
+func loadPeerManager(bc *Blockchain) {
+	pm, err := newPeerManager(bc.DB)
+	if err != nil {
+		Error.Printf("Cannot load the banned peers: %v", err)
+		return
+	}
+	setPeerManager(pm)
+	Info.Printf("Peer manager: %d banned peer(s)", len(pm.Report().Bans))
+}
+
+// banThreshold returns the misbehaviour score banning a peer.
+func banThreshold() int {
+	if cfg := getNetworkCfg(); cfg != nil && cfg.Network.BanThreshold > 0 {
+		return cfg.Network.BanThreshold
+	}
+	return DEFAULT_BAN_THRESHOLD
+}
+
+// banDuration returns how long a misbehaving peer is banned.
+func banDuration() time.Duration {
+	if cfg := getNetworkCfg(); cfg != nil && cfg.Network.BanDuration != "" {
+		if duration, err := time.ParseDuration(cfg.Network.BanDuration); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return DEFAULT_BAN_DURATION
+}
+
+func isLoopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// peerKey returns the identity of the peer listening on the given address (or host).
+func peerKey(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || isLoopbackHost(host) {
+		return address
+	}
+	return host
+}
+
+// inboundPeerKey returns the identity of the peer connected to us,
+// `address` being its announced listening address (may be empty).
+func inboundPeerKey(conn net.Conn, address string) string {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if isLoopbackHost(host) && address != "" {
+		return address
+	}
+	return peerKey(conn.RemoteAddr().String())
+}
+
+// isAdminConn returns true if the connection comes from the local host.
+func isAdminConn(conn net.Conn) bool {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	return isLoopbackHost(host)
+}
+
+// isMalformedMsg returns true if the error is caused by an unreadable message.
+func isMalformedMsg(err error) bool {
+	return errors.Is(err, ErrBadChecksum) || errors.Is(err, ErrBadMsgHeader) || errors.Is(err, ErrMsgTooLarge)
+}
+
+// PeerManager's methods:
+
+// Open registers a new connection with the peer, unless it is banned.
+func (pm *PeerManager) Open(key, address, remote string, inbound bool) (*PeerConn, error) {
+	if ban, banned := pm.IsBanned(key); banned {
+		return nil, fmt.Errorf("%w: %s until %s (%s)", ErrPeerBanned, key,
+			time.Unix(ban.Until, 0).Format(time.RFC3339), ban.Reason)
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.nextID++
+	peer := &PeerConn{
+		ID:      pm.nextID,
+		Key:     key,
+		Address: address,
+		Remote:  remote,
+		Inbound: inbound,
+		State:   PEER_HANDSHAKING,
+		Since:   time.Now().Unix(),
+	}
+	pm.conns[peer.ID] = peer
+	return peer, nil
+}
+
+// Activate records the successful handshake of the connection with the peer of the given node ID.
+func (pm *PeerManager) Activate(peer *PeerConn, version *VersionPayload, identity string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	peer.State = PEER_ACTIVE
+	peer.UserAgent = version.UserAgent
+	peer.Identity = identity
+}
+
+// Close unregisters the connection.
+func (pm *PeerManager) Close(peer *PeerConn) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	delete(pm.conns, peer.ID)
+}
+
+// Misbehave adds misbehaviour points to the peer, banning it past the threshold.
+// Returns true if the peer got banned.
+func (pm *PeerManager) Misbehave(key string, points int, reason string) bool {
+	if key == peerKey(getLocalNode().Address) {
+		return false
+	}
+	pm.mu.Lock()
+	pm.scores[key] += points
+	score := pm.scores[key]
+	pm.mu.Unlock()
+
+	Warning.Printf("Peer %s misbehaved (+%d, score %d/%d): %s", key, points, score, banThreshold(), reason)
+	if score < banThreshold() {
+		return false
+	}
+	pm.Ban(key, banDuration(), reason)
+	return true
+}
+
+// Ban bans the peer for the given duration.
+func (pm *PeerManager) Ban(key string, duration time.Duration, reason string) {
+	ban := &Ban{Key: key, Until: time.Now().Add(duration).Unix(), Reason: reason}
+
+	pm.mu.Lock()
+	pm.bans[key] = ban
+	delete(pm.scores, key)
+	pm.saveBan(ban)
+	pm.mu.Unlock()
+
+	outboundPeers.RemoveKey(key)
+	Warning.Printf("Peer %s is banned until %s: %s", key, time.Unix(ban.Until, 0).Format(time.RFC3339), reason)
+}
+
+// Unban lifts the ban of the peer and returns true if it was banned.
+func (pm *PeerManager) Unban(key string) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	_, banned := pm.bans[key]
+	delete(pm.bans, key)
+	delete(pm.scores, key)
+	pm.deleteBan(key)
+	return banned
+}
+
+// IsBanned returns the ban of the peer, if any. Expired bans are lifted.
+func (pm *PeerManager) IsBanned(key string) (*Ban, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	ban, banned := pm.bans[key]
+	if banned && ban.Until <= time.Now().Unix() {
+		delete(pm.bans, key)
+		pm.deleteBan(key)
+		return nil, false
+	}
+	return ban, banned
+}
+
+// Report returns the open connections, the misbehaviour scores and the active bans.
+func (pm *PeerManager) Report() *PeersReport {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	report := &PeersReport{Conns: []PeerConn{}, Scores: make(map[string]int), Bans: []Ban{}}
+	for _, peer := range pm.conns {
+		report.Conns = append(report.Conns, *peer)
+	}
+	sort.Slice(report.Conns, func(i, j int) bool { return report.Conns[i].ID < report.Conns[j].ID })
+	for key, score := range pm.scores {
+		report.Scores[key] = score
+	}
+	now := time.Now().Unix()
+	for _, ban := range pm.bans {
+		if ban.Until > now {
+			report.Bans = append(report.Bans, *ban)
+		}
+	}
+	sort.Slice(report.Bans, func(i, j int) bool { return report.Bans[i].Key < report.Bans[j].Key })
+	return report
+}
+
+// saveBan persists the ban (the lock must be held).
+func (pm *PeerManager) saveBan(ban *Ban) {
+	if pm.db == nil {
+		return
+	}
+	err := pm.db.Update(func(tx *bolt.Tx) error {
+		value, err := json.Marshal(ban)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(BANNED_BUCKET)).Put([]byte(ban.Key), value)
+	})
+	if err != nil {
+		Warning.Printf("Cannot save the ban of %s: %v", ban.Key, err)
+	}
+}
+
+// deleteBan removes the ban from the database (the lock must be held).
+func (pm *PeerManager) deleteBan(key string) {
+	if pm.db == nil {
+		return
+	}
+	err := pm.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BANNED_BUCKET)).Delete([]byte(key))
+	})
+	if err != nil {
+		Warning.Printf("Cannot remove the ban of %s: %v", key, err)
+	}
+}
+
+// trackedConn's methods:
+
+func (tc *trackedConn) Close() error {
+	tc.once.Do(func() { tc.pm.Close(tc.peer) })
+	return tc.Conn.Close()
+}
+
+// PeersReport's methods:
+
+// Stringify returns the report in a human readable format.
+func (report *PeersReport) Stringify() string {
+	str := fmt.Sprintf("Connections: %d\n", len(report.Conns))
+	for _, peer := range report.Conns {
+		direction := "outbound"
+		if peer.Inbound {
+			direction = "inbound"
+		}
+		str += fmt.Sprintf("\t#%d %s %s (%s, %s) %s score %d since %s\n", peer.ID, direction, peer.Key,
+			peer.Remote, peer.State, peer.UserAgent, report.Scores[peer.Key],
+			time.Unix(peer.Since, 0).Format(time.RFC3339))
+		if peer.Identity != "" {
+			str += fmt.Sprintf("\t\tnode ID %s\n", peer.Identity)
+		}
+	}
+
+	var misbehaving []string
+	for key := range report.Scores {
+		misbehaving = append(misbehaving, key)
+	}
+	sort.Strings(misbehaving)
+	str += fmt.Sprintf("Misbehaving peers: %d\n", len(misbehaving))
+	for _, key := range misbehaving {
+		str += fmt.Sprintf("\t%s score %d\n", key, report.Scores[key])
+	}
+
+	stats := report.Inbound
+	str += fmt.Sprintf("Inbound connections: %d open, %d accepted, %d refused over the total limit, %d over the per-IP limit\n",
+		stats.Open, stats.Accepted, stats.RejectedTotal, stats.RejectedPerIP)
+	str += fmt.Sprintf("Requests refused over the rate limits: %d\n", stats.RateLimited)
+
+	str += fmt.Sprintf("Banned peers: %d\n", len(report.Bans))
+	for _, ban := range report.Bans {
+		str += fmt.Sprintf("\t%s until %s: %s\n", ban.Key, time.Unix(ban.Until, 0).Format(time.RFC3339), ban.Reason)
+	}
+	return str
+}
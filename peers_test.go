@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPeerKey(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.7:3331":   "10.0.0.7",
+		"10.0.0.7":        "10.0.0.7",
+		"localhost:3332":  "localhost:3332",
+		"127.0.0.1:3333":  "127.0.0.1:3333",
+		"[2001:db8::1]:1": "2001:db8::1",
+	}
+	for address, expect := range tests {
+		if key := peerKey(address); key != expect {
+			t.Errorf("peerKey(%s): expected %s, got %s", address, expect, key)
+		}
+	}
+}
+
+func TestPeerManagerBans(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{Network: Network{LocalNode: Node{Address: "localhost:3331"}, BanThreshold: 50}})
+	dbPath := filepath.Join(t.TempDir(), DB_FILE)
+	db, err := openDB(dbPath)
+	if err != nil {
+		t.Fatalf("openDB failed: %v", err)
+	}
+	pm, err := newPeerManager(db)
+	if err != nil {
+		t.Fatalf("newPeerManager failed: %v", err)
+	}
+
+	peer, err := pm.Open("10.0.0.7", "10.0.0.7:3331", "10.0.0.7:51234", true)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	pm.Activate(peer, &VersionPayload{UserAgent: NODE_USER_AGENT}, "")
+	if report := pm.Report(); len(report.Conns) != 1 || report.Conns[0].State != PEER_ACTIVE {
+		t.Errorf("Expected one active connection, got %v", report.Conns)
+	}
+
+	if pm.Misbehave("10.0.0.7", MISBEHAVIOUR_INVALID_TX, "invalid tx") {
+		t.Errorf("Peer should not be banned below the threshold")
+	}
+	if pm.Misbehave("localhost:3331", MISBEHAVIOUR_INVALID_BLOCK, "own request") {
+		t.Errorf("The local node should never be banned")
+	}
+	if !pm.Misbehave("10.0.0.7", MISBEHAVIOUR_INVALID_BLOCK, "invalid block") {
+		t.Errorf("Peer should be banned past the threshold")
+	}
+	if _, err = pm.Open("10.0.0.7", "", "10.0.0.7:51235", true); !errors.Is(err, ErrPeerBanned) {
+		t.Errorf("Banned peer should be refused, got %v", err)
+	}
+	pm.Close(peer)
+	pm.Ban("10.0.0.8", -time.Second, "expired")
+
+	// The bans survive a restart, the expired ones are lifted.
+	db.Close()
+	if db, err = openDB(dbPath); err != nil {
+		t.Fatalf("openDB failed: %v", err)
+	}
+	defer db.Close()
+	restored, _ := newPeerManager(db)
+	if _, banned := restored.IsBanned("10.0.0.7"); !banned {
+		t.Errorf("Ban should persist across restarts")
+	}
+	if _, banned := restored.IsBanned("10.0.0.8"); banned {
+		t.Errorf("Expired ban should be lifted")
+	}
+	if report := restored.Report(); len(report.Conns) != 0 || len(report.Bans) != 1 {
+		t.Errorf("Unexpected report after restart: %+v", report)
+	}
+	if !restored.Unban("10.0.0.7") || restored.Unban("10.0.0.7") {
+		t.Errorf("Unban should lift the ban once")
+	}
+}
+
+func TestMalformedMsgMisbehaviour(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{Network: Network{LocalNode: Node{Address: "localhost:3331"}}})
+	pm, _ := newPeerManager(nil)
+	defer setPeerManager(getPeerManager())
+	setPeerManager(pm)
+
+	var frame bytes.Buffer
+	writeMsg(&frame, &Message{Magic: getChainParams().Magic, Cmd: CVersion, Data: []byte("{}")})
+	corrupted := frame.Bytes()
+	corrupted[len(corrupted)-2] ^= 0x01
+
+	for i := 0; i < DEFAULT_BAN_THRESHOLD/MISBEHAVIOUR_MALFORMED_MSG; i++ {
+		server, client := net.Pipe()
+		go func() {
+			if secured, _, err := secureOutbound(client, Node{}); err == nil {
+				secured.Write(corrupted)
+			}
+			client.Close()
+		}()
+		handleReq(context.Background(), server, nil)
+	}
+	// net.Pipe connections have no IP, they all share the same identity.
+	if _, banned := pm.IsBanned("pipe"); !banned {
+		t.Errorf("Peer sending corrupted messages should be banned, report: %+v", pm.Report())
+	}
+}
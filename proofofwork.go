@@ -103,3 +103,25 @@ func (pow *ProofOfWork) Validate() bool {
 	// Returns true if the `hashInt` value is less than the `target` number.
 	return hashInt.Cmp(pow.Target) == -1
 }
+
+// ValidateHash checks the block's hash is the proof of work of its contents.
+func (pow *ProofOfWork) ValidateHash() bool {
+	hash := sha256.Sum256(pow.PrepareData(pow.Block.Header.Nonce))
+	return bytes.Equal(hash[:], pow.Block.Header.Hash) && checkHeaderPoW(&pow.Block.Header)
+}
+
+// checkHeaderPoW checks the header's hash satisfies the target constraint,
+// before its block's contents are downloaded.
+func checkHeaderPoW(header *Header) bool {
+	var hashInt big.Int
+	hashInt.SetBytes(header.Hash)
+	return len(header.Hash) == sha256.Size && hashInt.Cmp(newProofOfWork(nil).Target) == -1
+}
+
+// chainWork returns the expected number of hashes computed to mine a chain of the given depth:
+// `2^256 / (target + 1)` per block, every block being mined at the same `DIFFICULTY`.
+func chainWork(depth int) *big.Int {
+	blockWork := new(big.Int).Lsh(big.NewInt(1), 256)
+	blockWork.Div(blockWork, new(big.Int).Add(newProofOfWork(nil).Target, big.NewInt(1)))
+	return blockWork.Mul(blockWork, big.NewInt(int64(depth)))
+}
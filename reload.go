@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Config reload: on SIGHUP or the RELOAD_CFG admin command, the running node reads its config
+// file again. The new config is validated first, then the settings of the `network` section
+// are applied, except the ones only read when the node starts, which are kept until it restarts.
+// The neighbor nodes added are connected to, the removed ones are disconnected and forgotten.
+// The peers no longer allowed are disconnected.
+
+// Settings of the `network` section applied on the next start only.
+var restartOnlySettings = map[string]bool{
+	"name":         true,
+	"genesis_hash": true,
+	"local_node":   true,
+	"workers":      true,
+}
+
+// ReloadReport describes the changes of a config reload.
+type ReloadReport struct {
+	Applied []string `json:"applied"` // Settings applied.
+	Restart []string `json:"restart"` // Settings changed, applied on the next start only.
+	Added   []string `json:"added"`   // Addresses of the neighbor nodes added.
+	Removed []string `json:"removed"` // Addresses of the neighbor nodes removed.
+}
+
+// Serializes the reloads.
+var reloadMu sync.Mutex
+
+func init() {
+	registerCmd(Command{Name: CReloadCfg, Response: CResReload, Admin: true},
+		noPayload, jsonPayload[*ReloadReport](), handleAdminReload)
+}
+
+// Utility functions start from here.
+
+// reloadNwCfg reads the config file of the running node again and applies its changes.
+func reloadNwCfg() (*ReloadReport, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	cfgPath := getNwCfgPath()
+	if cfgPath == "" {
+		return nil, errors.New("no config file loaded")
+	}
+	cfg, err := parseCfgData(cfgPath)
+	if err == nil {
+		err = checkNwCfg(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config not reloaded: %w", err)
+	}
+
+	old := getNetworkCfg()
+	report := diffNwCfg(old, cfg)
+	setNetworkCfg(cfg)
+	if contains(report.Applied, "rate_limits") {
+		getInboundLimiter().ResetRates()
+	}
+	applyNeighbors(old.Network.NeighborNodes, cfg.Network.NeighborNodes)
+	if contains(report.Applied, "allowed_peers") {
+		applyAllowedPeers()
+	}
+	return report, nil
+}
+
+// diffNwCfg returns the changes from the `old` config to the new one, in which
+// the settings applied on the next start only are set back to their current value.
+func diffNwCfg(old, cfg *Config) *ReloadReport {
+	report := &ReloadReport{Applied: []string{}, Restart: []string{}, Added: []string{}, Removed: []string{}}
+	oldNw, newNw := reflect.ValueOf(&old.Network).Elem(), reflect.ValueOf(&cfg.Network).Elem()
+	for i := 0; i < oldNw.NumField(); i++ {
+		if sameSetting(oldNw.Field(i), newNw.Field(i)) {
+			continue
+		}
+		name := strings.Split(oldNw.Type().Field(i).Tag.Get("json"), ",")[0]
+		if restartOnlySettings[name] {
+			report.Restart = append(report.Restart, name)
+			newNw.Field(i).Set(oldNw.Field(i))
+		} else {
+			report.Applied = append(report.Applied, name)
+		}
+	}
+	if !reflect.DeepEqual(old.WJson, cfg.WJson) {
+		report.Restart = append(report.Restart, "wallet")
+		cfg.WJson = old.WJson
+	}
+
+	for _, node := range cfg.Network.NeighborNodes {
+		if !contains(old.Network.NeighborNodes, node) {
+			report.Added = append(report.Added, node.Address)
+		}
+	}
+	for _, node := range old.Network.NeighborNodes {
+		if !contains(cfg.Network.NeighborNodes, node) {
+			report.Removed = append(report.Removed, node.Address)
+		}
+	}
+	return report
+}
+
+// sameSetting returns true if both values of a setting are equal, an empty list being equal to none.
+func sameSetting(old, value reflect.Value) bool {
+	switch old.Kind() {
+	case reflect.Slice, reflect.Map:
+		if old.Len() == 0 && value.Len() == 0 {
+			return true
+		}
+	}
+	return reflect.DeepEqual(old.Interface(), value.Interface())
+}
+
+// applyNeighbors disconnects from the neighbor nodes removed from the config, forgetting them,
+// and connects to the added ones. A neighbor whose pinned identity changed is reconnected.
+func applyNeighbors(old, neighbors []Node) {
+	ab := getAddrBook()
+	for _, node := range old {
+		if contains(neighbors, node) {
+			continue
+		}
+		closePeerClient(node.Address)
+		outboundPeers.Remove(node.Address)
+		if ab != nil {
+			ab.Forget(node.Address)
+		}
+		Info.Printf("Neighbor %s removed", node.Address)
+	}
+	if ab == nil {
+		return
+	}
+	for _, node := range neighbors {
+		if contains(old, node) {
+			continue
+		}
+		ab.Add([]KnownAddr{{Address: node.Address}})
+		ab.Retry(node.Address)
+		go connectOutbound(ab, node.Address)
+	}
+}
+
+// applyAllowedPeers disconnects from the outbound peers no longer allowed. The inbound ones
+// are disconnected on their next request.
+func applyAllowedPeers() {
+	for _, address := range closeDisallowedPeerClients() {
+		outboundPeers.Remove(address)
+		Info.Printf("Peer %s is no longer allowed, disconnected", address)
+	}
+}
+
+// handleAdminReload handles the admin request to reload the config file,
+// answering with the changes applied.
+func handleAdminReload(req *Request, _ struct{}) (*ReloadReport, error) {
+	report, err := reloadNwCfg()
+	if err != nil {
+		Error.Print(err)
+		return nil, err
+	}
+	Info.Printf("Config reloaded:\n%s", report.Stringify())
+	return report, nil
+}
+
+// ReloadReport's methods:
+
+// Stringify returns the report in a human readable format.
+func (report *ReloadReport) Stringify() string {
+	str := fmt.Sprintf("Settings applied: %s\n", listOrNone(report.Applied))
+	str += fmt.Sprintf("Settings applied on the next start only: %s\n", listOrNone(report.Restart))
+	str += fmt.Sprintf("Neighbors added: %s\n", listOrNone(report.Added))
+	str += fmt.Sprintf("Neighbors removed: %s\n", listOrNone(report.Removed))
+	return str
+}
+
+func listOrNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
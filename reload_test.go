@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestCfg(t *testing.T, path string, cfg *Config) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Cannot marshal config: %v", err)
+	}
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Cannot write config: %v", err)
+	}
+}
+
+func TestReloadNwCfg(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	kept, removed, added := "127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"
+	old := &Config{Network: Network{
+		LocalNode:     Node{Address: "127.0.0.1:3341"},
+		NeighborNodes: []Node{{Address: kept}, {Address: removed}},
+	}}
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+	setTestConfig(t, old)
+	nwConfigPath = cfgPath
+	defer func() { nwConfigPath = "" }()
+	ab, _ := newAddrBook(nil)
+	ab.Add([]KnownAddr{{Address: kept}, {Address: removed}})
+	setAddrBook(ab)
+	defer setAddrBook(nil)
+	outboundPeers.Set(removed, &VersionPayload{})
+
+	// An invalid config is not applied.
+	writeTestCfg(t, cfgPath, &Config{Network: Network{BanDuration: "soon"}})
+	if _, err := reloadNwCfg(); err == nil || getNetworkCfg() != old {
+		t.Fatalf("Invalid config should not be applied: %v", err)
+	}
+
+	writeTestCfg(t, cfgPath, &Config{Network: Network{
+		LocalNode:      Node{Address: "127.0.0.1:4000"},
+		NeighborNodes:  []Node{{Address: kept}, {Address: added}},
+		TargetOutbound: 2,
+	}})
+	report, err := reloadNwCfg()
+	if err != nil {
+		t.Fatalf("Cannot reload config: %v", err)
+	}
+	expected := &ReloadReport{
+		Applied: []string{"neighbor_nodes", "target_outbound"},
+		Restart: []string{"local_node"},
+		Added:   []string{added},
+		Removed: []string{removed},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Expected report %+v, got %+v", expected, report)
+	}
+	nw := getNetwork()
+	if nw.TargetOutbound != 2 || nw.LocalNode.Address != old.Network.LocalNode.Address {
+		t.Errorf("Unexpected network after reload: %+v", nw)
+	}
+
+	if outboundPeers.Has(removed) {
+		t.Errorf("Removed neighbor %s should be disconnected", removed)
+	}
+	ab.mu.Lock()
+	_, hasRemoved := ab.addrs[removed]
+	_, hasAdded := ab.addrs[added]
+	ab.mu.Unlock()
+	if hasRemoved || !hasAdded {
+		t.Errorf("Address book should forget %s and learn %s", removed, added)
+	}
+}
+
+func TestReloadAllowedPeers(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+	setTestConfig(t, &Config{})
+	nwConfigPath = cfgPath
+	defer func() { nwConfigPath = "" }()
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+	node := serveTestChain(t, bc)
+	if _, err := getDepthNeighbor(node); err != nil {
+		t.Fatalf("Cannot connect to %s: %v", node.Address, err)
+	}
+	outboundPeers.Set(node.Address, &VersionPayload{})
+	defer outboundPeers.Remove(node.Address)
+
+	// The connected peer keeps the former identity of the local node, left out of the allowed peers.
+	oldIdentity := getNodeIdentity()
+	defer setNodeIdentity(oldIdentity)
+	setNodeIdentity(newNodeIdentity(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize))))
+	writeTestCfg(t, cfgPath, &Config{Network: Network{AllowedPeers: []string{getNodeIdentity().ID()}}})
+	report, err := reloadNwCfg()
+	if err != nil || !contains(report.Applied, "allowed_peers") {
+		t.Fatalf("Allowed peers should be reloaded: %+v, %v", report, err)
+	}
+
+	peerClientsMu.Lock()
+	_, connected := peerClients[node.Address]
+	peerClientsMu.Unlock()
+	if connected || outboundPeers.Has(node.Address) {
+		t.Errorf("Peer %s no longer allowed should be disconnected", node.Address)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"sync"
+)
+
+// The secp256k1 curve (y² = x³ + 7) used by Bitcoin, for the interoperability with its tools.
+// The generic `elliptic.CurveParams` assumes a = -3, hence this small implementation
+// in Jacobian coordinates; `crypto/ecdsa` accepts it through its custom curves path.
+// NOTE: the arithmetic is not constant-time, keep the P-256 keys for the long-lived wallets.
+
+var (
+	secp256k1Once  sync.Once
+	secp256k1Curve *secp256k1
+)
+
+type secp256k1 struct {
+	params *elliptic.CurveParams
+}
+
+// Utility functions start from here.
+
+// Secp256k1 returns the secp256k1 curve.
+func Secp256k1() elliptic.Curve {
+	secp256k1Once.Do(func() {
+		params := &elliptic.CurveParams{Name: "secp256k1", BitSize: 256}
+		params.P, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F", 16)
+		params.N, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+		params.B = big.NewInt(7)
+		params.Gx, _ = new(big.Int).SetString("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798", 16)
+		params.Gy, _ = new(big.Int).SetString("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8", 16)
+		secp256k1Curve = &secp256k1{params: params}
+	})
+	return secp256k1Curve
+}
+
+// secp256k1's methods:
+
+func (curve *secp256k1) Params() *elliptic.CurveParams {
+	return curve.params
+}
+
+// IsOnCurve reports whether the given (x, y) lies on the curve.
+func (curve *secp256k1) IsOnCurve(x, y *big.Int) bool {
+	p := curve.params.P
+	if x.Sign() < 0 || x.Cmp(p) >= 0 || y.Sign() < 0 || y.Cmp(p) >= 0 {
+		return false
+	}
+
+	// y² = x³ + 7
+	lhs := new(big.Int).Mul(y, y)
+	lhs.Mod(lhs, p)
+	rhs := new(big.Int).Mul(x, x)
+	rhs.Mul(rhs, x)
+	rhs.Add(rhs, curve.params.B)
+	rhs.Mod(rhs, p)
+	return lhs.Cmp(rhs) == 0
+}
+
+func (curve *secp256k1) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	return curve.toAffine(curve.addJacobian(curve.toJacobian(x1, y1), curve.toJacobian(x2, y2)))
+}
+
+func (curve *secp256k1) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
+	return curve.toAffine(curve.doubleJacobian(curve.toJacobian(x1, y1)))
+}
+
+// ScalarMult returns k*(x, y) by double-and-add, `k` being big-endian.
+func (curve *secp256k1) ScalarMult(x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	base := curve.toJacobian(x, y)
+	result := [3]*big.Int{new(big.Int), new(big.Int), new(big.Int)}
+	for _, b := range k {
+		for bit := 7; bit >= 0; bit-- {
+			result = curve.doubleJacobian(result)
+			if (b>>uint(bit))&1 == 1 {
+				result = curve.addJacobian(result, base)
+			}
+		}
+	}
+	return curve.toAffine(result)
+}
+
+func (curve *secp256k1) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return curve.ScalarMult(curve.params.Gx, curve.params.Gy, k)
+}
+
+// toJacobian converts affine coordinates, (0, 0) being the point at infinity (Z = 0).
+func (curve *secp256k1) toJacobian(x, y *big.Int) [3]*big.Int {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return [3]*big.Int{new(big.Int), new(big.Int), new(big.Int)}
+	}
+	return [3]*big.Int{new(big.Int).Set(x), new(big.Int).Set(y), big.NewInt(1)}
+}
+
+// toAffine converts Jacobian coordinates: x = X/Z², y = Y/Z³.
+func (curve *secp256k1) toAffine(point [3]*big.Int) (*big.Int, *big.Int) {
+	if point[2].Sign() == 0 {
+		return new(big.Int), new(big.Int)
+	}
+	p := curve.params.P
+	zInv := new(big.Int).ModInverse(point[2], p)
+	zInv2 := new(big.Int).Mul(zInv, zInv)
+
+	x := new(big.Int).Mul(point[0], zInv2)
+	x.Mod(x, p)
+	y := zInv2.Mul(zInv2, zInv)
+	y.Mul(y, point[1])
+	y.Mod(y, p)
+	return x, y
+}
+
+// doubleJacobian doubles the point ("dbl-2009-l" formulas for a = 0).
+func (curve *secp256k1) doubleJacobian(point [3]*big.Int) [3]*big.Int {
+	p := curve.params.P
+	x, y, z := point[0], point[1], point[2]
+	if z.Sign() == 0 || y.Sign() == 0 {
+		return [3]*big.Int{new(big.Int), new(big.Int), new(big.Int)}
+	}
+
+	a := new(big.Int).Mul(x, x) // A = X²
+	b := new(big.Int).Mul(y, y) // B = Y²
+	b.Mod(b, p)
+	c := new(big.Int).Mul(b, b) // C = B²
+	d := new(big.Int).Add(x, b) // D = 2*((X+B)² - A - C)
+	d.Mul(d, d)
+	d.Sub(d, a)
+	d.Sub(d, c)
+	d.Lsh(d, 1)
+	e := new(big.Int).Mul(a, big.NewInt(3)) // E = 3*A
+	f := new(big.Int).Mul(e, e)             // F = E²
+
+	x3 := new(big.Int).Sub(f, new(big.Int).Lsh(d, 1)) // X3 = F - 2*D
+	x3.Mod(x3, p)
+	y3 := new(big.Int).Sub(d, x3) // Y3 = E*(D - X3) - 8*C
+	y3.Mul(y3, e)
+	y3.Sub(y3, c.Lsh(c, 3))
+	y3.Mod(y3, p)
+	z3 := new(big.Int).Mul(y, z) // Z3 = 2*Y*Z
+	z3.Lsh(z3, 1)
+	z3.Mod(z3, p)
+	return [3]*big.Int{x3, y3, z3}
+}
+
+// addJacobian adds both points ("add-2007-bl" formulas).
+func (curve *secp256k1) addJacobian(p1, p2 [3]*big.Int) [3]*big.Int {
+	if p1[2].Sign() == 0 {
+		return p2
+	}
+	if p2[2].Sign() == 0 {
+		return p1
+	}
+	p := curve.params.P
+
+	z1z1 := new(big.Int).Mul(p1[2], p1[2])
+	z1z1.Mod(z1z1, p)
+	z2z2 := new(big.Int).Mul(p2[2], p2[2])
+	z2z2.Mod(z2z2, p)
+	u1 := new(big.Int).Mul(p1[0], z2z2)
+	u1.Mod(u1, p)
+	u2 := new(big.Int).Mul(p2[0], z1z1)
+	u2.Mod(u2, p)
+	s1 := new(big.Int).Mul(p1[1], p2[2])
+	s1.Mul(s1, z2z2)
+	s1.Mod(s1, p)
+	s2 := new(big.Int).Mul(p2[1], p1[2])
+	s2.Mul(s2, z1z1)
+	s2.Mod(s2, p)
+
+	if u1.Cmp(u2) == 0 {
+		if s1.Cmp(s2) != 0 {
+			return [3]*big.Int{new(big.Int), new(big.Int), new(big.Int)}
+		}
+		return curve.doubleJacobian(p1)
+	}
+
+	h := new(big.Int).Sub(u2, u1) // H = U2 - U1
+	i := new(big.Int).Lsh(h, 1)   // I = (2*H)²
+	i.Mul(i, i)
+	j := new(big.Int).Mul(h, i)   // J = H*I
+	r := new(big.Int).Sub(s2, s1) // r = 2*(S2 - S1)
+	r.Lsh(r, 1)
+	v := new(big.Int).Mul(u1, i) // V = U1*I
+
+	x3 := new(big.Int).Mul(r, r) // X3 = r² - J - 2*V
+	x3.Sub(x3, j)
+	x3.Sub(x3, new(big.Int).Lsh(v, 1))
+	x3.Mod(x3, p)
+	y3 := new(big.Int).Sub(v, x3) // Y3 = r*(V - X3) - 2*S1*J
+	y3.Mul(y3, r)
+	s1.Mul(s1, j)
+	y3.Sub(y3, s1.Lsh(s1, 1))
+	y3.Mod(y3, p)
+	z3 := new(big.Int).Add(p1[2], p2[2]) // Z3 = ((Z1 + Z2)² - Z1Z1 - Z2Z2)*H
+	z3.Mul(z3, z3)
+	z3.Sub(z3, z1z1)
+	z3.Sub(z3, z2z2)
+	z3.Mul(z3, h)
+	z3.Mod(z3, p)
+	return [3]*big.Int{x3, y3, z3}
+}
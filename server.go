@@ -1,167 +1,555 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
 
-// startBCServer turn on the Blockchain network server.
-func startBCServer(bc *Blockchain) {
+// BCServer accepts the connections of the peers.
+type BCServer struct {
+	bc       *Blockchain
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{} // Open inbound connections.
+	handlers sync.WaitGroup        // Accept loop and connection handlers.
+}
+
+// reqConn is the connection a request was received on, its responses carrying the request's ID.
+type reqConn struct {
+	net.Conn
+	session  *inboundSession
+	id       uint32
+	answered bool
+}
+
+// inboundSession is the state of a connection opened by a peer.
+type inboundSession struct {
+	mu   sync.Mutex
+	invs map[uint32][]InvItem // Items requested from the peer's announcements, by INV ID.
+}
+
+// startBCServer turn on the Blockchain network server, accepting the connections until `ctx` is canceled.
+func startBCServer(ctx context.Context, bc *Blockchain) (*BCServer, error) {
 	cfg := getNetworkCfg()
 	listener, err := net.Listen("tcp", cfg.Network.LocalNode.Address)
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+		return nil, err
 	}
-	defer listener.Close()
-	go closeDB(bc) //@@@ Maybe this function is not needed anymore!
+	srv := &BCServer{bc: bc, listener: listener, conns: make(map[net.Conn]struct{})}
 
 	Info.Println("Local Node listening on port: " + cfg.Network.LocalNode.Address)
+	go pushNeighborBC(bc)
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			Error.Println("Error accept connection: ", err.Error())
-			os.Exit(1)
-		}
-
-		// Create new go-routines to store the new node's connection requests.
-		go handleReq(conn, bc)
-	}
+	srv.handlers.Add(1)
+	go srv.serve(ctx)
+	return srv, nil
 }
 
-// handleReq handles all cases of incoming message's command from any connected node.
-func handleReq(conn net.Conn, bc *Blockchain) {
-	buf := make([]byte, 1024)
-	len, err := conn.Read(buf)
+// handleReq handles the connection opened by a node: the TLS and version handshakes, then its requests
+// until `ctx` is canceled.
+func handleReq(ctx context.Context, rawConn net.Conn, bc *Blockchain) {
+	defer rawConn.Close()
+	pm := getPeerManager()
+	if _, banned := pm.IsBanned(inboundPeerKey(rawConn, "")); banned {
+		return
+	}
+	conn, identity, err := secureInbound(rawConn)
 	if err != nil {
-		Error.Println("Error read data: ", err.Error())
+		Warning.Printf("Reject connection from %s: %v", rawConn.RemoteAddr(), err)
+		return
+	}
+	defer conn.Close()
+	msg, err := readMsg(conn)
+	if errors.Is(err, ErrBadMagic) {
+		// Framed with our magic, the refusal tells the peer which network we run.
+		rejectPeer(conn, err)
+	}
+	if err == nil {
+		if err = verifyMsg(msg, identity); err != nil {
+			rejectPeer(conn, err)
+		}
+	}
+	if err != nil {
+		if isMalformedMsg(err) {
+			pm.Misbehave(inboundPeerKey(conn, ""), MISBEHAVIOUR_MALFORMED_MSG, err.Error())
+		} else if isForgedMsg(err) {
+			pm.Misbehave(inboundPeerKey(conn, ""), MISBEHAVIOUR_FORGED_MSG, err.Error())
+		}
+		Warning.Printf("Reject request from %s: %v", conn.RemoteAddr(), err)
 		return
 	}
 
-	msg := new(Message)
-	err = json.Unmarshal(buf[:len], msg)
+	key := inboundPeerKey(conn, msg.Source.Address)
+	peer, err := pm.Open(key, msg.Source.Address, conn.RemoteAddr().String(), true)
+	if err != nil {
+		rejectPeer(conn, err)
+		Warning.Printf("Reject request from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer pm.Close(peer)
 
+	version, err := handshakeInbound(conn, localVersion(), msg)
 	if err != nil {
-		Error.Println("Error unmarshal:", err.Error())
+		Warning.Printf("Reject request from %s: %v", conn.RemoteAddr(), err)
 		return
 	}
+	pm.Activate(peer, version, identity)
+	heardOfPeer(msg.Source.Address)
+	serveConn(ctx, negotiateWire(conn, version, identity), key, func(req *reqConn, msg *Message) {
+		handleMsg(req, bc, msg, key)
+	})
+}
+
+// serveConn serves the requests of the peer on its connection until it is closed, idle for
+// CONN_IDLE_TIMEOUT, the peer is banned or no longer allowed, or `ctx` is canceled. Each request is handled in its own goroutine,
+// so that a slow request does not delay the others, up to MAX_INFLIGHT_PER_CONN at once.
+// Requests over the peer's rate limits are refused.
+func serveConn(ctx context.Context, conn net.Conn, key string, handle func(req *reqConn, msg *Message)) {
+	pm := getPeerManager()
+	limiter := getInboundLimiter()
+	limiter.Join(key)
+	defer limiter.Leave(key)
+	session := &inboundSession{invs: make(map[uint32][]InvItem)}
+	inflight := make(chan struct{}, MAX_INFLIGHT_PER_CONN)
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// No more requests are read, the ongoing ones are still answered.
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(CONN_IDLE_TIMEOUT))
+		if ctx.Err() != nil {
+			return
+		}
+		msg, err := readMsg(conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if isMalformedMsg(err) {
+				pm.Misbehave(key, MISBEHAVIOUR_MALFORMED_MSG, err.Error())
+			}
+			if err != io.EOF {
+				Warning.Printf("Connection with %s closed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if _, banned := pm.IsBanned(key); banned {
+			return
+		}
+		if peerID := connPeerID(conn); peerID != "" {
+			// The allowed peers may have changed since the handshake (eg: config reloaded).
+			if err = checkPeerID(peerID, ""); err != nil {
+				Warning.Printf("Refused %s request from %s: %v", msg.Cmd, conn.RemoteAddr(), err)
+				rejectPeer(&reqConn{Conn: conn, id: msg.ID}, err)
+				return
+			}
+		}
+		if err = verifyMsg(msg, connPeerID(conn)); err != nil {
+			if isForgedMsg(err) {
+				pm.Misbehave(key, MISBEHAVIOUR_FORGED_MSG, err.Error())
+			}
+			Warning.Printf("Refused %s request from %s: %v", msg.Cmd, conn.RemoteAddr(), err)
+			rejectPeer(&reqConn{Conn: conn, id: msg.ID}, err)
+			return
+		}
+		if !limiter.Allow(key, msg.Cmd) {
+			Warning.Printf("Refused %s request from %s: %v", msg.Cmd, conn.RemoteAddr(), ErrRateLimited)
+			if msg.ID != 0 {
+				rejectPeer(&reqConn{Conn: conn, id: msg.ID}, ErrRateLimited)
+			}
+			continue
+		}
+		if msg.Cmd != CPing {
+			Info.Printf("Handle command %s request from port: %s\n", msg.Cmd, conn.RemoteAddr())
+		}
+
+		inflight <- struct{}{}
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			defer func() { <-inflight }()
+			req := &reqConn{Conn: conn, session: session, id: msg.ID}
+			if isExpensiveCmd(msg.Cmd) {
+				runOnWorker(func() { handle(req, msg) })
+			} else {
+				handle(req, msg)
+			}
+			if msg.ID != 0 && !req.answered {
+				// The requesting node is not left waiting for a response.
+				rejectPeer(req, fmt.Errorf("%s request refused", msg.Cmd))
+			}
+		}()
+	}
+}
 
-	// msg := deserializeMsg(buf[:len])
-	Info.Printf("Handle command %s request from port: %s\n", msg.Cmd, conn.RemoteAddr())
+// respond writes the response message to the requesting node.
+func respond(conn net.Conn, msg *Message) {
+	if err := writeMsg(conn, tagResponse(conn, msg)); err != nil {
+		Warning.Printf("Cannot respond %s to %s: %v", msg.Cmd, conn.RemoteAddr(), err)
+	}
+}
 
-	switch msg.Cmd {
-	case CFwHashList:
-		handleReqFwHash(conn, bc, msg)
-	case CReqDepth:
-		handleReqDepth(conn, bc)
-	case CReqBlock:
-		handleReqBlock(conn, bc, msg)
-	case CReqHeader:
-		handleReqHeader(conn, bc, msg)
-	case CReqAddr:
-		handleReqAddr(conn, msg)
-	case CReqPrf:
-		handleReqPrf(conn, bc, msg)
-	case CPrintChain:
-		handlePrintChain(bc)
-	case CAddBlock:
-		handleAddBlock(conn, bc, []Transaction{}) // NOTE: not use anymore!
-	case CAddTx:
-		handleAddTx(conn, bc, msg)
-	default:
-		Info.Printf("Command message is invalid!\n")
+// tagResponse gives the response the ID of the request it answers, when written to a `reqConn`.
+func tagResponse(conn net.Conn, msg *Message) *Message {
+	if req, ok := conn.(*reqConn); ok {
+		msg.ID = req.id
+		req.answered = true
 	}
+	return msg
+}
 
-	conn.Close()
+// The commands of the node's core, the other subsystems register theirs in their own file.
+func init() {
+	registerCmd(Command{Name: CPing, Response: CPong}, noPayload, noPayload, handlePing)
+	registerCmd(Command{Name: CInv, Response: CGetData, Class: CLASS_RELAY}, invPayload, invPayload, handleInv)
+	registerCmd(Command{Name: CResData, Class: CLASS_RELAY}, invDataPayload, noPayload, handleResData)
+	registerCmd(Command{Name: CReqDepth, Response: CResDepth, Class: CLASS_SYNC}, noPayload, intPayload, handleReqDepth)
+	registerCmd(Command{Name: CReqTip, Response: CResTip, Class: CLASS_SYNC}, noPayload, jsonPayload[*ChainTip](), handleReqTip)
+	registerCmd(Command{Name: CReqBlock, Response: CResBlock, Services: SERVICE_STORAGE, Class: CLASS_SYNC},
+		intPayload, blockPayload, handleReqBlock)
+	registerCmd(Command{Name: CReqHeader, Response: CResHeader, Class: CLASS_SYNC}, headerPayload, boolPayload, handleReqHeader)
+	registerCmd(Command{Name: CReqHeaders, Response: CResHeaders, Class: CLASS_SYNC},
+		hashesPayload(MAX_LOCATOR_LEN), jsonPayload[[]Header](), handleReqHeaders)
+	registerCmd(Command{Name: CReqBlocks, Response: CResBlocks, Services: SERVICE_STORAGE, Class: CLASS_SYNC},
+		hashesPayload(MAX_BLOCKS_PER_MSG), blocksPayload, handleReqBlocks)
+	registerCmd(Command{Name: CReqAddr, Response: CResAddr, Class: CLASS_QUERY}, noPayload, jsonPayload[[]KnownAddr](), handleReqAddr)
+	registerCmd(Command{Name: CReqPrf, Response: CResPrf, Class: CLASS_QUERY, Expensive: true}, rawPayload, boolPayload, handleReqPrf)
+	registerCmd(Command{Name: CPrintChain, Class: CLASS_QUERY}, noPayload, noPayload, handlePrintChain)
+	registerCmd(Command{Name: CAddBlock, Services: SERVICE_MINER, Class: CLASS_RELAY}, noPayload, noPayload, handleAddBlock)
+	registerCmd(Command{Name: CAddTx, Response: CResTx, Services: SERVICE_MINER, Class: CLASS_RELAY, Expensive: true},
+		txPayload, boolPayload, handleAddTx)
+	registerCmd(Command{Name: CReqTxState, Response: CResTxState, Class: CLASS_QUERY}, rawPayload, jsonPayload[*TxStatus](), handleReqTxState)
+	registerCmd(Command{Name: CReqPeers, Response: CResPeers, Admin: true}, noPayload, jsonPayload[*PeersReport](), handleReqPeers)
+	registerCmd(Command{Name: CBanPeer, Response: CResPeers, Admin: true}, jsonPayload[*BanRequest](), jsonPayload[*PeersReport](), handleBanPeer)
+	registerCmd(Command{Name: CUnbanPeer, Response: CResPeers, Admin: true}, stringPayload, jsonPayload[*PeersReport](), handleUnbanPeer)
 }
 
-func handleReqPrf(conn net.Conn, bc *Blockchain, msg *Message) {
-	prf := msg.Data
-	if isValid := bc.ValidatePrf(prf); isValid {
-		resMsg := createMsgResPrf(isValid)
-		conn.Write(resMsg.Serialize())
+// handlePing answers the keepalive request of an idle connection.
+func handlePing(req *Request, _ struct{}) (struct{}, error) {
+	return struct{}{}, nil
+}
+
+// handleReqPrf handles the request verifying a block's proof, answering whether it is valid.
+func handleReqPrf(req *Request, prf []byte) (bool, error) {
+	isValid := req.BC.ValidatePrf(prf)
+	if !isValid {
+		Error.Print("Integrity verification given block failed!")
+	}
+	return isValid, nil
+}
+
+// handleInv handles the announcement of new blocks and transactions, requesting the ones
+// the local node lacks. The announcing node sends them in a RES_DATA message carrying the INV's ID.
+func handleInv(req *Request, items []InvItem) ([]InvItem, error) {
+	wanted := []InvItem{}
+	for _, item := range items {
+		if !haveInv(req.BC, item) {
+			wanted = append(wanted, item)
+		}
+	}
+	if len(wanted) > 0 {
+		req.Conn.session.AddInv(req.Msg.ID, wanted, req.Conn.RemoteAddr())
 	}
-	Error.Print("Integrity verification given block failed!")
+	return wanted, nil
 }
 
-// handleReqFwHash handles request forwards hashes list to all neighbor node.
-func handleReqFwHash(conn net.Conn, bc *Blockchain, msg *Message) {
-	Info.Printf("BlockChain detected modification. Starting synchronize chain...")
-	reqConnectBC(msg.Source, bc)
+// handleResData handles the items requested from an announcement of the peer.
+func handleResData(req *Request, data *InvData) (struct{}, error) {
+	wanted, ok := req.Conn.session.TakeInv(data.InvID)
+	if !ok {
+		Warning.Printf("Items not requested received from %s", req.Conn.RemoteAddr())
+		return struct{}{}, nil
+	}
+	receiveInvData(req.BC, data, req.Msg.Source, req.Key, wanted)
+	return struct{}{}, nil
 }
 
 // handleReqDepth handles the request asking for the others node's depth (blockchain)
 // for the synchronizing in the local node.
 // Response with the message of the other node's depth.
-func handleReqDepth(conn net.Conn, bc *Blockchain) {
-	resMsg := createMsgResDepth(bc.GetDepth())
-	conn.Write(resMsg.Serialize())
+func handleReqDepth(req *Request, _ struct{}) (int, error) {
+	return req.BC.GetDepth(), nil
+}
+
+// handleReqTip handles the request of fetching the tip of the local chain.
+func handleReqTip(req *Request, _ struct{}) (*ChainTip, error) {
+	tip := &ChainTip{Height: req.BC.GetDepth(), Hash: req.BC.GetLatestHash()}
+	tip.Work = chainWork(tip.Height)
+	return tip, nil
 }
 
 // handleReqBlock handles the request of pulling block after checking the neighbor node's depth.
 // Response with the block was missing and sync it into the local node.
-func handleReqBlock(conn net.Conn, bc *Blockchain, msg *Message) {
-	reqDepth := Bytestoi(msg.Data)
-	block := bc.GetBlockByDepth(reqDepth)
-	resMsg := createMsgResBlock(block)
-	conn.Write(resMsg.Serialize())
+func handleReqBlock(req *Request, reqDepth int) (*Block, error) {
+	block := req.BC.GetBlockByDepth(reqDepth)
+	if block == nil {
+		return nil, fmt.Errorf("no block at depth %d", reqDepth)
+	}
+	return block, nil
 }
 
 // handleReqHeader handles the header identical validation block between local and neighbor node.
-func handleReqHeader(conn net.Conn, bc *Blockchain, msg *Message) {
-	neighborHeader := deserializeHeader(msg.Data)
-	localBlock := bc.GetBlockByDepth(neighborHeader.Depth)
-	result := cmp.Equal(*neighborHeader, localBlock.Header)
-	resMsg := createMsgResHeader(result)
-	conn.Write(resMsg.Serialize())
+func handleReqHeader(req *Request, neighborHeader *Header) (bool, error) {
+	localBlock := req.BC.GetBlockByDepth(neighborHeader.Depth)
+	return localBlock != nil && cmp.Equal(*neighborHeader, localBlock.Header), nil
 }
 
-// handleReqAddr handles the request of fetch node's address.
-func handleReqAddr(conn net.Conn, msg *Message) {
-	resMsg := createMsgResAddr()
-	conn.Write(resMsg.Serialize())
-	Info.Printf("Wallet address : %s", getNetworkCfg().WJson.Address)
+// handleReqHeaders handles the request of fetching the headers following the latest block
+// of the locator known by the local node (from the genesis block if none is known).
+func handleReqHeaders(req *Request, locator [][]byte) ([]Header, error) {
+	depth := 0
+	for _, hash := range locator {
+		if block := req.BC.GetBlockByHash(hash); block != nil {
+			depth = block.Header.Depth
+			break
+		}
+	}
+	return req.BC.GetHeadersAfter(depth, MAX_HEADERS_PER_MSG), nil
+}
+
+// handleReqBlocks handles the request of fetching the blocks of the given hashes,
+// answering with the known ones up to the first unknown.
+func handleReqBlocks(req *Request, hashes [][]byte) ([]*Block, error) {
+	blocks := []*Block{}
+	for _, hash := range hashes {
+		block := req.BC.GetBlockByHash(hash)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// handleReqAddr handles the request of fetching the addresses known by the node,
+// answering with a sample of the recently seen ones.
+func handleReqAddr(req *Request, _ struct{}) ([]KnownAddr, error) {
+	addrs := []KnownAddr{}
+	if ab := getAddrBook(); ab != nil {
+		addrs = ab.Sample(MAX_ADDR_PER_MSG, req.Msg.Source.Address)
+	}
+	return addrs, nil
 }
 
 // handlePrintChain handles the request of printing the chain's values in string format.
-func handlePrintChain(bc *Blockchain) {
-	Info.Printf("%v", bc.Stringify())
+func handlePrintChain(req *Request, _ struct{}) (struct{}, error) {
+	Info.Printf("%v", req.BC.Stringify())
+	return struct{}{}, nil
 }
 
 // NOTE: now instead of adding block -> adding a blank transaction to the latest block.
 // handleAddBlock handles the request of adding new block to the chain.
-func handleAddBlock(conn net.Conn, bc *Blockchain, txs []Transaction) {
-	block := newBlock(txs, bc.GetLatestHash(), bc.GetDepth()+1)
-	bc.AddBlock(block)
-	fwHashes(bc)
+func handleAddBlock(req *Request, _ struct{}) (struct{}, error) {
+	getMiner().Mine(req.BC, []Transaction{})
+	return struct{}{}, nil
 }
 
 // handleAddTx handles the request to add a transaction into a block.
-func handleAddTx(conn net.Conn, bc *Blockchain, msg *Message) {
-	var isSuccess bool
-
-	tx := DeserializeTx(msg.Data)
+func handleAddTx(req *Request, tx *Transaction) (bool, error) {
 	Info.Printf("Receiving new transaction: %x", tx)
 
-	isSuccess = bc.VerifyTx(tx)
+	isSuccess := acceptTx(req.BC, tx, req.Key)
 	if isSuccess {
+		announceTx(req.BC, tx, req.Msg.Source.Address)
+
 		Info.Println("Transaction validation succeeded => Create new block!")
 		toAddr := getWallet().Address
 		Info.Printf("Indicating coinbase transaction to an address: %s", toAddr)
 
 		coinbaseTx := newCoinBaseTx(toAddr)
-		nBlock := newBlock([]Transaction{*tx, *coinbaseTx}, bc.GetLatestHash(), bc.GetDepth()+1)
-		bc.AddBlock(nBlock)
-		fwHashes(bc)
+		getMiner().Mine(req.BC, []Transaction{*tx, *coinbaseTx})
 	} else {
 		Info.Println("Invalid transaction!")
 	}
+	return isSuccess, nil
+}
+
+// acceptTx verifies the transaction and adds it to the mempool.
+// A transaction spending outputs already spent by a confirmed (or pending) one
+// is remembered as conflicted, so that its sender can find out why it was dropped.
+func acceptTx(bc *Blockchain, tx *Transaction, key string) bool {
+	// Only the signed transactions matching their ID can be marked as conflicted,
+	// so that nobody can report the pending transaction of somebody else as dropped.
+	if !tx.HasValidID() || !tx.VerifySignature() {
+		getPeerManager().Misbehave(key, MISBEHAVIOUR_INVALID_TX, fmt.Sprintf("invalid transaction %x", tx.ID))
+		return false
+	}
+	if spenderID, isSpent := bc.FindSpender(tx); isSpent {
+		Info.Printf("Transaction %x conflicts with confirmed transaction %x", tx.ID, spenderID)
+		getMempool().MarkConflict(tx.ID, spenderID)
+		return false
+	}
+	if !bc.VerifyTx(tx) {
+		getPeerManager().Misbehave(key, MISBEHAVIOUR_INVALID_TX, fmt.Sprintf("invalid transaction %x", tx.ID))
+		return false
+	}
+	if conflictID, isAdded := getMempool().Add(tx); !isAdded {
+		Info.Printf("Transaction %x conflicts with pending transaction %s", tx.ID, conflictID)
+		return false
+	}
+	return true
+}
+
+// handleReqTxState handles the request of fetching the state of a transaction.
+func handleReqTxState(req *Request, txID []byte) (*TxStatus, error) {
+	return req.BC.GetTxStatus(txID), nil
+}
+
+// handleReqPeers handles the admin request of the `peers list` command,
+// answering with the state of the peer manager.
+func handleReqPeers(req *Request, _ struct{}) (*PeersReport, error) {
+	report := getPeerManager().Report()
+	report.Inbound = getInboundLimiter().Stats()
+	return report, nil
+}
+
+// handleBanPeer handles the admin request banning a peer.
+func handleBanPeer(req *Request, ban *BanRequest) (*PeersReport, error) {
+	if ban == nil || ban.Key == "" {
+		return nil, fmt.Errorf("malformed ban request")
+	}
+	duration := time.Duration(ban.Duration) * time.Second
+	if duration <= 0 {
+		duration = banDuration()
+	}
+	getPeerManager().Ban(peerKey(ban.Key), duration, ban.Reason)
+	return handleReqPeers(req, struct{}{})
+}
+
+// handleUnbanPeer handles the admin request lifting the ban of a peer.
+func handleUnbanPeer(req *Request, key string) (*PeersReport, error) {
+	if !getPeerManager().Unban(peerKey(key)) {
+		return nil, fmt.Errorf("%s is not banned", key)
+	}
+	Info.Printf("Peer %s is unbanned", key)
+	return handleReqPeers(req, struct{}{})
+}
+
+// BCServer's methods:
+
+// serve accepts the connections of the peers until `ctx` is canceled.
+func (srv *BCServer) serve(ctx context.Context) {
+	defer srv.handlers.Done()
+	go func() {
+		<-ctx.Done()
+		srv.listener.Close()
+	}()
+
+	limiter := getInboundLimiter()
+	var delay time.Duration
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Out of file descriptors for instance: wait for connections to close.
+			delay = minVal(2*delay+5*time.Millisecond, time.Second)
+			Error.Printf("Error accept connection: %v, retrying in %v", err, delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			continue
+		}
+		delay = 0
+		if err = limiter.Accept(conn); err != nil {
+			Warning.Printf("Reject connection from %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+
+		// Create new go-routines to store the new node's connection requests.
+		srv.track(conn, true)
+		srv.handlers.Add(1)
+		go func() {
+			defer srv.handlers.Done()
+			defer srv.track(conn, false)
+			defer limiter.Release(conn)
+			handleReq(ctx, conn, srv.bc)
+		}()
+	}
+}
+
+// Stop closes the listener and waits for the ongoing requests to be answered until `ctx` expires,
+// then closes the remaining connections.
+func (srv *BCServer) Stop(ctx context.Context) error {
+	srv.listener.Close()
+	err := waitCtx(ctx, srv.handlers.Wait)
+	if err != nil {
+		srv.mu.Lock()
+		Warning.Printf("Closing %d connection(s) with requests still running", len(srv.conns))
+		for conn := range srv.conns {
+			conn.Close()
+		}
+		srv.mu.Unlock()
+	}
+	return err
+}
+
+func (srv *BCServer) track(conn net.Conn, open bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if open {
+		srv.conns[conn] = struct{}{}
+	} else {
+		delete(srv.conns, conn)
+	}
+}
+
+// reqConn's methods:
+
+// Codec returns the codec negotiated on the connection.
+func (req *reqConn) Codec() WireCodec {
+	return connCodec(req.Conn)
+}
+
+// Write writes to the peer, giving up if it does not read within WRITE_TIMEOUT.
+func (req *reqConn) Write(b []byte) (int, error) {
+	req.Conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	return req.Conn.Write(b)
+}
+
+// inboundSession's methods:
+
+// AddInv remembers the items requested from an announcement, until they are received
+// or INV_DATA_TIMEOUT elapses.
+func (session *inboundSession) AddInv(invID uint32, wanted []InvItem, remote net.Addr) {
+	session.mu.Lock()
+	session.invs[invID] = wanted
+	session.mu.Unlock()
+
+	time.AfterFunc(INV_DATA_TIMEOUT, func() {
+		if _, ok := session.TakeInv(invID); ok {
+			Warning.Printf("Announced items not received from %s", remote)
+		}
+	})
+}
+
+// TakeInv returns and forgets the items requested from the given announcement.
+func (session *inboundSession) TakeInv(invID uint32) ([]InvItem, bool) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
 
-	resMsg := createMsgResAddTx(isSuccess)
-	conn.Write(resMsg.Serialize())
+	wanted, ok := session.invs[invID]
+	delete(session.invs, invID)
+	return wanted, ok
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Shamir's secret sharing over GF(2^8): every byte of the secret is the constant term of
+// a random polynomial of degree `threshold - 1`, share `x` holding its value at `x` (1..255).
+// Any `threshold` shares rebuild the secret by Lagrange interpolation at 0,
+// fewer shares tell nothing about it.
+
+const (
+	SHAMIR_MAX_SHARES = 255
+	SHAMIR_POLYNOMIAL = 0x11b // x^8 + x^4 + x^3 + x + 1 (the AES field).
+)
+
+var ErrNotEnoughShares = errors.New("not enough shares")
+
+// Logarithm and exponential tables of GF(2^8), generated by 3.
+var gfLog, gfExp = genGFTables()
+
+// Utility functions start from here.
+
+func genGFTables() ([256]byte, [510]byte) {
+	var logs [256]byte
+	var exps [510]byte
+	x := 1
+	for idx := 0; idx < 255; idx++ {
+		exps[idx], exps[idx+255] = byte(x), byte(x)
+		logs[x] = byte(idx)
+
+		// x *= 3, ie: x ^= x * 2.
+		x2 := x << 1
+		if x2&0x100 != 0 {
+			x2 ^= SHAMIR_POLYNOMIAL
+		}
+		x ^= x2
+	}
+	return logs, exps
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// shamirSplit splits the secret into `n` shares, any `threshold` of them rebuilding it.
+// The share `idx` is the evaluation of the polynomials at `x = idx + 1`.
+func shamirSplit(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 1 || threshold > n || n > SHAMIR_MAX_SHARES {
+		return nil, fmt.Errorf("invalid %d-of-%d sharing (at most %d shares)", threshold, n, SHAMIR_MAX_SHARES)
+	}
+
+	shares := make([][]byte, n)
+	for idx := range shares {
+		shares[idx] = make([]byte, len(secret))
+	}
+	coeffs := make([]byte, threshold)
+	for pos, b := range secret {
+		coeffs[0] = b
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for idx := range shares {
+			// Horner's method.
+			x, y := byte(idx+1), byte(0)
+			for deg := threshold - 1; deg >= 0; deg-- {
+				y = gfMul(y, x) ^ coeffs[deg]
+			}
+			shares[idx][pos] = y
+		}
+	}
+	return shares, nil
+}
+
+// shamirCombine rebuilds the secret from the shares evaluated at the given (distinct) `xs`.
+func shamirCombine(xs []byte, shares [][]byte) ([]byte, error) {
+	if len(xs) == 0 || len(xs) != len(shares) {
+		return nil, ErrNotEnoughShares
+	}
+	for i := range xs {
+		if xs[i] == 0 || len(shares[i]) != len(shares[0]) {
+			return nil, fmt.Errorf("invalid share %d", xs[i])
+		}
+		for j := 0; j < i; j++ {
+			if xs[i] == xs[j] {
+				return nil, fmt.Errorf("duplicated share %d", xs[i])
+			}
+		}
+	}
+
+	secret := make([]byte, len(shares[0]))
+	for i, xi := range xs {
+		// Lagrange basis polynomial at 0: prod(xj / (xj - xi)), subtraction being XOR.
+		basis := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				basis = gfMul(basis, gfDiv(xj, xj^xi))
+			}
+		}
+		for pos, y := range shares[i] {
+			secret[pos] ^= gfMul(y, basis)
+		}
+	}
+	return secret, nil
+}
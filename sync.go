@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Headers-first synchronization: the node sends the block locator of its chain (REQ_HEADERS)
+// and gets back the headers following the latest block it shares with the peer. The header
+// chain and its proof of work are validated before any block is downloaded (REQ_BLOCKS),
+// in batches. Only the blocks added to the chain are kept, so an interrupted synchronization
+// resumes from the locator of the blocks already stored. A node whose chain forks from the
+// local one is followed only if its branch has more work, the local chain being reorganized
+// from their common ancestor.
+
+const (
+	MAX_HEADERS_PER_MSG   = 500           // Largest number of headers sent in one RES_HEADERS.
+	MAX_BLOCKS_PER_MSG    = 16            // Largest number of blocks sent in one RES_BLOCKS.
+	MAX_LOCATOR_LEN       = 100           // Largest number of hashes in a block locator.
+	MAX_FUTURE_BLOCK_TIME = 2 * time.Hour // Blocks further in the future are invalid.
+)
+
+var (
+	ErrInvalidHeader = errors.New("invalid header")
+	ErrChainForked   = errors.New("chain diverges from the local one")
+)
+
+// ChainTip describes the chain of a peer.
+type ChainTip struct {
+	Node   Node     `json:"-"`      // Peer holding the chain.
+	Height int      `json:"height"` // Depth of its chain.
+	Hash   []byte   `json:"hash"`   // Hash of its latest block.
+	Work   *big.Int `json:"work"`   // Work of its chain (see `chainWork`).
+}
+
+// Utility functions start from here.
+
+// reqConnectBC synchronizes the local chain with the given node, headers first.
+// When the node's chain forks from the local one and has more work, the local chain
+// is reorganized from their common ancestor.
+// Returns false if the node is not available or its chain could not be used.
+func reqConnectBC(node Node, bc *Blockchain) bool {
+	locator := bc.Locator()
+	for {
+		headers, err := getHeadersNeighbor(node, locator)
+		if err != nil {
+			Error.Printf("Cannot fetch headers from %s: %v", node.Address, err)
+			return false
+		}
+		isLastBatch := len(headers) < MAX_HEADERS_PER_MSG
+		if len(headers) > 0 {
+			locator = [][]byte{headers[len(headers)-1].Hash}
+		}
+		if headers = skipKnownHeaders(bc, headers); len(headers) == 0 {
+			if isLastBatch {
+				return true
+			}
+			continue
+		}
+
+		if err = checkHeaders(bc, headers); errors.Is(err, ErrChainForked) {
+			Error.Printf("Chain of %s: %v, skip synchronizing with it!", node.Address, err)
+			return false
+		} else if err != nil {
+			getPeerManager().Misbehave(peerKey(node.Address), MISBEHAVIOUR_INVALID_BLOCK, err.Error())
+			return false
+		}
+		Info.Printf("Received %d valid headers [%d-%d] from %s", len(headers),
+			headers[0].Depth, headers[len(headers)-1].Depth, node.Address)
+
+		if !bytes.Equal(headers[0].PrevBlockHash, bc.GetLatestHash()) {
+			if !reorganizeBC(node, bc, headers, isLastBatch) {
+				return false
+			}
+		} else if !downloadBlocks(node, bc, headers) {
+			return false
+		} else if isLastBatch {
+			return true
+		}
+		locator = bc.Locator()
+	}
+}
+
+// skipKnownHeaders drops the leading headers of the blocks the local chain already has: the node
+// answers from the latest block of the locator it knows, which may precede the fork of the chains.
+func skipKnownHeaders(bc *Blockchain, headers []Header) []Header {
+	for len(headers) > 0 && bc.GetBlockByHash(headers[0].Hash) != nil {
+		headers = headers[1:]
+	}
+	return headers
+}
+
+// checkHeaders validates a batch of headers following a block of the local chain:
+// each one must carry a valid proof of work and extend the previous one.
+func checkHeaders(bc *Blockchain, headers []Header) error {
+	first := &headers[0]
+	if first.Depth == 1 {
+		if len(first.PrevBlockHash) != 0 || !getChainParams().IsGenesis(&Block{Header: *first}) {
+			return fmt.Errorf("%w: block %x is not the genesis block", ErrInvalidHeader, first.Hash)
+		}
+		if !bc.IsEmpty() {
+			return fmt.Errorf("%w from the genesis block", ErrChainForked)
+		}
+	} else {
+		parent := bc.GetBlockByHash(first.PrevBlockHash)
+		if parent == nil || parent.Header.Depth+1 != first.Depth {
+			return fmt.Errorf("%w: block [%d] does not follow the locator", ErrInvalidHeader, first.Depth)
+		}
+	}
+	return checkHeaderChain(headers)
+}
+
+// checkHeaderChain checks the proof of work of every header and that each one extends the previous one.
+func checkHeaderChain(headers []Header) error {
+	maxTimestamp := time.Now().Add(MAX_FUTURE_BLOCK_TIME).Unix()
+	for idx := range headers {
+		header := &headers[idx]
+		if idx > 0 && (header.Depth != headers[idx-1].Depth+1 || !bytes.Equal(header.PrevBlockHash, headers[idx-1].Hash)) {
+			return fmt.Errorf("%w: block [%d] does not extend block [%d]", ErrInvalidHeader, header.Depth, headers[idx-1].Depth)
+		}
+		if !checkHeaderPoW(header) {
+			return fmt.Errorf("%w: block [%d] %x does not satisfy the proof of work", ErrInvalidHeader, header.Depth, header.Hash)
+		}
+		if header.Timestamp > maxTimestamp {
+			return fmt.Errorf("%w: block [%d] is too far in the future", ErrInvalidHeader, header.Depth)
+		}
+	}
+	return nil
+}
+
+// reorganizeBC switches the local chain to the node's branch forking from it, if the branch
+// has more work than the local blocks it replaces. The headers of the branch are fetched until
+// it outgrows the local chain, its blocks are downloaded, then swapped in one database transaction.
+func reorganizeBC(node Node, bc *Blockchain, branch []Header, isLastBatch bool) bool {
+	for !isLastBatch && branch[len(branch)-1].Depth <= bc.GetDepth() {
+		headers, err := getHeadersNeighbor(node, [][]byte{branch[len(branch)-1].Hash})
+		if err != nil {
+			Error.Printf("Cannot fetch headers from %s: %v", node.Address, err)
+			return false
+		}
+		isLastBatch = len(headers) < MAX_HEADERS_PER_MSG
+		branch = append(branch, headers...)
+		if err = checkHeaderChain(branch); err != nil {
+			getPeerManager().Misbehave(peerKey(node.Address), MISBEHAVIOUR_INVALID_BLOCK, err.Error())
+			return false
+		}
+	}
+
+	fork := bc.GetBlockByHash(branch[0].PrevBlockHash)
+	branchWork, localWork := chainWork(branch[len(branch)-1].Depth), chainWork(bc.GetDepth())
+	if branchWork.Cmp(localWork) <= 0 {
+		Error.Printf("Chain of %s: %v at block [%d] with less work, skip synchronizing with it!",
+			node.Address, ErrChainForked, fork.Header.Depth)
+		return false
+	}
+	Info.Printf("Chain of %s forks at block [%d] with more work, downloading its %d blocks",
+		node.Address, fork.Header.Depth, len(branch))
+
+	var blocks []*Block
+	isFetched := fetchBlocks(node, branch, func(block *Block) bool {
+		blocks = append(blocks, block)
+		return true
+	})
+	if !isFetched {
+		return false
+	}
+	removed, ok := bc.Reorganize(fork, blocks)
+	if !ok {
+		Error.Printf("Cannot reorganize the chain at block [%d]", fork.Header.Depth)
+		return false
+	}
+	Info.Printf("Reorganized the chain at block [%d]: %d blocks replaced by %d blocks of %s",
+		fork.Header.Depth, len(removed), len(blocks), node.Address)
+	restoreTxs(bc, removed)
+	return true
+}
+
+// restoreTxs returns the transactions of the blocks removed from the chain to the mempool,
+// unless the new chain confirms them or spends their inputs.
+func restoreTxs(bc *Blockchain, removed []*Block) {
+	for _, block := range removed {
+		for idx := range block.Transactions {
+			tx := &block.Transactions[idx]
+			if tx.IsCoinbase() {
+				continue
+			}
+			if _, isSpent := bc.FindSpender(tx); !isSpent && bc.VerifyTx(tx) {
+				getMempool().Add(tx)
+			}
+		}
+	}
+}
+
+// downloadBlocks downloads the blocks of the validated headers in batches and adds them to the chain.
+func downloadBlocks(node Node, bc *Blockchain, headers []Header) bool {
+	return fetchBlocks(node, headers, func(block *Block) bool {
+		if !bc.AddBlock(block) {
+			getPeerManager().Misbehave(peerKey(node.Address), MISBEHAVIOUR_INVALID_BLOCK,
+				fmt.Sprintf("invalid block [%d] %x", block.Header.Depth, block.Header.Hash))
+			return false
+		}
+		return true
+	})
+}
+
+// fetchBlocks downloads the blocks of the validated headers in batches,
+// passing each block matching its header to `accept` until it returns false.
+func fetchBlocks(node Node, headers []Header, accept func(*Block) bool) bool {
+	key := peerKey(node.Address)
+	for start := 0; start < len(headers); {
+		end := minVal(start+MAX_BLOCKS_PER_MSG, len(headers))
+		var hashes [][]byte
+		for _, header := range headers[start:end] {
+			hashes = append(hashes, header.Hash)
+		}
+
+		blocks, err := getBlocksNeighbor(node, hashes)
+		if err != nil {
+			Error.Printf("Cannot fetch blocks from %s: %v", node.Address, err)
+			return false
+		}
+		if len(blocks) == 0 {
+			Error.Printf("%s did not send the blocks [%d-%d]", node.Address, headers[start].Depth, headers[end-1].Depth)
+			return false
+		}
+
+		for _, block := range blocks {
+			header := headers[start]
+			if !cmp.Equal(block.Header, header) || !newProofOfWork(block).ValidateHash() {
+				getPeerManager().Misbehave(key, MISBEHAVIOUR_INVALID_BLOCK, fmt.Sprintf("block [%d] does not match its header", header.Depth))
+				return false
+			}
+			if !accept(block) {
+				return false
+			}
+			start++
+		}
+		Info.Printf("Pulled blocks up to [%d] from %s. Progress: %d%%", headers[start-1].Depth,
+			node.Address, headers[start-1].Depth*100/headers[len(headers)-1].Depth)
+	}
+	return true
+}
+
+// getChainTips returns the tips of the nodes' chains, the one with the most work first
+// (then the highest one). The unreachable nodes are left out.
+func getChainTips(nodes []Node) []*ChainTip {
+	var tips []*ChainTip
+	for _, node := range nodes {
+		tip, err := getTipNeighbor(node)
+		if err != nil {
+			Info.Printf("Cannot fetch the chain tip of %s: %v", node.Address, err)
+			continue
+		}
+		tips = append(tips, tip)
+	}
+	sort.SliceStable(tips, func(i, j int) bool {
+		if order := tips[i].Work.Cmp(tips[j].Work); order != 0 {
+			return order > 0
+		}
+		return tips[i].Height > tips[j].Height
+	})
+	return tips
+}
+
+// pushTip announces the latest block of the local chain to the peers whose chain has less work,
+// so that they synchronize with the local node.
+func pushTip(bc *Blockchain, tips []*ChainTip) {
+	if bc.IsEmpty() {
+		return
+	}
+	localWork := chainWork(bc.GetDepth())
+	item := InvItem{Type: INV_BLOCK, Hash: bc.GetLatestHash()}
+	for _, tip := range tips {
+		if tip.Work.Cmp(localWork) < 0 {
+			Info.Printf("Announcing the chain tip to %s (height %d)", tip.Node.Address, tip.Height)
+			go sendInv(bc, tip.Node, []InvItem{item})
+		}
+	}
+}
+
+// getTipNeighbor returns the tip of the node's chain.
+func getTipNeighbor(node Node) (*ChainTip, error) {
+	msgRes, err := reqNeighbor(createMsgReqTip(), node)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMsgCmd(msgRes, CResTip); err != nil {
+		return nil, err
+	}
+
+	tip := &ChainTip{Node: node}
+	if err = json.Unmarshal(msgRes.Data, tip); err != nil || tip.Work == nil {
+		getPeerManager().Misbehave(peerKey(node.Address), MISBEHAVIOUR_MALFORMED_MSG, "malformed chain tip")
+		return nil, fmt.Errorf("malformed chain tip")
+	}
+	return tip, nil
+}
+
+// getHeadersNeighbor returns the headers following the latest block of the locator known by the node.
+func getHeadersNeighbor(node Node, locator [][]byte) ([]Header, error) {
+	msgRes, err := reqNeighbor(createMsgReqHeaders(locator), node)
+	if err != nil {
+		return nil, err
+	}
+	if msgRes.Cmd == CReject {
+		return nil, fmt.Errorf("%w: %s", ErrPeerRejected, msgRes.Data)
+	}
+
+	var headers []Header
+	if err = json.Unmarshal(msgRes.Data, &headers); err != nil || len(headers) > MAX_HEADERS_PER_MSG {
+		getPeerManager().Misbehave(peerKey(node.Address), MISBEHAVIOUR_MALFORMED_MSG, "malformed headers")
+		return nil, fmt.Errorf("malformed headers")
+	}
+	return headers, nil
+}
+
+// getBlocksNeighbor returns the blocks of the given hashes, in the same order.
+// The node may send fewer blocks than requested.
+func getBlocksNeighbor(node Node, hashes [][]byte) ([]*Block, error) {
+	msgRes, err := reqNeighbor(createMsgReqBlocks(hashes), node)
+	if err != nil {
+		return nil, err
+	}
+	if msgRes.Cmd == CReject {
+		return nil, fmt.Errorf("%w: %s", ErrPeerRejected, msgRes.Data)
+	}
+
+	blocks, err := decodeWireBlocks(msgRes.Data)
+	if err != nil || len(blocks) > len(hashes) {
+		getPeerManager().Misbehave(peerKey(node.Address), MISBEHAVIOUR_MALFORMED_MSG, "malformed blocks")
+		return nil, fmt.Errorf("malformed blocks")
+	}
+	for _, block := range blocks {
+		if block == nil {
+			return nil, fmt.Errorf("malformed blocks")
+		}
+	}
+	return blocks, nil
+}
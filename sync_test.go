@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// newTestChain returns an empty chain stored in a temporary database.
+func newTestChain(t *testing.T, name string) *Blockchain {
+	db, err := openDB(filepath.Join(t.TempDir(), name+".db"))
+	if err != nil {
+		t.Fatalf("openDB failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte(BLOCKS_BUCKET))
+		return err
+	})
+	return &Blockchain{DB: db}
+}
+
+// setTestConfig replaces the network configurations until the end of the test.
+func setTestConfig(t testing.TB, cfg *Config) {
+	old := getNetworkCfg()
+	t.Cleanup(func() { setNetworkCfg(old) })
+	setNetworkCfg(cfg)
+}
+
+// serveTestChain answers the sync requests of the local node with the given chain.
+func serveTestChain(t *testing.T, bc *Blockchain) Node {
+	return serveTestConns(t, nil, func(req *reqConn, msg *Message) {
+		handleMsg(req, bc, msg, "")
+	})
+}
+
+// serveTestConns accepts the connections of the local node, sending them to `accepted` if not nil,
+// and hands their requests to `handle` once the handshakes are done.
+func serveTestConns(t *testing.T, accepted chan<- net.Conn, handle func(req *reqConn, msg *Message)) Node {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Cannot listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	t.Cleanup(closePeerClients)
+
+	peerVersion := testPeerVersion()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if accepted != nil {
+				accepted <- conn
+			}
+			go func() {
+				defer conn.Close()
+				conn, err := acceptTestPeer(conn, peerVersion)
+				if err == nil {
+					serveConn(context.Background(), conn, "", handle)
+				}
+			}()
+		}
+	}()
+	return Node{Address: listener.Addr().String()}
+}
+
+func TestHeadersFirstSync(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	remote := newTestChain(t, "remote")
+	remote.AddBlock(getChainParams().GenesisBlock())
+	for depth := 2; depth <= 5; depth++ {
+		remote.AddBlock(newBlock([]Transaction{*newCoinBaseTx(newWallet().Address)}, remote.GetLatestHash(), depth))
+	}
+	node := serveTestChain(t, remote)
+
+	if locator := remote.Locator(); len(locator) != 5 || !bytes.Equal(locator[0], remote.GetLatestHash()) {
+		t.Errorf("Unexpected locator of %d hashes", len(locator))
+	}
+
+	// An interrupted synchronization: the local node stored the 2 first blocks only.
+	local := newTestChain(t, "local")
+	local.AddBlock(remote.GetBlockByDepth(1))
+	local.AddBlock(remote.GetBlockByDepth(2))
+	if !reqConnectBC(node, local) {
+		t.Fatalf("Synchronization failed")
+	}
+	if local.GetDepth() != 5 || !bytes.Equal(local.GetLatestHash(), remote.GetLatestHash()) {
+		t.Errorf("Expected the remote chain, got depth %d", local.GetDepth())
+	}
+	if !reqConnectBC(node, local) || local.GetDepth() != 5 {
+		t.Errorf("Synchronizing an up to date chain should change nothing")
+	}
+
+	// A chain diverging after the genesis block with less work is reorganized.
+	forked := newTestChain(t, "forked")
+	forked.AddBlock(remote.GetBlockByDepth(1))
+	forked.AddBlock(newBlock([]Transaction{}, forked.GetLatestHash(), 2))
+	forkHash := forked.GetLatestHash()
+	if !reqConnectBC(node, forked) {
+		t.Fatalf("Synchronizing with a diverging chain of more work failed")
+	}
+	if forked.GetDepth() != 5 || !bytes.Equal(forked.GetLatestHash(), remote.GetLatestHash()) {
+		t.Errorf("Expected the remote chain, got depth %d", forked.GetDepth())
+	}
+	if forked.GetBlockByHash(forkHash) != nil {
+		t.Errorf("Replaced block should be deleted")
+	}
+}
+
+func TestForkWithLessWork(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	remote := newTestChain(t, "remote")
+	remote.AddBlock(getChainParams().GenesisBlock())
+	remote.AddBlock(newBlock([]Transaction{}, remote.GetLatestHash(), 2))
+	node := serveTestChain(t, remote)
+
+	local := newTestChain(t, "local")
+	local.AddBlock(remote.GetBlockByDepth(1))
+	for depth := 2; depth <= 3; depth++ {
+		local.AddBlock(newBlock([]Transaction{*newCoinBaseTx(newWallet().Address)}, local.GetLatestHash(), depth))
+	}
+	latestHash := local.GetLatestHash()
+	if reqConnectBC(node, local) {
+		t.Errorf("Synchronizing with a diverging chain of less work should fail")
+	}
+	if !bytes.Equal(local.GetLatestHash(), latestHash) {
+		t.Errorf("Local chain with more work should not be modified")
+	}
+
+	tips := getChainTips([]Node{node, {Address: "127.0.0.1:1"}})
+	if len(tips) != 1 || tips[0].Height != 2 || tips[0].Work.Cmp(chainWork(2)) != 0 {
+		t.Fatalf("Unexpected chain tips %v", tips)
+	}
+}
+
+func TestReorganize(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+	bc.AddBlock(newBlock([]Transaction{}, bc.GetLatestHash(), 2))
+	fork := bc.GetBlockByDepth(2)
+	bc.AddBlock(newBlock([]Transaction{}, bc.GetLatestHash(), 3))
+	replaced := bc.GetLatestHash()
+
+	branch := []*Block{newBlock([]Transaction{*newCoinBaseTx(newWallet().Address)}, fork.Header.Hash, 3)}
+	branch = append(branch, newBlock([]Transaction{}, branch[0].Header.Hash, 4))
+	if _, ok := bc.Reorganize(fork, branch[1:]); ok {
+		t.Errorf("Branch not extending the fork block should be refused")
+	}
+	removed, ok := bc.Reorganize(fork, branch)
+	if !ok || len(removed) != 1 || !bytes.Equal(removed[0].Header.Hash, replaced) {
+		t.Fatalf("Unexpected reorganization: %v, %v", removed, ok)
+	}
+	if bc.GetDepth() != 4 || !bytes.Equal(bc.GetLatestHash(), branch[1].Header.Hash) || bc.GetBlockByHash(replaced) != nil {
+		t.Errorf("Chain should end with the branch")
+	}
+}
+
+func TestCheckHeaders(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+	bc.AddBlock(newBlock([]Transaction{}, bc.GetLatestHash(), 2))
+	headers := bc.GetHeadersAfter(0, MAX_HEADERS_PER_MSG)
+	if len(headers) != 2 || headers[0].Depth != 1 {
+		t.Fatalf("Unexpected headers %v", headers)
+	}
+
+	empty := newTestChain(t, "empty")
+	if err := checkHeaders(empty, headers); err != nil {
+		t.Errorf("Valid headers rejected: %v", err)
+	}
+	unmined := headers[1]
+	unmined.Hash = bytes.Repeat([]byte{0xff}, 32)
+	if err := checkHeaders(empty, []Header{headers[0], unmined}); err == nil {
+		t.Errorf("Header without proof of work should be rejected")
+	}
+	unlinked := headers[1]
+	unlinked.PrevBlockHash = unmined.Hash
+	if err := checkHeaders(empty, []Header{headers[0], unlinked}); err == nil {
+		t.Errorf("Header not extending the previous one should be rejected")
+	}
+}
@@ -3,13 +3,11 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
-	"math/big"
 )
 
 // A quickly explanation of transmitting mechanism or transaction procedure in a Blockchain system:
@@ -66,6 +64,26 @@ func (tx *Transaction) HashTx() []byte {
 	return hash[:]
 }
 
+// HasValidID returns true if the ID of the transaction is the hash of its contents,
+// the signatures left out.
+func (tx *Transaction) HasValidID() bool {
+	unsignedTx := tx.Clone()
+	return bytes.Equal(tx.ID, unsignedTx.HashTx())
+}
+
+// HasDuplicateInputs returns true if the transaction spends an output more than once.
+func (tx *Transaction) HasDuplicateInputs() bool {
+	spent := make(map[string]bool)
+	for _, txIn := range tx.TxIns {
+		outpoint := fmt.Sprintf("%x:%d", txIn.TxID, txIn.TxOutIdx)
+		if spent[outpoint] {
+			return true
+		}
+		spent[outpoint] = true
+	}
+	return false
+}
+
 // Clone is the method that allows creating a new imitation/emulation
 // transaction from the original one.
 func (tx *Transaction) Clone() Transaction {
@@ -100,58 +118,70 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey) {
 	}
 
 	clonedTx := tx.Clone()
-	signData := fmt.Sprintf("%x", clonedTx)
-	// NOTE: Not yet fully understood!
-	// IDEA: a full signature was generated from a `rand` number, a buyer's `privKey`,
-	// and the corresponding data.
-	r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(signData))
-	if err != nil {
-		Error.Panic(err)
-	}
+	signature := signBytes(privKey, clonedTx.SigHash())
 
 	// NOTE: the main point of this process represents cloning the data of a transaction from a block.
 	// Executing all of the necessary calculations on the cloned transaction,
 	// before returning the signature to the original one.
-	signature := append(r.Bytes(), s.Bytes()...)
 	for idx := range clonedTx.TxIns {
 		clonedTx.TxIns[idx].Signature = nil
 		tx.TxIns[idx].Signature = signature
 	}
 }
 
+// SignWith signs every input with the wallet owning its public key,
+// so that one transaction can spend the funds of several addresses.
+func (tx *Transaction) SignWith(wallets []*Wallet) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	signData := tx.SigHash()
+	for idx, txIn := range tx.TxIns {
+		owner := findWalletByPubKey(wallets, txIn.PubKey)
+		if owner == nil {
+			return fmt.Errorf("no wallet owns the input %x:%d", txIn.TxID, txIn.TxOutIdx)
+		}
+		signature, err := owner.SignData(signData)
+		if err != nil {
+			return err
+		}
+		tx.TxIns[idx].Signature = signature
+	}
+	return nil
+}
+
+// SigHash returns the hash signed by every input: the transaction without its signatures,
+// bound to the chain ID of the current network.
+func (tx *Transaction) SigHash() []byte {
+	return getChainParams().SigHash([]byte(fmt.Sprintf("%x", tx.Clone())))
+}
+
+// signBytes signs the given data with the private key and returns `r || s`.
+func signBytes(privKey ecdsa.PrivateKey, data []byte) []byte {
+	// NOTE: Not yet fully understood!
+	// IDEA: a full signature was generated from a `rand` number, a buyer's `privKey`,
+	// and the corresponding data.
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, data)
+	if err != nil {
+		Error.Panic(err)
+	}
+
+	// Both halves are padded to the curve's size, so the signature can be split in the middle.
+	keySize := (privKey.Curve.Params().BitSize + 7) / 8
+	return append(r.FillBytes(make([]byte, keySize)), s.FillBytes(make([]byte, keySize))...)
+}
+
 // VerifySignature is a helper function that used to verify the
 // reliability of a transaction's signature.
 func (tx *Transaction) VerifySignature() bool {
-	clonedTx := tx.Clone()
-	curve := elliptic.P256()
-
-	for _, valIn := range clonedTx.TxIns {
-		pubKey := valIn.PubKey
-
-		// HACK: maybe we should use `big.Int{}` constructor instead?
-		// Expected values:
-		r := new(big.Int)
-		s := new(big.Int)
-		signLen := len(valIn.Signature)
-		r.SetBytes(valIn.Signature[:(signLen / 2)])
-		s.SetBytes(valIn.Signature[(signLen / 2):])
-
-		// Real signature values:
-		x := new(big.Int)
-		y := new(big.Int)
-		keyLen := len(pubKey) - 1
-		x.SetBytes(pubKey[1:(keyLen/2 + 1)])
-		y.SetBytes(pubKey[(keyLen/2 + 1):])
-
-		// Transaction data that needs to be verified.
-		verifyData := fmt.Sprintf("%x", clonedTx)
-
-		rawPubKey := ecdsa.PublicKey{
-			Curve: curve,
-			X:     x,
-			Y:     y,
-		}
-		if !ecdsa.Verify(&rawPubKey, []byte(verifyData), r, s) {
+	verifyData := tx.SigHash()
+
+	// NOTE: the signatures are read from the original inputs,
+	// the cloned ones were cleared to rebuild the signed data.
+	// The signature scheme is given by the type of each input's public key.
+	for _, valIn := range tx.TxIns {
+		if !verifyPubKeySig(valIn.PubKey, verifyData, valIn.Signature) {
 			return false
 		}
 	}
@@ -161,13 +191,16 @@ func (tx *Transaction) VerifySignature() bool {
 // VerifyValues have similarities in use with the signatures verification method.
 // But instead of verifying the signature itself, it checks the balancing between
 // the total amount of the stream inputs (TxIns) and outputs (TxOuts), from the start
-// until the current transaction.
+// until the current transaction. Inputs spending a missing output make the transaction invalid.
 func (tx *Transaction) VerifyValues(prevTxs map[string]Transaction) bool {
 	totalIns, totalOuts := 0, 0
 
 	for _, valIn := range tx.TxIns {
-		prevTx := prevTxs[hex.EncodeToString(valIn.TxID)]
-		totalIns = prevTx.TxOuts[valIn.TxOutIdx].Value
+		prevTx, ok := prevTxs[hex.EncodeToString(valIn.TxID)]
+		if !ok || valIn.TxOutIdx < 0 || valIn.TxOutIdx >= len(prevTx.TxOuts) {
+			return false
+		}
+		totalIns += prevTx.TxOuts[valIn.TxOutIdx].Value
 	}
 
 	for _, valOut := range tx.TxOuts {
@@ -191,16 +224,15 @@ func (tx Transaction) Serialize() []byte {
 	return encoded.Bytes()
 }
 
-func DeserializeTx(data []byte) *Transaction {
+func DeserializeTx(data []byte) (*Transaction, error) {
 	var tx Transaction
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&tx)
-	if err != nil {
-		Error.Panic(err)
+	if err := decoder.Decode(&tx); err != nil {
+		return nil, err
 	}
 
-	return &tx
+	return &tx, nil
 }
 
 func (tx Transaction) Stringify() string {
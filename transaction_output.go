@@ -32,9 +32,8 @@ func newTxOut(val int, addr string) *TxOutput {
 // LockTx depicts the progression of a transaction that is already
 // occupied by a buyer and identify by using their unique identifier hash.
 func (txOut *TxOutput) LockTx(addr string) {
-	decodeAddr := base58Decode([]byte(addr))
 	// @@@ FIXME: handles all cases addr := { localhost:3331, 3331 }
-	buyerHash := decodeAddr[1 : len(addr)-4]
+	buyerHash := addrPubKeyHash(addr)
 
 	// Locking a transaction with the buyer is PubKeyHash.
 	txOut.PubKeyHash = buyerHash
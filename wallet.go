@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
@@ -15,23 +16,28 @@ import (
 )
 
 const (
-	PUB_KEY_PREFIX    = byte(0x04)
-	NW_VERSION        = byte(0x00)
-	ADDR_CHECKSUM_LEN = 4
+	PUB_KEY_PREFIX        = byte(0x04)
+	ADDR_CHECKSUM_LEN     = 4
+	ADDR_PUB_KEY_HASH_LEN = 20 // Length of a RIPEMD-160 digest.
 )
 
 // Wallet contains a public-private keypair that can be used to identify itself.
 type Wallet struct {
-	PrivateKey ecdsa.PrivateKey
-	PublicKey  []byte
-	Address    string
+	KeyType      string             // Signature scheme of the key (see `key_type.go`).
+	PrivateKey   ecdsa.PrivateKey   // Key of the ECDSA types (P-256, secp256k1).
+	EdPrivateKey ed25519.PrivateKey // Key of the ed25519 type.
+	PublicKey    []byte
+	Address      string
+	WatchOnly    bool // No private key: the wallet can only be watched, not sign.
 }
 
 // WalletJson is used to store the Wallet data structure in the JSON file.
 type WalletJson struct {
-	PrivateKey string `json:"private_key"`
+	KeyType    string `json:"key_type,omitempty"` // Signature scheme of the key (empty = P-256).
+	PrivateKey string `json:"private_key,omitempty"`
 	PublicKey  string `json:"public_key"`
 	Address    string `json:"address"`
+	Keystore   string `json:"keystore,omitempty"` // Path to the encrypted keystore (replaces `PrivateKey`).
 }
 
 // Utility functions start from here.
@@ -50,6 +56,7 @@ func getWallet() *Wallet {
 func newWallet() *Wallet {
 	privKey, pubKey := newKeyPair()
 	wallet := Wallet{
+		KeyType:    KEY_TYPE_P256,
 		PrivateKey: privKey,
 		PublicKey:  pubKey,
 		Address:    genAddr(pubKey),
@@ -66,23 +73,43 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		Error.Panic(err)
 	}
-	pubKey := append(privKey.PublicKey.X.Bytes(), privKey.PublicKey.Y.Bytes()...)
+	return *privKey, encodePubKey(&privKey.PublicKey)
+}
+
+// importPrivKey returns the wallet owning the given hex-encoded private key of the key type.
+func importPrivKey(keyType, privKeyHex string) (*Wallet, error) {
+	privKeyAsBytes, err := hex.DecodeString(privKeyHex)
+	if err != nil {
+		return nil, err
+	}
+	return walletFromPrivKey(keyType, privKeyAsBytes)
+}
 
-	return *privKey, pubKey
+// findWalletByPubKey returns the wallet owning the given public key, or nil.
+func findWalletByPubKey(wallets []*Wallet, pubKey []byte) *Wallet {
+	for _, w := range wallets {
+		if bytes.Equal(w.PublicKey, pubKey) {
+			return w
+		}
+	}
+	return nil
 }
 
 /*
 Simple imitation schema for generating new `Address` in Bitcoin network (Pk := `PublicKey`)
 
 Schema:
-	. nwVersion
+	. nwVersion (+ key tag, if the key is not a P-256 one)
 	. ripemd160(sha256(Pk)) -> Pk_Hash
 	. sha256(sha256(nw_Version + Pk_Hash))[:4] -> checksum
 	--------------------------------------------------------------
 	base58Encode(nwVersion + Pk_hash + checksum) -> Wallet_Address
 */
 func genAddr(pubKey []byte) string {
-	version := []byte{NW_VERSION}
+	version := []byte{getChainParams().AddrVersion}
+	if keyTag := keyTagOf(keyTypeOf(pubKey)); keyTag != 0 {
+		version = append(version, keyTag)
+	}
 	pubKeyHash := hashPubKey(pubKey)
 	versionPayload := append(version, pubKeyHash...)
 	checksum := checksum(versionPayload)
@@ -112,6 +139,35 @@ func hashPubKey(pubKey []byte) []byte {
 	return pubRIPEMD160
 }
 
+// encodePubKey returns the raw `X || Y` public key, both coordinates padded to the curve's size
+// so that the key can be split in the middle.
+func encodePubKey(pubKey *ecdsa.PublicKey) []byte {
+	keySize := (pubKey.Curve.Params().BitSize + 7) / 8
+	return append(pubKey.X.FillBytes(make([]byte, keySize)), pubKey.Y.FillBytes(make([]byte, keySize))...)
+}
+
+// splitPubKey returns the (X, Y) coordinates of a raw P-256 public key, with or without
+// the leading `PUB_KEY_PREFIX` byte. The keys of older wallets were stored without padding,
+// so a shorter key is split where both coordinates make a point of the curve.
+func splitPubKey(pubKey []byte) (*big.Int, *big.Int) {
+	candidates := [][]byte{pubKey}
+	if len(pubKey)%2 == 1 && pubKey[0] == PUB_KEY_PREFIX {
+		candidates = [][]byte{pubKey[1:], pubKey}
+	}
+	curve := elliptic.P256()
+	keySize := (curve.Params().BitSize + 7) / 8
+	for _, key := range candidates {
+		for xLen := minVal(len(key), keySize); xLen >= len(key)-keySize && xLen > 0; xLen-- {
+			x, y := new(big.Int).SetBytes(key[:xLen]), new(big.Int).SetBytes(key[xLen:])
+			if curve.IsOnCurve(x, y) {
+				return x, y
+			}
+		}
+	}
+	half := len(candidates[0]) / 2
+	return new(big.Int).SetBytes(candidates[0][:half]), new(big.Int).SetBytes(candidates[0][half:])
+}
+
 // checksum returns the checksum of `PublicKey` after hashing through `sha256.Sum256()` twice.
 func checksum(hash []byte) []byte {
 	firstSHA := sha256.Sum256(hash)
@@ -121,25 +177,64 @@ func checksum(hash []byte) []byte {
 	return secondSHA[:ADDR_CHECKSUM_LEN]
 }
 
-// validateAddr checks if the wallet address is valid.
+// validateAddr checks if the wallet address is valid on the current network.
 func validateAddr(address string) bool {
 	payload := base58Decode([]byte(address))
+	switch len(payload) {
+	case 1 + ADDR_PUB_KEY_HASH_LEN + ADDR_CHECKSUM_LEN:
+	case 2 + ADDR_PUB_KEY_HASH_LEN + ADDR_CHECKSUM_LEN:
+		if payload[1] != KEY_TAG_SECP256K1 && payload[1] != KEY_TAG_ED25519 {
+			return false
+		}
+	default:
+		return false
+	}
 	actualChecksum := payload[len(payload)-ADDR_CHECKSUM_LEN:]
 
 	version := payload[0]
-	pubKeyHash := payload[1 : len(payload)-ADDR_CHECKSUM_LEN]
-	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
+	if version != getChainParams().AddrVersion {
+		return false
+	}
+	targetChecksum := checksum(payload[:len(payload)-ADDR_CHECKSUM_LEN])
 
 	// Checking the actual checksum versus the expected checksum value.
 	return bytes.Equal(actualChecksum, targetChecksum)
 }
 
+// addrPubKeyHash returns the public key hash an address was generated from.
+func addrPubKeyHash(address string) []byte {
+	payload := base58Decode([]byte(address))
+	if len(payload) < ADDR_PUB_KEY_HASH_LEN+ADDR_CHECKSUM_LEN {
+		return nil
+	}
+	return payload[len(payload)-ADDR_CHECKSUM_LEN-ADDR_PUB_KEY_HASH_LEN : len(payload)-ADDR_CHECKSUM_LEN]
+}
+
 // Wallet's methods:
 
+// SignData signs the given data with the wallet's key, whatever its type.
+func (w *Wallet) SignData(data []byte) ([]byte, error) {
+	if w.WatchOnly {
+		return nil, fmt.Errorf("%w: %s", ErrWatchOnly, w.Address)
+	}
+	if w.KeyType == KEY_TYPE_ED25519 {
+		return ed25519.Sign(w.EdPrivateKey, data), nil
+	}
+	return signBytes(w.PrivateKey, data), nil
+}
+
 // ToJson converts the `Wallet` instance to a JSON storage file.
 func (w *Wallet) ToJson() *WalletJson {
 	wJson := new(WalletJson)
-	wJson.PrivateKey = hex.EncodeToString(w.PrivateKey.D.Bytes())
+	if w.KeyType != KEY_TYPE_P256 {
+		wJson.KeyType = w.KeyType
+	}
+	if w.KeyType == KEY_TYPE_ED25519 {
+		wJson.PrivateKey = hex.EncodeToString(w.EdPrivateKey.Seed())
+	} else {
+		keySize := (w.PrivateKey.Curve.Params().BitSize + 7) / 8
+		wJson.PrivateKey = hex.EncodeToString(w.PrivateKey.D.FillBytes(make([]byte, keySize)))
+	}
 	wJson.PublicKey = hex.EncodeToString(w.PublicKey)
 	wJson.Address = w.Address
 	return wJson
@@ -158,12 +253,32 @@ func (w Wallet) Stringify() string {
 
 // ToWallet converts the JSON payload to a `Wallet` instance.
 func (wj *WalletJson) ToWallet() *Wallet {
-	w := new(Wallet)
-	curve := elliptic.P256()
+	keyType, err := normalizeKeyType(wj.KeyType)
+	if err != nil {
+		Error.Fatal(err)
+	}
+	if wj.PrivateKey == "" {
+		pubKey, err := hex.DecodeString(wj.PublicKey)
+		if err != nil {
+			Error.Fatal(err)
+		}
+		return &Wallet{KeyType: keyType, PublicKey: pubKey, Address: wj.Address, WatchOnly: true}
+	}
 	privKeyAsBytes, err := hex.DecodeString(wj.PrivateKey)
 	if err != nil {
 		Error.Fatal(err)
 	}
+	if keyType != KEY_TYPE_P256 {
+		w, err := walletFromPrivKey(keyType, privKeyAsBytes)
+		if err != nil {
+			Error.Fatal(err)
+		}
+		w.Address = wj.Address
+		return w
+	}
+
+	w := &Wallet{KeyType: keyType}
+	curve := elliptic.P256()
 
 	w.PrivateKey.D = new(big.Int).SetBytes(privKeyAsBytes)
 	w.PrivateKey.PublicKey.Curve = curve
@@ -177,6 +292,18 @@ func (wj *WalletJson) ToWallet() *Wallet {
 	return w
 }
 
+// Unlock returns the wallet's JSON holding the private key, decrypting
+// the keystore first if the wallet is locked.
+func (wj *WalletJson) Unlock() *WalletJson {
+	if wj.Keystore != "" {
+		return unlockKeystore(wj)
+	}
+	if wj.PrivateKey != "" {
+		Warning.Printf("Private key of %s is stored in plain text, run `wallet lock` to encrypt it.", wj.Address)
+	}
+	return wj
+}
+
 // Stringify returns a string representation for the given `WalletJson` instance.
 func (wj WalletJson) Stringify() string {
 	strWallet := "\n  ** Wallet Information ** \n"
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Signed messages prove the control of a wallet's address outside of any transaction.
+//
+// Schema:
+//	. sha256(sha256(MESSAGE_SIGN_DOMAIN || len(message) || message)) -> message hash
+//	. ECDSA(private key, message hash) -> r || s
+//	--------------------------------------------------------------------------
+//	base64(MESSAGE_SIGN_VERSION || X || Y || r || s) -> signature of a P-256 key
+//	base64(MESSAGE_SIGN_TAGGED || tagged public key || signature) -> signature of the other key types
+//
+// The public key (X, Y padded to the curve's size) is carried by the signature,
+// the verifier checks it hashes to the address.
+// The domain prefix makes a signed message unusable as a transaction's signature (and vice versa).
+
+const (
+	MESSAGE_SIGN_VERSION = byte(0x01)
+	MESSAGE_SIGN_TAGGED  = byte(0x02)
+	MESSAGE_SIGN_DOMAIN  = "PDP Signed Message:\n"
+)
+
+var ErrInvalidMsgSignature = errors.New("invalid message signature")
+
+// Utility functions start from here.
+
+// hashWalletMessage returns the domain-separated hash of the given message.
+func hashWalletMessage(message string) []byte {
+	var msgLen [4]byte
+	binary.BigEndian.PutUint32(msgLen[:], uint32(len(message)))
+
+	data := append([]byte(MESSAGE_SIGN_DOMAIN), msgLen[:]...)
+	data = append(data, message...)
+	firstSHA := sha256.Sum256(data)
+	secondSHA := sha256.Sum256(firstSHA[:])
+	return secondSHA[:]
+}
+
+// signWalletMessage signs the message with the wallet's private key and returns the base64 signature.
+func signWalletMessage(w *Wallet, message string) (string, error) {
+	signature, err := w.SignData(hashWalletMessage(message))
+	if err != nil {
+		return "", err
+	}
+	if w.KeyType != KEY_TYPE_P256 {
+		sig := append([]byte{MESSAGE_SIGN_TAGGED}, w.PublicKey...)
+		return base64.StdEncoding.EncodeToString(append(sig, signature...)), nil
+	}
+
+	keySize := (w.PrivateKey.Curve.Params().BitSize + 7) / 8
+	sig := append([]byte{MESSAGE_SIGN_VERSION}, w.PrivateKey.X.FillBytes(make([]byte, keySize))...)
+	sig = append(sig, w.PrivateKey.Y.FillBytes(make([]byte, keySize))...)
+	return base64.StdEncoding.EncodeToString(append(sig, signature...)), nil
+}
+
+// verifyWalletMessage checks that the message was signed by the owner of the given address.
+func verifyWalletMessage(address, signature, message string) error {
+	if !validateAddr(address) {
+		return fmt.Errorf("invalid address %s on network %s", address, getChainParams().Name)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMsgSignature, err)
+	}
+	if len(sig) > 0 && sig[0] == MESSAGE_SIGN_TAGGED {
+		return verifyTaggedMessage(address, sig[1:], message)
+	}
+
+	keySize := (elliptic.P256().Params().BitSize + 7) / 8
+	if len(sig) != 1+4*keySize || sig[0] != MESSAGE_SIGN_VERSION {
+		return ErrInvalidMsgSignature
+	}
+	x := new(big.Int).SetBytes(sig[1 : 1+keySize])
+	y := new(big.Int).SetBytes(sig[1+keySize : 1+2*keySize])
+	rs := sig[1+2*keySize:]
+
+	// The public key must be the one the address was generated from (see `newKeyPair`),
+	// addresses of older wallets being generated from the unpadded coordinates.
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	pubKeyHash := addrPubKeyHash(address)
+	if !bytes.Equal(hashPubKey(encodePubKey(&rawPubKey)), pubKeyHash) &&
+		!bytes.Equal(hashPubKey(append(x.Bytes(), y.Bytes()...)), pubKeyHash) {
+		return fmt.Errorf("%w: signed by another key than %s", ErrInvalidMsgSignature, address)
+	}
+
+	r := new(big.Int).SetBytes(rs[:keySize])
+	s := new(big.Int).SetBytes(rs[keySize:])
+	if !ecdsa.Verify(&rawPubKey, hashWalletMessage(message), r, s) {
+		return ErrInvalidMsgSignature
+	}
+	return nil
+}
+
+// verifyTaggedMessage checks a signature made by a key of a tagged type (secp256k1, ed25519).
+func verifyTaggedMessage(address string, sig []byte, message string) error {
+	var pubKeyLen int
+	switch {
+	case len(sig) > 0 && sig[0] == KEY_TAG_SECP256K1:
+		pubKeyLen = 1 + 64
+	case len(sig) > 0 && sig[0] == KEY_TAG_ED25519:
+		pubKeyLen = 1 + ed25519.PublicKeySize
+	default:
+		return ErrInvalidMsgSignature
+	}
+	if len(sig) <= pubKeyLen {
+		return ErrInvalidMsgSignature
+	}
+
+	pubKey := sig[:pubKeyLen]
+	if !bytes.Equal(hashPubKey(pubKey), addrPubKeyHash(address)) {
+		return fmt.Errorf("%w: signed by another key than %s", ErrInvalidMsgSignature, address)
+	}
+	if !verifyPubKeySig(pubKey, hashWalletMessage(message), sig[pubKeyLen:]) {
+		return ErrInvalidMsgSignature
+	}
+	return nil
+}
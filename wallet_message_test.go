@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWalletMessageSignature(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	owner, other := newWallet(), newWallet()
+	message := "register storage provider"
+
+	signature, err := signWalletMessage(owner, message)
+	if err != nil {
+		t.Fatalf("signWalletMessage failed: %v", err)
+	}
+	if err := verifyWalletMessage(owner.Address, signature, message); err != nil {
+		t.Fatalf("Valid signature rejected: %v", err)
+	}
+	if err := verifyWalletMessage(owner.Address, signature, message+"!"); !errors.Is(err, ErrInvalidMsgSignature) {
+		t.Errorf("Tampered message should be rejected, got %v", err)
+	}
+	if err := verifyWalletMessage(other.Address, signature, message); !errors.Is(err, ErrInvalidMsgSignature) {
+		t.Errorf("Signature of another address should be rejected, got %v", err)
+	}
+
+}
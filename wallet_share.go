@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Backup shares of a private key (or of the HD seed): K-of-N Shamir shares (see `shamir.go`)
+// to be kept in distinct places, any K of them rebuilding the secret.
+//
+// Schema:
+//	. secret || sha256(secret)[:4] -> shared data (its digest checks the rebuilt secret)
+//	--------------------------------------------------------------------------
+//	base58(SHARE_VERSION || kind || key tag || group ID || threshold || x || data || checksum) -> share
+//
+// The group ID (random) ties the shares of one split together,
+// the checksum catches the shares mistyped or damaged.
+
+const (
+	SHARE_VERSION    = byte(0x01)
+	SHARE_KIND_KEY   = byte(0x00) // Private key of one wallet.
+	SHARE_KIND_SEED  = byte(0x01) // Seed of the HD wallet.
+	SHARE_GROUP_LEN  = 4
+	SHARE_DIGEST_LEN = 4
+	SHARE_HEADER_LEN = 1 + 1 + 1 + SHARE_GROUP_LEN + 1 + 1
+	SHARE_DIR        = "shares" // Default directory of the share files (not under `config/`, see `readNwCfgPath`).
+)
+
+var ErrInvalidShare = errors.New("invalid share")
+
+// WalletShare is one share of a backed up secret.
+type WalletShare struct {
+	Kind      byte   // SHARE_KIND_KEY or SHARE_KIND_SEED.
+	KeyTag    byte   // Key type of the private key (see `keyTagOf`).
+	Group     []byte // Identifier shared by the shares of one split.
+	Threshold int    // Number of shares needed to rebuild the secret.
+	X         byte   // Index of the share (1..N).
+	Data      []byte // Share of `secret || digest`.
+}
+
+// Utility functions start from here.
+
+// splitWalletSecret splits the secret into `n` shares, `threshold` of them being needed to rebuild it.
+func splitWalletSecret(kind, keyTag byte, secret []byte, n, threshold int) ([]WalletShare, error) {
+	group := make([]byte, SHARE_GROUP_LEN)
+	if _, err := rand.Read(group); err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(secret)
+	data, err := shamirSplit(append(append([]byte{}, secret...), digest[:SHARE_DIGEST_LEN]...), n, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([]WalletShare, n)
+	for idx := range shares {
+		shares[idx] = WalletShare{
+			Kind:      kind,
+			KeyTag:    keyTag,
+			Group:     group,
+			Threshold: threshold,
+			X:         byte(idx + 1),
+			Data:      data[idx],
+		}
+	}
+	return shares, nil
+}
+
+// combineWalletShares rebuilds the secret of the given shares, which must come from the same split.
+func combineWalletShares(shares []WalletShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrNotEnoughShares
+	}
+	first := shares[0]
+	var xs []byte
+	var data [][]byte
+	for _, share := range shares {
+		if share.Kind != first.Kind || share.KeyTag != first.KeyTag || share.Threshold != first.Threshold ||
+			!bytes.Equal(share.Group, first.Group) {
+			return nil, fmt.Errorf("%w: share %d does not belong to the same backup", ErrInvalidShare, share.X)
+		}
+		xs = append(xs, share.X)
+		data = append(data, share.Data)
+	}
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("%w: %d of %d", ErrNotEnoughShares, len(shares), first.Threshold)
+	}
+
+	// Extra shares are not needed, and the first ones are as good as any.
+	combined, err := shamirCombine(xs[:first.Threshold], data[:first.Threshold])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidShare, err)
+	}
+	if len(combined) <= SHARE_DIGEST_LEN {
+		return nil, ErrInvalidShare
+	}
+	secret := combined[:len(combined)-SHARE_DIGEST_LEN]
+	digest := sha256.Sum256(secret)
+	if !bytes.Equal(digest[:SHARE_DIGEST_LEN], combined[len(combined)-SHARE_DIGEST_LEN:]) {
+		return nil, fmt.Errorf("%w: the rebuilt secret does not match its digest", ErrInvalidShare)
+	}
+	return secret, nil
+}
+
+// decodeWalletShare parses a base58-encoded share, checking its checksum.
+func decodeWalletShare(encoded string) (*WalletShare, error) {
+	payload := base58Decode([]byte(strings.TrimSpace(encoded)))
+	if len(payload) <= SHARE_HEADER_LEN+ADDR_CHECKSUM_LEN {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidShare)
+	}
+	body := payload[:len(payload)-ADDR_CHECKSUM_LEN]
+	if !bytes.Equal(checksum(body), payload[len(payload)-ADDR_CHECKSUM_LEN:]) {
+		return nil, fmt.Errorf("%w: bad checksum", ErrInvalidShare)
+	}
+	if body[0] != SHARE_VERSION {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrInvalidShare, body[0])
+	}
+
+	share := &WalletShare{
+		Kind:      body[1],
+		KeyTag:    body[2],
+		Group:     body[3 : 3+SHARE_GROUP_LEN],
+		Threshold: int(body[3+SHARE_GROUP_LEN]),
+		X:         body[4+SHARE_GROUP_LEN],
+		Data:      body[SHARE_HEADER_LEN:],
+	}
+	if share.X == 0 || share.Threshold == 0 {
+		return nil, fmt.Errorf("%w: bad index", ErrInvalidShare)
+	}
+	return share, nil
+}
+
+// readWalletShare returns the share stored in the given file, or the given text itself.
+func readWalletShare(ref string) (*WalletShare, error) {
+	if content, err := ioutil.ReadFile(ref); err == nil {
+		ref = string(content)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return decodeWalletShare(ref)
+}
+
+// keyTypeOfTag returns the key type of the given key tag (see `keyTagOf`).
+func keyTypeOfTag(tag byte) string {
+	for _, keyType := range []string{KEY_TYPE_SECP256K1, KEY_TYPE_ED25519} {
+		if keyTagOf(keyType) == tag {
+			return keyType
+		}
+	}
+	return KEY_TYPE_P256
+}
+
+// WalletShare's methods:
+
+// Encode returns the base58 text of the share.
+func (share *WalletShare) Encode() string {
+	body := []byte{SHARE_VERSION, share.Kind, share.KeyTag}
+	body = append(body, share.Group...)
+	body = append(body, byte(share.Threshold), share.X)
+	body = append(body, share.Data...)
+	return string(base58Encode(append(body, checksum(body)...)))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestShamirAnyThresholdShares(t *testing.T) {
+	secret := []byte("cloud reliability operator key!!")
+	shares, err := shamirSplit(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("shamirSplit failed: %v", err)
+	}
+
+	// Every subset of 3 shares rebuilds the secret.
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for c := b + 1; c < 5; c++ {
+				xs := []byte{byte(a + 1), byte(b + 1), byte(c + 1)}
+				combined, err := shamirCombine(xs, [][]byte{shares[a], shares[b], shares[c]})
+				if err != nil || !bytes.Equal(combined, secret) {
+					t.Errorf("Shares %v rebuilt %q (%v)", xs, combined, err)
+				}
+			}
+		}
+	}
+	if _, err := shamirSplit(secret, 2, 3); err == nil {
+		t.Errorf("Threshold above the number of shares should be rejected")
+	}
+}
+
+func TestWalletShares(t *testing.T) {
+	w, _ := newWalletOf(KEY_TYPE_SECP256K1)
+	privKey := w.PrivateKey.D.FillBytes(make([]byte, 32))
+	shares, err := splitWalletSecret(SHARE_KIND_KEY, keyTagOf(w.KeyType), privKey, 5, 3)
+	if err != nil {
+		t.Fatalf("splitWalletSecret failed: %v", err)
+	}
+
+	var decoded []WalletShare
+	for _, idx := range []int{4, 1, 2} {
+		share, err := decodeWalletShare(shares[idx].Encode())
+		if err != nil {
+			t.Fatalf("decodeWalletShare failed: %v", err)
+		}
+		decoded = append(decoded, *share)
+	}
+	secret, err := combineWalletShares(decoded)
+	if err != nil {
+		t.Fatalf("combineWalletShares failed: %v", err)
+	}
+	rebuilt, _ := walletFromPrivKey(keyTypeOfTag(decoded[0].KeyTag), secret)
+	if rebuilt.Address != w.Address {
+		t.Errorf("Expected wallet %s, got %s", w.Address, rebuilt.Address)
+	}
+
+	// Insufficient shares.
+	if _, err := combineWalletShares(decoded[:2]); !errors.Is(err, ErrNotEnoughShares) {
+		t.Errorf("Two shares of a 3-of-5 backup should not be enough, got %v", err)
+	}
+
+	// A damaged share fails its checksum.
+	encoded := []byte(shares[0].Encode())
+	if encoded[10] == '2' {
+		encoded[10] = '3'
+	} else {
+		encoded[10] = '2'
+	}
+	if _, err := decodeWalletShare(string(encoded)); !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("Damaged share should be rejected, got %v", err)
+	}
+
+	// A tampered share with a valid checksum rebuilds a secret which fails its digest.
+	tampered := decoded[0]
+	tampered.Data = append([]byte{}, tampered.Data...)
+	tampered.Data[0] ^= 0x01
+	reencoded, err := decodeWalletShare(tampered.Encode())
+	if err != nil {
+		t.Fatalf("decodeWalletShare failed: %v", err)
+	}
+	if _, err := combineWalletShares([]WalletShare{*reencoded, decoded[1], decoded[2]}); !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("Tampered share should be rejected, got %v", err)
+	}
+
+	// Shares of distinct backups cannot be mixed.
+	others, _ := splitWalletSecret(SHARE_KIND_KEY, keyTagOf(w.KeyType), privKey, 5, 3)
+	if _, err := combineWalletShares([]WalletShare{others[0], decoded[1], decoded[2]}); !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("Shares of another backup should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The wallet store keeps every key of a node (with a label and a creation date)
+// in `config/<node>/wallets.json`. The `wallet` section of `config.json` stays
+// the default wallet of the node, the one receiving the mining rewards.
+
+const (
+	WALLET_STORE_VERSION = 1
+	WALLET_STORE_FILE    = "wallets.json"
+	DEFAULT_WALLET_LABEL = "default"
+	HD_SEED_LABEL        = "hd-seed"
+)
+
+// WalletEntry is one key of the wallet store.
+type WalletEntry struct {
+	Label     string     `json:"label"`                // Name given by the user.
+	CreatedAt int64      `json:"created_at"`           // Unix timestamp the key was created (or imported) at.
+	Wallet    WalletJson `json:"wallet"`               // Key itself, possibly locked in a keystore.
+	Path      string     `json:"path,omitempty"`       // Derivation path of an HD key (its private key is not stored).
+	WatchOnly bool       `json:"watch_only,omitempty"` // Address (or public key) watched without private key.
+}
+
+// WalletStore is the file format holding all the keys of a node.
+type WalletStore struct {
+	Version int           `json:"version"`
+	Default string        `json:"default"` // Address of the default wallet.
+	Entries []WalletEntry `json:"entries"`
+	HD      *HDChain      `json:"hd,omitempty"` // Seed of the HD keys, if any.
+
+	path string // File the store was loaded from.
+}
+
+// Utility functions start from here.
+
+// openWalletStore loads the wallet store stored next to the given config file.
+// If it does not exist yet, a new store is created containing the config's wallet.
+func openWalletStore(cfgPath string, cfg *Config) *WalletStore {
+	storePath := walletStorePath(resolveCfgPath(cfgPath))
+	ws := &WalletStore{Version: WALLET_STORE_VERSION, path: storePath}
+
+	contents, err := ioutil.ReadFile(storePath)
+	if errors.Is(err, os.ErrNotExist) {
+		if cfg.WJson.Address != "" {
+			ws.Entries = append(ws.Entries, WalletEntry{
+				Label:     DEFAULT_WALLET_LABEL,
+				CreatedAt: time.Now().Unix(),
+				Wallet:    cfg.WJson,
+			})
+			ws.Default = cfg.WJson.Address
+		}
+		return ws
+	}
+	if err != nil {
+		Error.Printf("Cannot read wallet store %s: %v", storePath, err)
+		os.Exit(1)
+	}
+
+	if err = json.Unmarshal(contents, ws); err != nil {
+		Error.Printf("Cannot parse wallet store %s: %v", storePath, err)
+		os.Exit(1)
+	}
+	if ws.Version != WALLET_STORE_VERSION {
+		Error.Printf("Unsupported wallet store version %d", ws.Version)
+		os.Exit(1)
+	}
+	return ws
+}
+
+// walletStorePath returns the path of the wallet store next to the given config file.
+func walletStorePath(cfgFilePath string) string {
+	return filepath.ToSlash(filepath.Join(filepath.Dir(cfgFilePath), WALLET_STORE_FILE))
+}
+
+// WalletStore's methods:
+
+// Add stores a new key under the given label.
+func (ws *WalletStore) Add(label string, wj WalletJson) (*WalletEntry, error) {
+	if label == "" {
+		label = wj.Address
+	}
+	if _, ok := ws.Find(wj.Address); ok {
+		return nil, fmt.Errorf("wallet %s already exists", wj.Address)
+	}
+	if _, ok := ws.Find(label); ok {
+		return nil, fmt.Errorf("label %q is already used", label)
+	}
+
+	ws.Entries = append(ws.Entries, WalletEntry{Label: label, CreatedAt: time.Now().Unix(), Wallet: wj})
+	if ws.Default == "" {
+		ws.Default = wj.Address
+	}
+	return &ws.Entries[len(ws.Entries)-1], nil
+}
+
+// Put replaces the stored key having the same address (eg: after locking it).
+func (ws *WalletStore) Put(wj WalletJson) {
+	if entry, ok := ws.Find(wj.Address); ok {
+		entry.Wallet = wj
+	}
+}
+
+// Find returns the entry matching the given address or label.
+func (ws *WalletStore) Find(ref string) (*WalletEntry, bool) {
+	for idx, entry := range ws.Entries {
+		if entry.Wallet.Address == ref || entry.Label == ref {
+			return &ws.Entries[idx], true
+		}
+	}
+	return nil, false
+}
+
+// Sorted returns the entries ordered by creation date.
+func (ws *WalletStore) Sorted() []WalletEntry {
+	entries := append([]WalletEntry{}, ws.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt < entries[j].CreatedAt
+	})
+	return entries
+}
+
+// Save writes the store back to its file, readable by the owner only.
+func (ws *WalletStore) Save() {
+	prettyMarshal, err := json.MarshalIndent(ws, "", "  ")
+	if err != nil {
+		Error.Println(err.Error())
+		os.Exit(1)
+	}
+	if err = ioutil.WriteFile(ws.path, prettyMarshal, 0600); err != nil {
+		Error.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+// Unlock returns the usable wallets of the given addresses or labels, each wallet once.
+func (ws *WalletStore) Unlock(refs []string) ([]*Wallet, error) {
+	var wallets []*Wallet
+	unlocked := make(map[string]bool)
+	for _, ref := range refs {
+		entry, ok := ws.Find(ref)
+		if !ok {
+			return nil, fmt.Errorf("unknown wallet %q", ref)
+		}
+		if entry.WatchOnly {
+			return nil, fmt.Errorf("%w: %s", ErrWatchOnly, ref)
+		}
+		if unlocked[entry.Wallet.Address] {
+			continue
+		}
+		unlocked[entry.Wallet.Address] = true
+		wallets = append(wallets, ws.Wallet(entry))
+	}
+	return wallets, nil
+}
+
+// Wallet returns the usable wallet of the given entry, deriving it from the HD seed if needed.
+func (ws *WalletStore) Wallet(entry *WalletEntry) *Wallet {
+	if entry.WatchOnly {
+		return entry.Wallet.ToWallet()
+	}
+	if entry.Path == "" {
+		return entry.Wallet.Unlock().ToWallet()
+	}
+	if ws.HD == nil {
+		Error.Printf("Wallet %s is derived but the store has no HD seed!", entry.Wallet.Address)
+		os.Exit(1)
+	}
+
+	w, err := deriveHDWallet(ws.HD.Unlock(), entry.Path)
+	if err != nil || w.Address != entry.Wallet.Address {
+		Error.Printf("Cannot derive wallet %s from %s: %v", entry.Wallet.Address, entry.Path, err)
+		os.Exit(1)
+	}
+	return w
+}
+
+// InitHD stores the seed of the HD keys, encrypted in the keystore `ksPath` if a passphrase is given.
+func (ws *WalletStore) InitHD(seed []byte, account uint32, ksPath string, passphrase []byte) error {
+	if ws.HD != nil {
+		return errors.New("HD seed is already initialized")
+	}
+
+	hd := &HDChain{Seed: hex.EncodeToString(seed), Account: account, seed: seed}
+	if passphrase != nil {
+		// The seed is sealed the same way as a private key.
+		seedJson := &WalletJson{Address: HD_SEED_LABEL, PrivateKey: hd.Seed}
+		if err := lockWalletJson(seedJson, ksPath, passphrase); err != nil {
+			return err
+		}
+		hd.Seed = ""
+		hd.Keystore = seedJson.Keystore
+	}
+	ws.HD = hd
+	return nil
+}
+
+// AddHD derives the next unused key of the given chain (external or change) into the store.
+func (ws *WalletStore) AddHD(chain uint32, label string) (*WalletEntry, *Wallet, error) {
+	if ws.HD == nil {
+		return nil, nil, errors.New("HD seed is not initialized, run `wallet hd-init` first")
+	}
+
+	if label == "" {
+		label = fmt.Sprintf("%s-%d", hdChainNames[chain], ws.HD.Next[chain])
+	}
+	path, w := ws.HD.Derive(chain, ws.HD.Next[chain])
+	entry, err := ws.Add(label, WalletJson{PublicKey: hex.EncodeToString(w.PublicKey), Address: w.Address})
+	if err != nil {
+		return nil, nil, err
+	}
+	entry.Path = path
+	ws.HD.Next[chain]++
+	return entry, w, nil
+}
+
+// AddWatch stores a watch-only entry of the given address, or of the given public key.
+func (ws *WalletStore) AddWatch(label, address string, pubKey []byte) (*WalletEntry, error) {
+	wj := WalletJson{Address: address}
+	if pubKey != nil {
+		wj.Address = genAddr(pubKey)
+		wj.PublicKey = hex.EncodeToString(pubKey)
+		if keyType := keyTypeOf(pubKey); keyType != KEY_TYPE_P256 {
+			wj.KeyType = keyType
+		}
+		if address != "" && address != wj.Address {
+			return nil, fmt.Errorf("public key does not match the address %s", address)
+		}
+	}
+	if !validateAddr(wj.Address) {
+		return nil, fmt.Errorf("invalid address %s on network %s", wj.Address, getChainParams().Name)
+	}
+
+	entry, err := ws.Add(label, wj)
+	if err != nil {
+		return nil, err
+	}
+	entry.WatchOnly = true
+	if ws.Default == entry.Wallet.Address {
+		ws.Default = "" // A watch-only entry never becomes the node's wallet.
+	}
+	return entry, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestWalletStoreLabels(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	ws := &WalletStore{Version: WALLET_STORE_VERSION}
+	first, second := newWallet(), newWallet()
+
+	if _, err := ws.Add("savings", *first.ToJson()); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if _, err := ws.Add("savings", *second.ToJson()); err == nil {
+		t.Errorf("Duplicated label should be rejected!")
+	}
+	if _, err := ws.Add("other", *first.ToJson()); err == nil {
+		t.Errorf("Duplicated address should be rejected!")
+	}
+	if ws.Default != first.Address {
+		t.Errorf("First stored wallet should become the default one!")
+	}
+	if entry, ok := ws.Find("savings"); !ok || entry.Wallet.Address != first.Address {
+		t.Errorf("Wallet not found by its label!")
+	}
+	if wallets, err := ws.Unlock([]string{"savings", "savings", first.Address}); err != nil || len(wallets) != 1 {
+		t.Errorf("A wallet given several times should be unlocked once, got %d: %v", len(wallets), err)
+	}
+}
+
+func TestSignWithSeveralWallets(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	first, second, receiver := newWallet(), newWallet(), newWallet()
+	tx := &Transaction{
+		TxIns: []TxInput{
+			{TxID: []byte("prev-1"), TxOutIdx: 0, PubKey: first.PublicKey},
+			{TxID: []byte("prev-2"), TxOutIdx: 1, PubKey: second.PublicKey},
+		},
+		TxOuts: []TxOutput{*newTxOut(5, receiver.Address)},
+	}
+	tx.ID = tx.HashTx()
+
+	if err := tx.SignWith([]*Wallet{first}); err == nil {
+		t.Errorf("Signing without the owner of every input should fail!")
+	}
+	if err := tx.SignWith([]*Wallet{second, first}); err != nil {
+		t.Fatalf("SignWith failed: %v", err)
+	}
+	if !tx.VerifySignature() {
+		t.Errorf("Transaction signed by several wallets does not verify!")
+	}
+}
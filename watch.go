@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Watch-only wallets: the addresses of the wallet store added without any private key,
+// to follow their balances and payments (eg: on monitoring hosts).
+// Every block added to the local chain is scanned for them, each payment received or spent
+// is appended to `config/<node>/watch-events.log` and given to the registered callbacks.
+
+const (
+	WATCH_EVENT_RECEIVE = "receive"
+	WATCH_EVENT_SPEND   = "spend"
+	WATCH_LOG_FILE      = "watch-events.log"
+)
+
+var ErrWatchOnly = errors.New("wallet is watch-only, it cannot sign")
+
+// WatchEvent is one payment received or spent by a watched address.
+type WatchEvent struct {
+	Type      string `json:"type"`      // WATCH_EVENT_RECEIVE or WATCH_EVENT_SPEND.
+	Address   string `json:"address"`   // Watched address.
+	TxID      string `json:"tx_id"`     // Transaction paying or spending.
+	Value     int    `json:"value"`     // Amount received or spent.
+	Depth     int    `json:"depth"`     // Depth of the block containing the transaction.
+	Timestamp int64  `json:"timestamp"` // Timestamp of that block.
+}
+
+// Watcher scans the new blocks for the watched addresses.
+type Watcher struct {
+	mu        sync.Mutex
+	addrs     map[string]string // Hex public key hash -> address.
+	logPath   string            // Event log file (empty = no log).
+	callbacks []func(WatchEvent)
+}
+
+// Watcher of the running node (nil = nothing is watched).
+var watcher *Watcher
+
+// Utility functions start from here.
+
+func setWatcher(w *Watcher) {
+	watcher = w
+}
+
+func getWatcher() *Watcher {
+	return watcher
+}
+
+// newWatcher returns a watcher of the given addresses, logging its events to `logPath`.
+func newWatcher(addresses []string, logPath string) *Watcher {
+	return &Watcher{addrs: watchedHashes(addresses), logPath: logPath}
+}
+
+// watchLogPath returns the path of the event log next to the given config file.
+func watchLogPath(cfgFilePath string) string {
+	return filepath.ToSlash(filepath.Join(filepath.Dir(cfgFilePath), WATCH_LOG_FILE))
+}
+
+// startWatcher watches the watch-only entries of the node's wallet store, if any.
+func startWatcher(cfgPath string) {
+	ws := openWalletStore(cfgPath, getNetworkCfg())
+	var addresses []string
+	for _, entry := range ws.Entries {
+		if entry.WatchOnly {
+			addresses = append(addresses, entry.Wallet.Address)
+		}
+	}
+	if len(addresses) == 0 {
+		return
+	}
+
+	w := newWatcher(addresses, watchLogPath(resolveCfgPath(cfgPath)))
+	w.OnEvent(func(event WatchEvent) {
+		Info.Printf("Watched address %s: %s %d coins in transaction %s", event.Address, event.Type, event.Value, event.TxID)
+	})
+	setWatcher(w)
+	Info.Printf("Watching %d address(es), events are logged to %s", len(addresses), w.logPath)
+}
+
+// watchedHashes maps the public key hashes of the given addresses to them.
+func watchedHashes(addresses []string) map[string]string {
+	hashes := make(map[string]string)
+	for _, address := range addresses {
+		hashes[hex.EncodeToString(addrPubKeyHash(address))] = address
+	}
+	return hashes
+}
+
+// blockWatchEvents returns the payments of the block involving the watched public key hashes.
+// `prevOut` returns the output spent by an input (to know the amount spent).
+func blockWatchEvents(block *Block, watched map[string]string, prevOut func(TxInput) (TxOutput, bool)) []WatchEvent {
+	var events []WatchEvent
+	newEvent := func(eventType, address string, tx Transaction, value int) {
+		events = append(events, WatchEvent{
+			Type:      eventType,
+			Address:   address,
+			TxID:      hex.EncodeToString(tx.ID),
+			Value:     value,
+			Depth:     block.Header.Depth,
+			Timestamp: block.Header.Timestamp,
+		})
+	}
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, txIn := range tx.TxIns {
+				address, ok := watched[hex.EncodeToString(hashPubKey(txIn.PubKey))]
+				if !ok {
+					continue
+				}
+				if txOut, found := prevOut(txIn); found {
+					newEvent(WATCH_EVENT_SPEND, address, tx, txOut.Value)
+				}
+			}
+		}
+		for _, txOut := range tx.TxOuts {
+			if address, ok := watched[hex.EncodeToString(txOut.PubKeyHash)]; ok {
+				newEvent(WATCH_EVENT_RECEIVE, address, tx, txOut.Value)
+			}
+		}
+	}
+	return events
+}
+
+// Watcher's methods:
+
+// OnEvent registers a callback invoked with every new event.
+func (w *Watcher) OnEvent(callback func(WatchEvent)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callbacks = append(w.callbacks, callback)
+}
+
+// Notify reports the events of a block just added to the chain.
+func (w *Watcher) Notify(bc *Blockchain, block *Block) {
+	if w == nil {
+		return
+	}
+
+	// Spent outputs are looked for in the block itself first, then in the chain.
+	prevOut := func(txIn TxInput) (TxOutput, bool) {
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, txIn.TxID) && txIn.TxOutIdx >= 0 && txIn.TxOutIdx < len(tx.TxOuts) {
+				return tx.TxOuts[txIn.TxOutIdx], true
+			}
+		}
+		prevTx, err := bc.FindTxByID(txIn.TxID)
+		if err != nil || txIn.TxOutIdx < 0 || txIn.TxOutIdx >= len(prevTx.TxOuts) {
+			return TxOutput{}, false
+		}
+		return prevTx.TxOuts[txIn.TxOutIdx], true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, event := range blockWatchEvents(block, w.addrs, prevOut) {
+		if err := w.log(event); err != nil {
+			Warning.Printf("Cannot log watch event: %v", err)
+		}
+		for _, callback := range w.callbacks {
+			callback(event)
+		}
+	}
+}
+
+// log appends the event to the log file as one JSON line.
+func (w *Watcher) log(event WatchEvent) error {
+	if w.logPath == "" {
+		return nil
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(w.logPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// WatchEvent's methods:
+
+// Stringify returns one line describing the event.
+func (event WatchEvent) Stringify() string {
+	sign := "+"
+	if event.Type == WATCH_EVENT_SPEND {
+		sign = "-"
+	}
+	createdAt := time.Unix(event.Timestamp, 0).Format("2006-01-02 15:04:05")
+	return fmt.Sprintf("%s  depth %-6d %s%-8d %s  %s", createdAt, event.Depth, sign, event.Value, event.Address, event.TxID)
+}
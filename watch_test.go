@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBlockWatchEvents(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	watched, other := newWallet(), newWallet()
+
+	funding := newCoinBaseTx(watched.Address)
+	spending := Transaction{
+		TxIns:  []TxInput{{TxID: funding.ID, TxOutIdx: 0, PubKey: watched.PublicKey}},
+		TxOuts: []TxOutput{*newTxOut(600, other.Address), *newTxOut(400, watched.Address)},
+	}
+	spending.ID = spending.HashTx()
+	block := &Block{Header: Header{Depth: 2}, Transactions: []Transaction{*funding, spending}}
+
+	var notified []WatchEvent
+	w := newWatcher([]string{watched.Address}, "")
+	w.OnEvent(func(event WatchEvent) { notified = append(notified, event) })
+	w.Notify(nil, block)
+
+	expected := []WatchEvent{
+		{Type: WATCH_EVENT_RECEIVE, Value: SUBSIDY, TxID: hex.EncodeToString(funding.ID)},
+		{Type: WATCH_EVENT_SPEND, Value: SUBSIDY, TxID: hex.EncodeToString(spending.ID)},
+		{Type: WATCH_EVENT_RECEIVE, Value: 400, TxID: hex.EncodeToString(spending.ID)},
+	}
+	if len(notified) != len(expected) {
+		t.Fatalf("Expected %d events, got %v", len(expected), notified)
+	}
+	for idx, event := range notified {
+		if event.Type != expected[idx].Type || event.Value != expected[idx].Value ||
+			event.TxID != expected[idx].TxID || event.Address != watched.Address || event.Depth != 2 {
+			t.Errorf("Event %d: expected %+v, got %+v", idx, expected[idx], event)
+		}
+	}
+}
+
+func TestWatchMissingOutput(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	watched := newWallet()
+	bc := newTestChain(t, "chain")
+	bc.AddBlock(getChainParams().GenesisBlock())
+
+	// Blocks received from peers may spend outputs that do not exist.
+	funding := newCoinBaseTx(newWallet().Address)
+	var spendings []Transaction
+	for _, txOutIdx := range []int{-1, len(funding.TxOuts)} {
+		spending := Transaction{
+			TxIns:  []TxInput{{TxID: funding.ID, TxOutIdx: txOutIdx, PubKey: watched.PublicKey}},
+			TxOuts: []TxOutput{*newTxOut(1, newWallet().Address)},
+		}
+		spending.ID = spending.HashTx()
+		spendings = append(spendings, spending)
+	}
+	block := &Block{Header: Header{Depth: 2}, Transactions: append([]Transaction{*funding}, spendings...)}
+
+	var notified []WatchEvent
+	w := newWatcher([]string{watched.Address}, "")
+	w.OnEvent(func(event WatchEvent) { notified = append(notified, event) })
+	w.Notify(bc, block)
+	if len(notified) != 0 {
+		t.Errorf("Spending a missing output should not be reported, got %v", notified)
+	}
+}
+
+func TestWatchOnlyWallet(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	owner := newWallet()
+	edOwner, _ := newWalletOf(KEY_TYPE_ED25519)
+	ws := &WalletStore{Version: WALLET_STORE_VERSION}
+
+	byAddr, err := ws.AddWatch("shop", owner.Address, nil)
+	if err != nil {
+		t.Fatalf("AddWatch failed: %v", err)
+	}
+	byPubKey, err := ws.AddWatch("", "", edOwner.PublicKey)
+	if err != nil {
+		t.Fatalf("AddWatch failed: %v", err)
+	}
+	if !byAddr.WatchOnly || byPubKey.Wallet.Address != edOwner.Address || ws.Default != "" {
+		t.Errorf("Unexpected watch-only entries %+v, %+v (default %q)", byAddr, byPubKey, ws.Default)
+	}
+	if _, err := ws.AddWatch("", owner.Address, edOwner.PublicKey); err == nil {
+		t.Errorf("Public key of another address should be rejected")
+	}
+
+	if _, err := ws.Unlock([]string{"shop"}); !errors.Is(err, ErrWatchOnly) {
+		t.Errorf("Unlocking a watch-only entry should fail with ErrWatchOnly, got %v", err)
+	}
+	w := ws.Wallet(byPubKey)
+	if _, err := signWalletMessage(w, "hello"); !errors.Is(err, ErrWatchOnly) {
+		t.Errorf("Signing with a watch-only wallet should fail with ErrWatchOnly, got %v", err)
+	}
+	tx := Transaction{TxIns: []TxInput{{TxID: []byte{0x01}, TxOutIdx: 0, PubKey: w.PublicKey}}}
+	if err := tx.SignWith([]*Wallet{w}); !errors.Is(err, ErrWatchOnly) {
+		t.Errorf("Signing a transaction with a watch-only wallet should fail, got %v", err)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+)
+
+// Framing of the messages exchanged between the nodes, every message being prefixed by a header:
+//
+//	magic (4) || format (2) || command (12, NUL padded) || length (4) || checksum (4) -> header
+//	header || JSON of the `Message` -> frame of format 1
+//	header || flags (1) || binary `Message`, deflated if flagged -> frame of format 2
+//
+// Integers are big-endian and the checksum is the first 4 bytes of sha256(sha256(payload)).
+// The length lets both sides read messages of any size (up to MAX_MSG_SIZE) from a stream.
+//
+// The handshake is framed in JSON. Then the peers speaking protocol 2 exchange binary frames
+// (see `encodeBinaryMsg`), whose payload is compressed when it reaches the sender's
+// `compress_min_size` and the receiver announced it accepts compressed messages.
+
+const (
+	WIRE_PROTOCOL_VERSION     = uint16(2)
+	BINARY_PROTOCOL_VERSION   = uint16(2) // First protocol version speaking binary frames.
+	WIRE_FORMAT_JSON          = uint16(1)
+	WIRE_FORMAT_BINARY        = uint16(2)
+	WIRE_FLAG_DEFLATE         = byte(1 << 0)
+	WIRE_CMD_LEN              = 12
+	WIRE_CHECKSUM_LEN         = 4
+	WIRE_HEADER_LEN           = 4 + 2 + WIRE_CMD_LEN + 4 + WIRE_CHECKSUM_LEN
+	MAX_MSG_SIZE              = 32 * 1024 * 1024 // Largest payload accepted (32 MiB), once decompressed.
+	DEFAULT_COMPRESS_MIN_SIZE = 1024
+)
+
+var (
+	ErrBadMagic     = errors.New("message of another network")
+	ErrMsgTooLarge  = errors.New("message exceeds the maximum size")
+	ErrBadChecksum  = errors.New("message checksum mismatch")
+	ErrBadMsgHeader = errors.New("malformed message header")
+)
+
+// WireHeader is the header framing one message.
+type WireHeader struct {
+	Magic    uint32
+	Version  uint16 // Format of the payload (WIRE_FORMAT_*).
+	Cmd      string
+	Length   uint32
+	Checksum []byte
+}
+
+// WireCodec is the format of the frames written to a peer, negotiated in the version handshake.
+type WireCodec struct {
+	Binary      bool // Binary frames and compact blocks, JSON otherwise.
+	CompressMin int  // Size from which the payloads are compressed, 0 = never.
+}
+
+// wireConn is a connection writing the frames with the codec negotiated with its peer.
+type wireConn struct {
+	net.Conn
+	codec  WireCodec
+	peerID string // Identity of the peer authenticated by the connection.
+}
+
+// Utility functions start from here.
+
+// wireChecksum returns the checksum of a message's payload.
+func wireChecksum(payload []byte) []byte {
+	firstSHA := sha256.Sum256(payload)
+	secondSHA := sha256.Sum256(firstSHA[:])
+	return secondSHA[:WIRE_CHECKSUM_LEN]
+}
+
+// compressMinSize returns the size from which the local node compresses its messages,
+// 0 if it does not compress them (nor accepts compressed ones).
+func compressMinSize() int {
+	if cfg := getNetworkCfg(); cfg != nil && cfg.Network.CompressMinSize < 0 {
+		return 0
+	} else if cfg != nil && cfg.Network.CompressMinSize > 0 {
+		return cfg.Network.CompressMinSize
+	}
+	return DEFAULT_COMPRESS_MIN_SIZE
+}
+
+// negotiateWire returns the connection writing the frames in the richest format the peer,
+// of the given identity, speaks.
+func negotiateWire(conn net.Conn, peer *VersionPayload, peerID string) net.Conn {
+	var codec WireCodec
+	if peer.Protocol >= BINARY_PROTOCOL_VERSION {
+		codec.Binary = true
+		if peer.Compression {
+			codec.CompressMin = compressMinSize()
+		}
+	}
+	return &wireConn{Conn: conn, codec: codec, peerID: peerID}
+}
+
+// connCodec returns the codec of the frames written to the stream, JSON by default.
+func connCodec(w io.Writer) WireCodec {
+	if conn, ok := w.(interface{ Codec() WireCodec }); ok {
+		return conn.Codec()
+	}
+	return WireCodec{}
+}
+
+// connPeerID returns the identity of the peer authenticated by the connection, if known.
+func connPeerID(conn net.Conn) string {
+	if wc, ok := conn.(*wireConn); ok {
+		return wc.peerID
+	}
+	return ""
+}
+
+// writeMsg signs the message and writes it framed to the given stream,
+// with the codec negotiated with its peer.
+func writeMsg(w io.Writer, msg *Message) error {
+	if len(msg.Cmd) > WIRE_CMD_LEN {
+		return fmt.Errorf("%w: command %q is too long", ErrBadMsgHeader, msg.Cmd)
+	}
+	signed := *msg
+	signMsg(&signed, getNodeIdentity())
+	format, payload, err := encodeFrame(&signed, connCodec(w))
+	if err != nil {
+		return err
+	}
+	if len(payload) > MAX_MSG_SIZE {
+		return fmt.Errorf("%w: %d bytes", ErrMsgTooLarge, len(payload))
+	}
+
+	header := WireHeader{
+		Magic:    getChainParams().Magic,
+		Version:  format,
+		Cmd:      msg.Cmd,
+		Length:   uint32(len(payload)),
+		Checksum: wireChecksum(payload),
+	}
+	// One write per frame, so that concurrent writers cannot interleave their frames.
+	_, err = w.Write(append(header.Serialize(), payload...))
+	return err
+}
+
+// readMsg reads one framed message from the given stream, checking its header.
+func readMsg(r io.Reader) (*Message, error) {
+	rawHeader := make([]byte, WIRE_HEADER_LEN)
+	if _, err := io.ReadFull(r, rawHeader); err != nil {
+		return nil, err
+	}
+	header := deserializeWireHeader(rawHeader)
+	if header.Magic != getChainParams().Magic {
+		return nil, fmt.Errorf("%w: magic %08x, expected %08x (%s)",
+			ErrBadMagic, header.Magic, getChainParams().Magic, getChainParams().Name)
+	}
+	if header.Length > MAX_MSG_SIZE {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMsgTooLarge, header.Length)
+	}
+
+	payload := make([]byte, header.Length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(wireChecksum(payload), header.Checksum) {
+		return nil, fmt.Errorf("%w: %s", ErrBadChecksum, header.Cmd)
+	}
+
+	msg, err := decodeFrame(header.Version, payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadMsgHeader, err)
+	}
+	if msg.Cmd != header.Cmd {
+		return nil, fmt.Errorf("%w: command %q framed as %q", ErrBadMsgHeader, msg.Cmd, header.Cmd)
+	}
+	return msg, nil
+}
+
+// encodeFrame returns the format and payload of the frame of the message.
+func encodeFrame(msg *Message, codec WireCodec) (uint16, []byte, error) {
+	if !codec.Binary {
+		payload, err := json.Marshal(msg)
+		return WIRE_FORMAT_JSON, payload, err
+	}
+
+	body := encodeBinaryMsg(msg)
+	if codec.CompressMin > 0 && len(body) >= codec.CompressMin {
+		var deflated bytes.Buffer
+		deflated.WriteByte(WIRE_FLAG_DEFLATE)
+		fw, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+		fw.Write(body)
+		if err := fw.Close(); err != nil {
+			return 0, nil, err
+		}
+		// Incompressible payloads (eg: random data) are sent as is.
+		if deflated.Len() <= len(body) {
+			return WIRE_FORMAT_BINARY, deflated.Bytes(), nil
+		}
+	}
+	return WIRE_FORMAT_BINARY, append([]byte{0}, body...), nil
+}
+
+// decodeFrame decodes the message of a frame payload in the given format.
+func decodeFrame(format uint16, payload []byte) (*Message, error) {
+	switch format {
+	case WIRE_FORMAT_JSON:
+		msg := new(Message)
+		if err := json.Unmarshal(payload, msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	case WIRE_FORMAT_BINARY:
+		if len(payload) == 0 {
+			return nil, errors.New("missing flags")
+		}
+		flags, body := payload[0], payload[1:]
+		if flags&WIRE_FLAG_DEFLATE != 0 {
+			inflated, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(body)), MAX_MSG_SIZE+1))
+			if err != nil {
+				return nil, err
+			}
+			if len(inflated) > MAX_MSG_SIZE {
+				return nil, ErrMsgTooLarge
+			}
+			body = inflated
+		}
+		return decodeBinaryMsg(body)
+	}
+	return nil, fmt.Errorf("unknown format %d", format)
+}
+
+// encodeBinaryMsg returns the binary form of the message:
+//
+//	ID (4) || magic (4) || command || source address || source ID || timestamp (8) || nonce (8) || signature || data
+//
+// with the strings and the signature prefixed by their varint length, the data taking the rest.
+func encodeBinaryMsg(msg *Message) []byte {
+	w := new(compactWriter)
+	binary.Write(&w.buf, binary.BigEndian, msg.ID)
+	binary.Write(&w.buf, binary.BigEndian, msg.Magic)
+	for _, field := range []string{msg.Cmd, msg.Source.Address, msg.Source.ID} {
+		w.Uvarint(uint64(len(field)))
+		w.buf.WriteString(field)
+	}
+	binary.Write(&w.buf, binary.BigEndian, msg.Timestamp)
+	binary.Write(&w.buf, binary.BigEndian, msg.Nonce)
+	w.Uvarint(uint64(len(msg.Signature)))
+	w.buf.Write(msg.Signature)
+	w.buf.Write(msg.Data)
+	return w.buf.Bytes()
+}
+
+// decodeBinaryMsg decodes the binary form of a message.
+func decodeBinaryMsg(body []byte) (*Message, error) {
+	if len(body) < 8 {
+		return nil, ErrMalformedCompact
+	}
+	msg := &Message{
+		ID:    binary.BigEndian.Uint32(body[0:4]),
+		Magic: binary.BigEndian.Uint32(body[4:8]),
+	}
+	r := newCompactReader(body[8:])
+	var fields [3]string
+	for i := range fields {
+		n := r.Count()
+		raw := make([]byte, n)
+		if _, err := io.ReadFull(r.r, raw); err != nil || r.err != nil {
+			return nil, ErrMalformedCompact
+		}
+		fields[i] = string(raw)
+	}
+	msg.Cmd, msg.Source.Address, msg.Source.ID = fields[0], fields[1], fields[2]
+	if binary.Read(r.r, binary.BigEndian, &msg.Timestamp) != nil || binary.Read(r.r, binary.BigEndian, &msg.Nonce) != nil {
+		return nil, ErrMalformedCompact
+	}
+	if n := r.Count(); n > 0 {
+		msg.Signature = make([]byte, n)
+		r.r.Read(msg.Signature)
+	}
+	if r.err != nil {
+		return nil, ErrMalformedCompact
+	}
+	msg.Data = make([]byte, r.r.Len())
+	r.r.Read(msg.Data)
+	return msg, nil
+}
+
+// deserializeWireHeader decodes a header of WIRE_HEADER_LEN bytes.
+func deserializeWireHeader(raw []byte) *WireHeader {
+	cmd := raw[6 : 6+WIRE_CMD_LEN]
+	return &WireHeader{
+		Magic:    binary.BigEndian.Uint32(raw[0:4]),
+		Version:  binary.BigEndian.Uint16(raw[4:6]),
+		Cmd:      string(bytes.TrimRight(cmd, "\x00")),
+		Length:   binary.BigEndian.Uint32(raw[6+WIRE_CMD_LEN : 10+WIRE_CMD_LEN]),
+		Checksum: raw[10+WIRE_CMD_LEN:],
+	}
+}
+
+// wireConn's methods:
+
+func (conn *wireConn) Codec() WireCodec {
+	return conn.codec
+}
+
+// WireHeader's methods:
+
+// Serialize encodes the header into WIRE_HEADER_LEN bytes.
+func (header *WireHeader) Serialize() []byte {
+	raw := make([]byte, WIRE_HEADER_LEN)
+	binary.BigEndian.PutUint32(raw[0:4], header.Magic)
+	binary.BigEndian.PutUint16(raw[4:6], header.Version)
+	copy(raw[6:6+WIRE_CMD_LEN], header.Cmd)
+	binary.BigEndian.PutUint32(raw[6+WIRE_CMD_LEN:10+WIRE_CMD_LEN], header.Length)
+	copy(raw[10+WIRE_CMD_LEN:], header.Checksum)
+	return raw
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWireRoundTrip(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	// A payload far above the former 1 KB read, delivered one byte at a time.
+	msg := &Message{Magic: getChainParams().Magic, Cmd: CResBlock, Data: bytes.Repeat([]byte{0xab}, 2*1024*1024)}
+	var stream bytes.Buffer
+	if err := writeMsg(&stream, msg); err != nil {
+		t.Fatalf("writeMsg failed: %v", err)
+	}
+	if err := writeMsg(&stream, &Message{Cmd: CReqDepth}); err != nil {
+		t.Fatalf("writeMsg failed: %v", err)
+	}
+
+	reader := iotest.OneByteReader(&stream)
+	received, err := readMsg(reader)
+	if err != nil {
+		t.Fatalf("readMsg failed: %v", err)
+	}
+	if received.Cmd != msg.Cmd || !bytes.Equal(received.Data, msg.Data) {
+		t.Errorf("Received message differs from the sent one")
+	}
+	if next, err := readMsg(reader); err != nil || next.Cmd != CReqDepth {
+		t.Errorf("Expected the next framed message, got %v (%v)", next, err)
+	}
+}
+
+func TestWireRejectsBadFrames(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	var stream bytes.Buffer
+	writeMsg(&stream, &Message{Cmd: CAddTx, Data: []byte("tx")})
+	frame := stream.Bytes()
+
+	corrupted := append([]byte{}, frame...)
+	corrupted[len(corrupted)-2] ^= 0x01
+	if _, err := readMsg(bytes.NewReader(corrupted)); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Corrupted payload should fail the checksum, got %v", err)
+	}
+
+	foreign := append([]byte{}, frame...)
+	foreign[0] ^= 0xff
+	if _, err := readMsg(bytes.NewReader(foreign)); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("Message of another network should be rejected, got %v", err)
+	}
+
+	oversized := &WireHeader{Magic: getChainParams().Magic, Cmd: CAddTx, Length: MAX_MSG_SIZE + 1}
+	if _, err := readMsg(bytes.NewReader(oversized.Serialize())); !errors.Is(err, ErrMsgTooLarge) {
+		t.Errorf("Oversized message should be rejected before reading it, got %v", err)
+	}
+}
+
+func TestReqNeighborLargeBlock(t *testing.T) {
+	GenerateLogger(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	setTestConfig(t, &Config{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Cannot listen: %v", err)
+	}
+	defer listener.Close()
+	defer closePeerClients()
+
+	data := bytes.Repeat([]byte("block"), 100*1024)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if conn, err = acceptTestPeer(conn, testPeerVersion()); err == nil {
+			if msg, err := readMsg(conn); err == nil {
+				respond(&reqConn{Conn: conn, id: msg.ID}, &Message{Cmd: CResBlock, Data: data})
+			}
+		}
+	}()
+
+	res, err := reqNeighbor(&Message{Cmd: CReqBlock, Data: Itobytes(1)}, Node{Address: listener.Addr().String()})
+	if err != nil {
+		t.Fatalf("reqNeighbor failed: %v", err)
+	}
+	if !bytes.Equal(res.Data, data) {
+		t.Errorf("Expected %d bytes of block, got %d", len(data), len(res.Data))
+	}
+}